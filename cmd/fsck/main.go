@@ -0,0 +1,30 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/connnorchen/MyDb/internal/kvstore"
+)
+
+// check a database file for structural damage, e.g. fsck ./db
+// the exit status is 1 if problems are found, 2 if the file can't be read.
+func main() {
+    if len(os.Args) != 2 {
+        fmt.Fprintf(os.Stderr, "usage: %s <database file>\n", os.Args[0])
+        os.Exit(2)
+    }
+    problems, err := kvstore.Verify(os.Args[1])
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "err in verify: %s\n", err.Error())
+        os.Exit(2)
+    }
+    for _, problem := range problems {
+        fmt.Println(problem.Error())
+    }
+    if len(problems) > 0 {
+        fmt.Printf("%d problems found\n", len(problems))
+        os.Exit(1)
+    }
+    fmt.Println("no problems found")
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/connnorchen/MyDb/internal/kvstore"
+	"github.com/connnorchen/MyDb/internal/resp"
+)
+
+// serve a database file over the Redis protocol, e.g.
+// resp-server -db ./db -addr 127.0.0.1:6379 && redis-cli set k v
+func main() {
+    path := flag.String("db", "db", "database file")
+    addr := flag.String("addr", "127.0.0.1:6379", "listen address")
+    useWAL := flag.Bool("wal", false, "use a write-ahead log with group commit")
+    flag.Parse()
+
+    db := kvstore.KV{Path: *path, WAL: *useWAL}
+    if err := db.Open(); err != nil {
+        fmt.Fprintf(os.Stderr, "err in open: %s\n", err.Error())
+        os.Exit(1)
+    }
+    defer db.Close()
+
+    l, err := net.Listen("tcp", *addr)
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "err in listen: %s\n", err.Error())
+        db.Close()
+        os.Exit(1)
+    }
+    srv := &resp.Server{DB: &db}
+
+    // shut down cleanly so that no request is cut in the middle
+    sig := make(chan os.Signal, 1)
+    signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+    go func() {
+        <-sig
+        srv.Close()
+    }()
+
+    fmt.Printf("listening on %s\n", l.Addr())
+    if err := srv.Serve(l); err != nil {
+        fmt.Fprintf(os.Stderr, "err in serve: %s\n", err.Error())
+    }
+    srv.Close() // wait for the connections before closing the DB
+}
@@ -13,20 +13,35 @@ import (
 
 // | klen | vlen  |  key |  val |
 // | 2B   | 2B    | ...  |  ... |
+// the highest bit of vlen marks a value stored in overflow pages, see
+// overflow.go
+// the value of an internal node is either empty or the number of keys in
+// the kid (8B), see count.go
 type BNode struct {
     Data []byte // can be dumped to disk
 }
 
 const (
-    BNODE_NODE = 1 // internal node without value
+    BNODE_NODE = 1 // internal node, the values are optional key counts
     BNODE_LEAF = 2 // leaf node with value
+    BNODE_OVERFLOW = 3 // part of a large value
 )
 
 const (
     HEADER = 4 // type + nkeys
     BTREE_PAGE_SIZE = 4096
+    // the end of each page is reserved for the storage, e.g. a checksum
+    BTREE_PAGE_TRAILER = 4
+    BTREE_NODE_SIZE = BTREE_PAGE_SIZE - BTREE_PAGE_TRAILER // usable bytes
     BTREE_MAX_KEY_SIZE = 1000
+    // larger values are moved to overflow pages
     BTREE_MAX_VALUE_SIZE = 3000
+    BTREE_MAX_LARGE_VALUE_SIZE = 64 << 20
+)
+
+const (
+    VLEN_OVERFLOW = 0x8000 // vlen flag
+    VLEN_MASK = 0x7fff
 )
 
 type BTree struct {
@@ -36,13 +51,16 @@ type BTree struct {
     Get func(uint64) BNode // dereference a pointer
     New func(BNode) uint64 // allocate a New page
     Del func(uint64)       // deallocate a New page
+    // internal nodes store the number of keys of each kid, it must match
+    // the existing nodes
+    Counted bool
 
     mockNodeList []BNode   // for testing usage
 }
 
 func init() { 
     node1max := HEADER + 8 + 2 + 4 + BTREE_MAX_KEY_SIZE + BTREE_MAX_VALUE_SIZE
-    util.Assert(node1max <= BTREE_PAGE_SIZE)
+    util.Assert(node1max <= BTREE_NODE_SIZE)
 }
 
 // decoding BNode
@@ -120,10 +138,24 @@ func (node BNode) getVal(idx uint16) []byte {
     util.Assert(idx < node.nkeys())
     kvPos := node.kvPos(idx)
     keyLength := binary.LittleEndian.Uint16(node.Data[kvPos:])
-    valLength := binary.LittleEndian.Uint16(node.Data[kvPos + 2:])
+    valLength := binary.LittleEndian.Uint16(node.Data[kvPos + 2:]) & VLEN_MASK
     return node.Data[kvPos+4+keyLength:][:valLength]
 }
 
+// the value is a reference to overflow pages
+func (node BNode) isOverflow(idx uint16) bool {
+    util.Assert(idx < node.nkeys())
+    kvPos := node.kvPos(idx)
+    return binary.LittleEndian.Uint16(node.Data[kvPos + 2:]) & VLEN_OVERFLOW != 0
+}
+
+func (node BNode) setOverflow(idx uint16) {
+    util.Assert(idx < node.nkeys())
+    kvPos := node.kvPos(idx)
+    vlen := binary.LittleEndian.Uint16(node.Data[kvPos + 2:])
+    binary.LittleEndian.PutUint16(node.Data[kvPos + 2:], vlen | VLEN_OVERFLOW)
+}
+
 func (node BNode) nbytes() uint16 {
     return node.kvPos(node.nkeys())
 }
@@ -132,16 +164,19 @@ func (node BNode) nbytes() uint16 {
 // The btree will shrink if these two conditions satisfied:
 // 1. The Root node is not a leaf.
 // 2. The Root node has only one child.
-func (tree *BTree) DeleteKey(key []byte) bool {
-    util.Assert(len(key) != 0)
-    util.Assert(len(key) <= BTREE_MAX_KEY_SIZE)
-    
+// On ErrCorruptPage the tree may be partially updated and must be discarded.
+func (tree *BTree) DeleteKey(key []byte) (deleted bool, err error) {
+    if err := checkKey(key); err != nil {
+        return false, err
+    }
     if tree.Root == 0 {
-        return false
+        return false, nil
     }
-    updated := treeDelete(tree, tree.Get(tree.Root), key)
+    defer recoverPageError(&err)
+
+    updated := treeDelete(tree, tree.get(tree.Root), key)
     if len(updated.Data) == 0 {
-        return false // not found
+        return false, nil // not found
     }
     tree.Del(tree.Root)
     
@@ -150,31 +185,82 @@ func (tree *BTree) DeleteKey(key []byte) bool {
     } else {
         tree.Root = tree.New(updated)
     }
-    return true
+    return true, nil
 }
 
-// Return true if btree successfully inserted the key, val pair
-func (tree *BTree) Insert(key []byte, val []byte) {
-    util.Assert(len(key) != 0)
-    util.Assert(len(key) <= BTREE_MAX_KEY_SIZE)
-    util.Assert(len(val) <= BTREE_MAX_VALUE_SIZE)
-    
+// Insert or update the key, val pair
+// On ErrCorruptPage the tree may be partially updated and must be discarded.
+func (tree *BTree) Insert(key []byte, val []byte) error {
+    return tree.insert(&insertReq{key: key, val: val, mode: MODE_UPSERT})
+}
+
+// InsertEx modes
+const (
+    MODE_UPSERT = 0      // insert or update
+    MODE_INSERT_ONLY = 1 // ErrKeyExists if the key exists
+    MODE_UPDATE_ONLY = 2 // ErrKeyNotFound if the key doesn't exist
+)
+
+type InsertResult struct {
+    Added   bool   // a new key
+    Updated bool   // an existing key, including with the same value
+    Old     []byte // the previous value of an existing key
+}
+
+// insert or update the key depending on the mode. the tree is unchanged if
+// the mode fails, the result still reports the existing value.
+// On ErrCorruptPage the tree may be partially updated and must be discarded.
+func (tree *BTree) InsertEx(key []byte, val []byte, mode int) (InsertResult, error) {
+    req := insertReq{key: key, val: val, mode: mode, withOld: true}
+    err := tree.insert(&req)
+    return req.res, err
+}
+
+// an update passed down to the leaf, which checks the mode and fills in
+// the result in the same lookup.
+type insertReq struct {
+    key     []byte
+    val     []byte
+    mode    int
+    withOld bool         // copy the previous value into res.Old
+    res     InsertResult
+    err     error        // the mode failed, nothing was changed
+}
+
+// insert or update the key from the root
+func (tree *BTree) insert(req *insertReq) (err error) {
+    if err := checkKey(req.key); err != nil {
+        return err
+    }
+    if err := checkVal(req.val); err != nil {
+        return err
+    }
+    defer recoverPageError(&err)
+
     if tree.Root == 0 {
+        if req.mode == MODE_UPDATE_ONLY {
+            return ErrKeyNotFound
+        }
         // first key ever possible
         Root := BNode{Data: make([]byte, BTREE_PAGE_SIZE)}
 
         // create a dummy node to pass LE check
         Root.setHeader(BNODE_LEAF, 2)
         nodeAppendKV(Root, 0, 0, nil, nil)
-        nodeAppendKV(Root, 1, 0, key, val)
+        nodeAppendKV(Root, 1, 0, req.key, leafStoreVal(tree, req.val))
+        if len(req.val) > BTREE_MAX_VALUE_SIZE {
+            Root.setOverflow(1)
+        }
         tree.Root = tree.New(Root)
-        return
+        req.res.Added = true
+        return nil
     }
-    
-    Root := tree.Get(tree.Root)
-    tree.Del(tree.Root)
 
-    newRoot := treeInsert(tree, Root, key, val)
+    newRoot := treeInsert(tree, tree.get(tree.Root), req)
+    if len(newRoot.Data) == 0 {
+        return req.err
+    }
+    tree.Del(tree.Root)
     nsplit, splited := nodeSplit3(newRoot)
     if nsplit > 1 {
         finalRoot := BNode{Data: make([]byte, BTREE_PAGE_SIZE)}
@@ -182,21 +268,25 @@ func (tree *BTree) Insert(key []byte, val []byte) {
         for i, node := range splited[:nsplit] {
             nodeAppendKV(
                 finalRoot, uint16(i),
-                tree.New(node), node.getKey(0), nil,
+                tree.New(node), node.getKey(0), tree.kidVal(node),
             )
         }
         tree.Root = tree.New(finalRoot)
     } else {
         tree.Root = tree.New(splited[0])
     }
+    return nil
 }
 
-func (tree *BTree) GetKey(key []byte) ([]byte, bool) {
-    util.Assert(len(key) != 0)
-    util.Assert(len(key) <= BTREE_MAX_KEY_SIZE)
+func (tree *BTree) GetKey(key []byte) (val []byte, found bool, err error) {
+    if err := checkKey(key); err != nil {
+        return nil, false, err
+    }
     if tree.Root == 0 {
-        return []byte(nil), false
+        return []byte(nil), false, nil
     }
-    root := tree.Get(tree.Root)
-    return treeGet(tree, root, key)
+    defer recoverPageError(&err)
+    root := tree.get(tree.Root)
+    val, found = treeGet(tree, root, key)
+    return val, found, nil
 }
@@ -128,16 +128,26 @@ func newC() *C {
     }
 }
 
+func mustDelete(t *testing.T, tree *BTree, key []byte) bool {
+    deleted, err := tree.DeleteKey(key)
+    assert.Nil(t, err)
+    return deleted
+}
+
 func TestBTreeInsert(t *testing.T) {
     container := newC()
     assert.Equal(t, container.tree.Root, uint64(0))
     
     // edge case checking
     keyTooLong := make([]byte, 1001)
-    assert.Panics(t, func() {container.tree.Insert(keyTooLong, nil)})
-    valTooLong := make([]byte, 3001)
-    assert.Panics(t, func() {container.tree.Insert([]byte{byte(0)}, valTooLong)})
-    assert.Panics(t, func() {container.tree.Insert(nil, nil)})
+    assert.ErrorIs(t, container.tree.Insert(keyTooLong, nil), ErrKeyTooLarge)
+    valTooLong := make([]byte, BTREE_MAX_LARGE_VALUE_SIZE + 1)
+    assert.ErrorIs(
+        t,
+        container.tree.Insert([]byte{byte(0)}, valTooLong),
+        ErrValueTooLarge,
+    )
+    assert.ErrorIs(t, container.tree.Insert(nil, nil), ErrEmptyKey)
     
     // add a long key, val pair
     //          Root: 0, 5
@@ -253,9 +263,11 @@ func TestBTreeDeleteKey(t *testing.T) {
     
     // edge cases 
     keyTooLong := make([]byte, 1001)
-    assert.Panics(t, func() {container.tree.DeleteKey(keyTooLong)})
-    assert.Panics(t, func() {container.tree.DeleteKey(nil)})
-    assert.False(t, container.tree.DeleteKey([]byte{byte(0)}))
+    _, err := container.tree.DeleteKey(keyTooLong)
+    assert.ErrorIs(t, err, ErrKeyTooLarge)
+    _, err = container.tree.DeleteKey(nil)
+    assert.ErrorIs(t, err, ErrEmptyKey)
+    assert.False(t, mustDelete(t, &container.tree, []byte{byte(0)}))
 
     
     key5 := make([]byte, 1000)
@@ -265,9 +277,9 @@ func TestBTreeDeleteKey(t *testing.T) {
     // Root: 0, 5
     container.tree.Insert(key5, val5)
     // does not exist
-    assert.False(t, container.tree.DeleteKey([]byte{byte(100)}))
+    assert.False(t, mustDelete(t, &container.tree, []byte{byte(100)}))
     // Root: 0, 5
-    assert.True(t, container.tree.DeleteKey(key5)) 
+    assert.True(t, mustDelete(t, &container.tree, key5)) 
     Root := container.tree.Get(container.tree.Root)
     assert.Equal(t, Root.btype(), uint16(BNODE_LEAF))
     assert.Equal(t, Root.nkeys(), uint16(1))
@@ -287,7 +299,7 @@ func TestBTreeDeleteKey(t *testing.T) {
     
     // Root will downlevel
     //     Root: 0, 5
-    assert.True(t, container.tree.DeleteKey(key7))
+    assert.True(t, mustDelete(t, &container.tree, key7))
     Root = container.tree.Get(container.tree.Root)
     assert.Equal(t, Root.btype(), uint16(BNODE_LEAF))
     assert.Equal(t, Root.nkeys(), uint16(2))
@@ -299,7 +311,7 @@ func TestBTreeDeleteKey(t *testing.T) {
     container.tree.Insert(key7, val7)
 
     // Root: 0, 7, Root will downlevel and 0, 7 will merge
-    assert.True(t, container.tree.DeleteKey(key5)) 
+    assert.True(t, mustDelete(t, &container.tree, key5)) 
     Root = container.tree.Get(container.tree.Root)
     assert.Equal(t, Root.btype(), uint16(BNODE_LEAF))
     assert.Equal(t, Root.nkeys(), uint16(2))
@@ -316,7 +328,7 @@ func TestBTreeDeleteKey(t *testing.T) {
 
     // 2:          Root: 0, 9
     //     left: 0, 5     right: 9
-    assert.True(t, container.tree.DeleteKey(key7)) 
+    assert.True(t, mustDelete(t, &container.tree, key7)) 
     Root = container.tree.Get(container.tree.Root)
     assert.Equal(t, Root.nkeys(), uint16(2))
 }
@@ -325,9 +337,12 @@ func TestTreeGetKey(t *testing.T) {
     container := newC()
     // edge cases 
     keyTooLong := make([]byte, 1001)
-    assert.Panics(t, func() {container.tree.GetKey(keyTooLong)})
-    assert.Panics(t, func() {container.tree.GetKey(nil)})
-    val, exist := container.tree.GetKey([]byte{byte(0)})
+    _, _, err := container.tree.GetKey(keyTooLong)
+    assert.ErrorIs(t, err, ErrKeyTooLarge)
+    _, _, err = container.tree.GetKey(nil)
+    assert.ErrorIs(t, err, ErrEmptyKey)
+    val, exist, err := container.tree.GetKey([]byte{byte(0)})
+    assert.Nil(t, err)
     assert.Equal(t, val, []byte(nil))
     assert.False(t, exist)
 
@@ -335,12 +350,14 @@ func TestTreeGetKey(t *testing.T) {
     val = []byte("world")
     
     container.tree.Insert(key, val)
-    resVal, exist := container.tree.GetKey(key)
+    resVal, exist, err := container.tree.GetKey(key)
+    assert.Nil(t, err)
     assert.Equal(t, resVal, val)
     assert.True(t, exist)
 
     notExistKey := []byte("hello1")
-    resVal, exist = container.tree.GetKey(notExistKey)
+    resVal, exist, err = container.tree.GetKey(notExistKey)
+    assert.Nil(t, err)
     assert.Equal(t, resVal, []byte(nil))
     assert.False(t, exist)
 }
@@ -0,0 +1,146 @@
+package b_tree
+
+import (
+	"bytes"
+	"errors"
+
+	"github.com/connnorchen/MyDb/internal/util"
+)
+
+var ErrKeyOrder = errors.New("keys not in ascending order")
+
+// builds a tree bottom-up from keys in ascending order. nodes are packed
+// full and allocated once, instead of rewriting a root-to-leaf path for
+// each Insert.
+// the node being filled at each level is kept in memory, when it's full it
+// is allocated and its first key is added to the level above.
+type BulkLoader struct {
+    tree   *BTree
+    levels []bulkLevel // the leaves first
+    nkeys  int         // number of keys added
+    last   []byte      // the last key added
+    err    error       // the loader is unusable after an error
+}
+
+// the pending node of a level
+type bulkLevel struct {
+    entries []bulkEntry
+    size    int // nbytes of the node
+}
+
+type bulkEntry struct {
+    key      []byte
+    val      []byte // the stored value, possibly an overflow reference, or
+                    // the key count of an internal node
+    ptr      uint64
+    overflow bool
+}
+
+// start loading into an empty tree, the tree is not usable until Finish
+func (tree *BTree) NewBulkLoader() *BulkLoader {
+    util.Assert(tree.Root == 0)
+    bl := &BulkLoader{tree: tree}
+    // the dummy first key like in Insert
+    bl.push(0, bulkEntry{key: []byte{}})
+    return bl
+}
+
+// add the next key, it must be greater than the previous one
+func (bl *BulkLoader) Add(key []byte, val []byte) (err error) {
+    if bl.err != nil {
+        return bl.err
+    }
+    if err := checkKey(key); err != nil {
+        return err
+    }
+    if err := checkVal(val); err != nil {
+        return err
+    }
+    if bl.nkeys > 0 && bytes.Compare(key, bl.last) <= 0 {
+        return ErrKeyOrder
+    }
+    // a corrupt page can leave the loader half updated
+    defer func() { bl.err = err }()
+    defer recoverPageError(&err)
+
+    entry := bulkEntry{
+        key: append([]byte(nil), key...),
+        overflow: len(val) > BTREE_MAX_VALUE_SIZE,
+    }
+    if entry.overflow {
+        entry.val = overflowWrite(bl.tree, val)
+    } else {
+        entry.val = append([]byte(nil), val...)
+    }
+    bl.push(0, entry)
+    bl.nkeys++
+    bl.last = entry.key
+    return nil
+}
+
+// add an entry to a level, the pending node is written if it's full.
+// every node gets at least 1 entry, and the first one at level 0 is the
+// dummy key.
+func (bl *BulkLoader) push(level int, entry bulkEntry) {
+    if level == len(bl.levels) {
+        bl.levels = append(bl.levels, bulkLevel{size: HEADER})
+    }
+    size := 8 + 2 + 4 + len(entry.key) + len(entry.val)
+    if bl.levels[level].size + size > BTREE_NODE_SIZE {
+        bl.flush(level)
+    }
+    // flushing can add a level and move `levels`
+    lv := &bl.levels[level]
+    lv.entries = append(lv.entries, entry)
+    lv.size += size
+}
+
+// write the pending node of a level and link it to the level above
+func (bl *BulkLoader) flush(level int) {
+    lv := &bl.levels[level]
+    node := bulkNode(level, lv.entries)
+    first := lv.entries[0].key
+    lv.entries, lv.size = nil, HEADER
+    bl.push(level + 1, bulkEntry{
+        key: first, ptr: bl.tree.New(node), val: bl.tree.kidVal(node),
+    })
+}
+
+func bulkNode(level int, entries []bulkEntry) BNode {
+    node := BNode{Data: make([]byte, BTREE_PAGE_SIZE)}
+    btype := uint16(BNODE_LEAF)
+    if level > 0 {
+        btype = BNODE_NODE
+    }
+    node.setHeader(btype, uint16(len(entries)))
+    for i, entry := range entries {
+        nodeAppendKV(node, uint16(i), entry.ptr, entry.key, entry.val)
+        if entry.overflow {
+            node.setOverflow(uint16(i))
+        }
+    }
+    return node
+}
+
+// write the remaining nodes and set the root of the tree.
+// an empty input leaves the tree empty.
+func (bl *BulkLoader) Finish() (err error) {
+    if bl.err != nil {
+        return bl.err
+    }
+    if bl.nkeys == 0 {
+        return nil
+    }
+    defer recoverPageError(&err)
+    // the levels can grow while flushing
+    for level := 0; level < len(bl.levels); level++ {
+        if level == len(bl.levels) - 1 {
+            lv := bl.levels[level]
+            bl.tree.Root = bl.tree.New(bulkNode(level, lv.entries))
+            break
+        }
+        bl.flush(level)
+    }
+    bl.err = errors.New("bulk loader finished")
+    return nil
+}
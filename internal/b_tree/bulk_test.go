@@ -0,0 +1,100 @@
+package b_tree
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBulkLoad(t *testing.T) {
+    for _, n := range []int{0, 1, 10, 1000, 20000} {
+        tree, pages := newVerifyTree(t, 0)
+        tree.Root = 0
+        bl := tree.NewBulkLoader()
+        for i := 0; i < n; i++ {
+            key := []byte(fmt.Sprintf("key%06d", i))
+            val := []byte(fmt.Sprintf("val%d", i))
+            if i % 500 == 7 {
+                val = largeVal(5000 + i, byte(i))
+            }
+            assert.Nil(t, bl.Add(key, val))
+        }
+        assert.Nil(t, bl.Finish())
+        if n == 0 {
+            assert.Equal(t, tree.Root, uint64(0))
+            continue
+        }
+        assert.Empty(t, verifyAll(tree, len(*pages)))
+
+        // iterate over everything
+        iter := tree.SeekLE([]byte("key"))
+        iter.Next()
+        for i := 0; i < n; i++ {
+            assert.True(t, iter.Valid())
+            assert.Equal(t, iter.Key(), []byte(fmt.Sprintf("key%06d", i)))
+            if i % 500 == 7 {
+                assert.True(t, bytes.Equal(iter.Val(), largeVal(5000 + i, byte(i))))
+            } else {
+                assert.Equal(t, iter.Val(), []byte(fmt.Sprintf("val%d", i)))
+            }
+            iter.Next()
+        }
+        assert.False(t, iter.Valid())
+
+        // the tree can be updated normally
+        assert.Nil(t, tree.Insert([]byte("key000000x"), []byte("x")))
+        assert.True(t, mustDelete(t, tree, []byte("key000000")))
+        assert.Empty(t, verifyAll(tree, len(*pages)))
+    }
+}
+
+func TestBulkLoadPacked(t *testing.T) {
+    tree, _ := newVerifyTree(t, 0)
+    tree.Root = 0
+    bl := tree.NewBulkLoader()
+    for i := 0; i < 10000; i++ {
+        assert.Nil(t, bl.Add([]byte(fmt.Sprintf("key%06d", i)), make([]byte, 100)))
+    }
+    assert.Nil(t, bl.Finish())
+    // leaves are full, except the last one
+    root := tree.Get(tree.Root)
+    for i := uint16(0); i + 1 < root.nkeys(); i++ {
+        kid := tree.Get(root.getPtr(i))
+        if kid.btype() == BNODE_LEAF {
+            assert.Greater(t, int(kid.nbytes()), BTREE_NODE_SIZE - 200)
+        }
+    }
+}
+
+func TestBulkLoadErrors(t *testing.T) {
+    tree, _ := newVerifyTree(t, 0)
+    tree.Root = 0
+    bl := tree.NewBulkLoader()
+    assert.Nil(t, bl.Add([]byte("b"), nil))
+    assert.ErrorIs(t, bl.Add([]byte("b"), nil), ErrKeyOrder)
+    assert.ErrorIs(t, bl.Add([]byte("a"), nil), ErrKeyOrder)
+    assert.ErrorIs(t, bl.Add(nil, nil), ErrEmptyKey)
+    assert.ErrorIs(t, bl.Add([]byte("c"), make([]byte, BTREE_MAX_LARGE_VALUE_SIZE + 1)), ErrValueTooLarge)
+    // bad input doesn't stop the loader
+    assert.Nil(t, bl.Add([]byte("c"), nil))
+    assert.Nil(t, bl.Finish())
+    _, found, _ := tree.GetKey([]byte("c"))
+    assert.True(t, found)
+}
+
+func TestDrop(t *testing.T) {
+    container := newC()
+    for i := 0; i < 1000; i++ {
+        key := []byte(fmt.Sprintf("key%04d", i))
+        val := make([]byte, 100)
+        if i % 100 == 0 {
+            val = largeVal(10000, 1)
+        }
+        assert.Nil(t, container.tree.Insert(key, val))
+    }
+    assert.Nil(t, container.tree.Drop())
+    assert.Equal(t, container.tree.Root, uint64(0))
+    assert.Empty(t, container.pages)
+}
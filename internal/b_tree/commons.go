@@ -26,7 +26,8 @@ func nodeLookLE(node BNode, key []byte) uint16 {
             return left
         }
         if left == 0 {
-            panic("trying to find a number that is less than all numbers")
+            // the parent routed the key to this node by its first key
+            panic(corruption("key is less than the first key of the node"))
         }
         return left - 1
     } else if bytes.Compare(node.getKey(right), key) >= 0 {
@@ -39,6 +40,19 @@ func nodeLookLE(node BNode, key []byte) uint16 {
     return right
 }
 
+// the first position whose key is greater or equal to the input key,
+// nkeys if there is none
+func nodeLookGE(node BNode, key []byte) uint16 {
+    if bytes.Compare(node.getKey(0), key) >= 0 {
+        return 0
+    }
+    idx := nodeLookLE(node, key)
+    if bytes.Equal(node.getKey(idx), key) {
+        return idx
+    }
+    return idx + 1
+}
+
 func nodeAppendRange(
     new BNode, old BNode, 
     dstNew uint16, srcOld uint16, n uint16,
@@ -90,13 +104,13 @@ func splitFromIdxFitInOnePage(node BNode, idx uint16) bool {
 
     nkeys := node.nkeys() - idx
     kvSize := node.nbytes() - node.kvPos(idx)
-    return (HEADER + nkeys * 8 + nkeys * 2 + kvSize) <= BTREE_PAGE_SIZE
+    return (HEADER + nkeys * 8 + nkeys * 2 + kvSize) <= BTREE_NODE_SIZE
 }
 
 // split a bigger-than-allowed node into two.
 // the second node always fits on a page
 func nodeSplit2(left BNode, right BNode, old BNode) {
-    // binary search on old node to find the biggest kvPos < BTREE_PAGE_SIZE
+    // binary search on old node to find the biggest kvPos < BTREE_NODE_SIZE
     l := uint16(0)
     r := old.nkeys() - 1
     for l + 1 < r {
@@ -123,7 +137,7 @@ func nodeSplit2(left BNode, right BNode, old BNode) {
 
 // split a node if it's too big, the results are 1~3 nodes
 func nodeSplit3(old BNode) (uint16, [3]BNode) {
-    if old.nbytes() <= BTREE_PAGE_SIZE {
+    if old.nbytes() <= BTREE_NODE_SIZE {
         old.Data = old.Data[:BTREE_PAGE_SIZE]
         return 1, [3]BNode{old}
     }
@@ -131,7 +145,7 @@ func nodeSplit3(old BNode) (uint16, [3]BNode) {
     left := BNode{make([]byte, 2 * BTREE_PAGE_SIZE)}
     right := BNode{make([]byte, BTREE_PAGE_SIZE)}
     nodeSplit2(left, right, old)
-    if left.nbytes() <= BTREE_PAGE_SIZE {
+    if left.nbytes() <= BTREE_NODE_SIZE {
         left.Data = left.Data[:BTREE_PAGE_SIZE]
         return 2, [3]BNode{left, right}
     }
@@ -140,7 +154,7 @@ func nodeSplit3(old BNode) (uint16, [3]BNode) {
     leftleft := BNode{make([]byte, BTREE_PAGE_SIZE)}
     leftright := BNode{make([]byte, BTREE_PAGE_SIZE)}
     nodeSplit2(leftleft, leftright, left)
-    util.Assert(leftleft.nbytes() <= BTREE_PAGE_SIZE)
+    util.Assert(leftleft.nbytes() <= BTREE_NODE_SIZE)
     return 3, [3]BNode{leftleft, leftright, right}
 }
 
@@ -153,7 +167,9 @@ func nodeReplaceKidN(
     new.setHeader(BNODE_NODE, old.nkeys() + inc - 1)
     nodeAppendRange(new, old, 0, 0, idx)
     for i, node := range kids {
-        nodeAppendKV(new, uint16(i) + idx, tree.New(node), node.getKey(0), nil)
+        nodeAppendKV(
+            new, uint16(i) + idx, tree.New(node), node.getKey(0), tree.kidVal(node),
+        )
     }
     nodeAppendRange(new, old, idx + inc, idx + 1, old.nkeys() - idx - 1)
 }
@@ -161,10 +177,10 @@ func nodeReplaceKidN(
 // discarding idx and idx + 1, place in ptr into idx
 func nodeReplace2Kid(
     new BNode, node BNode, idx uint16, ptr uint64, 
-    key []byte,
+    key []byte, val []byte,
 ) {
     new.setHeader(BNODE_NODE, node.nkeys() - 1)
     nodeAppendRange(new, node, 0, 0, idx)
-    nodeAppendKV(new, idx, ptr, key, nil)
+    nodeAppendKV(new, idx, ptr, key, val)
     nodeAppendRange(new, node, idx + 1, idx + 2, node.nkeys() - idx - 2)
 }
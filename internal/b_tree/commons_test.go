@@ -427,7 +427,7 @@ func TestNodeReplace2Kid(t *testing.T) {
     }
     new := BNode{Data: make([]byte, BTREE_PAGE_SIZE)}
     // 0, 1, 6, 8
-    nodeReplace2Kid(new, old, 1, 123444, []byte{byte(1)})
+    nodeReplace2Kid(new, old, 1, 123444, []byte{byte(1)}, nil)
     assert.Equal(t, new.nkeys(), uint16(4))
     assert.Equal(t, new.getKey(1), []byte{byte(1)})
 }
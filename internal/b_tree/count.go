@@ -0,0 +1,132 @@
+package b_tree
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+// order statistics.
+// with `Counted`, each link of an internal node carries the number of leaf
+// entries under it as its value, so ranks are computed along a single path.
+// the counts include the dummy first key, which is excluded from the
+// results. without `Counted`, the keys are scanned.
+
+// the number of leaf entries under a node
+func nodeCount(node BNode) uint64 {
+    if node.btype() == BNODE_LEAF {
+        return uint64(node.nkeys())
+    }
+    count := uint64(0)
+    for i := uint16(0); i < node.nkeys(); i++ {
+        count += kidCount(node, i)
+    }
+    return count
+}
+
+// the key count stored for a kid
+func kidCount(node BNode, idx uint16) uint64 {
+    val := node.getVal(idx)
+    if len(val) != 8 {
+        panic(corruption("internal node without key counts"))
+    }
+    return binary.LittleEndian.Uint64(val)
+}
+
+// the value of the link to a kid
+func (tree *BTree) kidVal(kid BNode) []byte {
+    if !tree.Counted {
+        return nil
+    }
+    val := make([]byte, 8)
+    binary.LittleEndian.PutUint64(val, nodeCount(kid))
+    return val
+}
+
+// the number of leaf entries less than the key
+func treeRank(tree *BTree, key []byte) uint64 {
+    rank := uint64(0)
+    for ptr := tree.Root; ptr != 0; {
+        node := tree.get(ptr)
+        switch node.btype() {
+        case BNODE_LEAF:
+            return rank + uint64(nodeLookGE(node, key))
+        case BNODE_NODE:
+            idx := nodeLookGE(node, key)
+            if idx == node.nkeys() || !bytes.Equal(node.getKey(idx), key) {
+                idx-- // the kid containing the key, the first key is <= key
+            }
+            for i := uint16(0); i < idx; i++ {
+                rank += kidCount(node, i)
+            }
+            ptr = node.getPtr(idx)
+        default:
+            panic(corruption("unrecognized node type %d", node.btype()))
+        }
+    }
+    return rank
+}
+
+// the number of keys in [start, end), a nil `end` means no upper bound
+func (tree *BTree) CountRange(start []byte, end []byte) (count int, err error) {
+    if len(start) == 0 {
+        start = []byte{0} // exclude the dummy key
+    }
+    if tree.Root == 0 || (end != nil && bytes.Compare(start, end) >= 0) {
+        return 0, nil
+    }
+    if !tree.Counted {
+        iter := tree.SeekGE(start)
+        for ; iter.Valid(); iter.Next() {
+            if end != nil && bytes.Compare(iter.Key(), end) >= 0 {
+                break
+            }
+            count++
+        }
+        return count, iter.Err()
+    }
+    defer recoverPageError(&err)
+    total := nodeCount(tree.get(tree.Root))
+    if end != nil {
+        total = treeRank(tree, end)
+    }
+    return int(total - treeRank(tree, start)), nil
+}
+
+// the k-th key (from 0) in ascending order, the iterator is invalid if k is
+// out of range
+func (tree *BTree) Nth(k int) *BIter {
+    if k < 0 {
+        return &BIter{tree: tree}
+    }
+    if !tree.Counted {
+        iter := tree.First()
+        for i := 0; i < k && iter.Valid(); i++ {
+            iter.Next()
+        }
+        return iter
+    }
+    remain := uint64(k) + 1 // skip the dummy key
+    iter := tree.seek(func(node BNode) uint16 {
+        if node.btype() == BNODE_LEAF {
+            if remain >= uint64(node.nkeys()) {
+                return node.nkeys() - 1 // out of range, fixed below
+            }
+            return uint16(remain)
+        }
+        for i := uint16(0); i < node.nkeys(); i++ {
+            count := kidCount(node, i)
+            if remain < count || i == node.nkeys() - 1 {
+                return i
+            }
+            remain -= count
+        }
+        return 0 // unreachable
+    })
+    if iter.err == nil && len(iter.path) > 0 {
+        level := len(iter.path) - 1
+        if remain >= uint64(iter.path[level].nkeys()) {
+            iter.pos[level] = iter.path[level].nkeys() // past the end
+        }
+    }
+    return iter
+}
@@ -0,0 +1,119 @@
+package b_tree
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// compare CountRange and Nth with the sorted keys
+func checkCounts(t *testing.T, tree *BTree, keys []string, rng *rand.Rand) {
+    sort.Strings(keys)
+    assert.Empty(t, verifyAll(tree, int(^uint32(0))))
+    count, err := tree.CountRange(nil, nil)
+    assert.Nil(t, err)
+    assert.Equal(t, count, len(keys))
+    for j := 0; j < 50; j++ {
+        a := fmt.Sprintf("key%05d", rng.Intn(12000))
+        b := fmt.Sprintf("key%05d", rng.Intn(12000))
+        expected := sort.SearchStrings(keys, b) - sort.SearchStrings(keys, a)
+        if expected < 0 {
+            expected = 0
+        }
+        count, err := tree.CountRange([]byte(a), []byte(b))
+        assert.Nil(t, err)
+        assert.Equal(t, count, expected, "[%s, %s)", a, b)
+        count, err = tree.CountRange([]byte(a), nil)
+        assert.Nil(t, err)
+        assert.Equal(t, count, len(keys) - sort.SearchStrings(keys, a))
+    }
+    for j := 0; j < 50 && len(keys) > 0; j++ {
+        k := rng.Intn(len(keys))
+        iter := tree.Nth(k)
+        assert.True(t, iter.Valid())
+        assert.Equal(t, string(iter.Key()), keys[k])
+        iter.Next()
+        if k + 1 < len(keys) {
+            assert.Equal(t, string(iter.Key()), keys[k + 1])
+        } else {
+            assert.False(t, iter.Valid())
+        }
+    }
+    assert.False(t, tree.Nth(len(keys)).Valid())
+    assert.False(t, tree.Nth(-1).Valid())
+}
+
+func TestCounted(t *testing.T) {
+    rng := rand.New(rand.NewSource(1))
+    tree, _ := newPageMapTree(t)
+    tree.Counted = true
+    ref := map[string]bool{}
+    keysOf := func() []string {
+        keys := []string{}
+        for key := range ref {
+            keys = append(keys, key)
+        }
+        return keys
+    }
+    checkCounts(t, tree, keysOf(), rng)
+
+    for i := 0; i < 5000; i++ {
+        key := fmt.Sprintf("key%05d", rng.Intn(10000))
+        assert.Nil(t, tree.Insert([]byte(key), make([]byte, rng.Intn(300))))
+        ref[key] = true
+    }
+    checkCounts(t, tree, keysOf(), rng)
+
+    for i := 0; i < 2000; i++ {
+        key := fmt.Sprintf("key%05d", rng.Intn(10000))
+        deleted, err := tree.DeleteKey([]byte(key))
+        assert.Nil(t, err)
+        assert.Equal(t, deleted, ref[key])
+        delete(ref, key)
+    }
+    checkCounts(t, tree, keysOf(), rng)
+
+    _, err := tree.DeleteRange([]byte("key02000"), []byte("key06000"))
+    assert.Nil(t, err)
+    for key := range ref {
+        if key >= "key02000" && key < "key06000" {
+            delete(ref, key)
+        }
+    }
+    checkCounts(t, tree, keysOf(), rng)
+}
+
+func TestCountedBulkLoad(t *testing.T) {
+    rng := rand.New(rand.NewSource(2))
+    tree, _ := newPageMapTree(t)
+    tree.Counted = true
+    keys := []string{}
+    bl := tree.NewBulkLoader()
+    for i := 0; i < 10000; i += 1 + rng.Intn(3) {
+        key := fmt.Sprintf("key%05d", i)
+        assert.Nil(t, bl.Add([]byte(key), make([]byte, 50)))
+        keys = append(keys, key)
+    }
+    assert.Nil(t, bl.Finish())
+    checkCounts(t, tree, keys, rng)
+}
+
+func TestCountWithoutCounts(t *testing.T) {
+    rng := rand.New(rand.NewSource(3))
+    tree, _ := newPageMapTree(t)
+    keys := []string{}
+    for i := 0; i < 3000; i += 2 {
+        key := fmt.Sprintf("key%05d", i)
+        assert.Nil(t, tree.Insert([]byte(key), make([]byte, 100)))
+        keys = append(keys, key)
+    }
+    checkCounts(t, tree, keys, rng)
+
+    // a counted tree can't read nodes without counts
+    tree.Counted = true
+    _, err := tree.CountRange([]byte("a"), []byte("key01000"))
+    assert.ErrorIs(t, err, ErrCorruptPage)
+}
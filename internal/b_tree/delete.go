@@ -21,20 +21,21 @@ func treeDelete(tree *BTree, node BNode, key []byte) BNode {
         if !bytes.Equal(key, node.getKey(idx)) {
             return BNode{} // key does not exist
         }
+        leafFreeVal(tree, node, idx)
         New := BNode{Data: make([]byte, BTREE_PAGE_SIZE)}
         leafDelete(New, node, idx)
         return New
     case BNODE_NODE:
         return nodeDelete(tree, node, idx, key)
     default:
-        panic("unknown BNode type")
+        panic(corruption("unrecognized node type %d", node.btype()))
     }
 }
 
 // delete a node from internal node
 func nodeDelete(tree *BTree, node BNode, idx uint16, key []byte) BNode {
     ptr := node.getPtr(idx)
-    updated := treeDelete(tree, tree.Get(ptr), key)
+    updated := treeDelete(tree, tree.get(ptr), key)
     if len(updated.Data) == 0 {
         return BNode{} // key does not exist
     }
@@ -48,20 +49,28 @@ func nodeDelete(tree *BTree, node BNode, idx uint16, key []byte) BNode {
         merged := BNode{Data: make([]byte, BTREE_PAGE_SIZE)}
         nodeMerge(merged, sibling, updated)
         tree.Del(node.getPtr(idx - 1))
-        nodeReplace2Kid(New, node, idx - 1, tree.New(merged), merged.getKey(0))
+        nodeReplace2Kid(
+            New, node, idx - 1, tree.New(merged), merged.getKey(0), tree.kidVal(merged),
+        )
     case mergeDir > 0: // right
         merged := BNode{Data: make([]byte, BTREE_PAGE_SIZE)}
         nodeMerge(merged, updated, sibling)
         tree.Del(node.getPtr(idx + 1))
-        nodeReplace2Kid(New, node, idx, tree.New(merged), merged.getKey(0))
+        nodeReplace2Kid(
+            New, node, idx, tree.New(merged), merged.getKey(0), tree.kidVal(merged),
+        )
+    case updated.nkeys() == 0:
+        // the only kid became empty, so does this node, the parent will
+        // merge it away
+        util.Assert(node.nkeys() == 1)
+        New.setHeader(BNODE_NODE, 0)
     case mergeDir == 0: // no merge needed
-        util.Assert(updated.nkeys() > 0)
         nodeReplaceKidN(tree, New, node, idx, updated)
     }
     return New
 }
 
-// sizeof(merge(left, right)) <= BTREE_PAGE_SIZE, this condition
+// sizeof(merge(left, right)) <= BTREE_NODE_SIZE, this condition
 // must be checked by the caller
 func nodeMerge(merged BNode, left BNode, right BNode) {
     util.Assert(left.btype() == right.btype())
@@ -77,24 +86,234 @@ func shouldMerge(
     tree *BTree, node BNode, 
     idx uint16, updated BNode,
 ) (int, BNode) {
-    if updated.nbytes() > BTREE_PAGE_SIZE / 4 {
+    if updated.nbytes() > BTREE_NODE_SIZE / 4 {
         return 0, BNode{}
     }
     if idx > 0 {
         leftChildPtr := node.getPtr(idx - 1)
-        leftChildNode := tree.Get(leftChildPtr)
+        leftChildNode := tree.get(leftChildPtr)
         merged := leftChildNode.nbytes() + updated.nbytes() - HEADER
-        if merged <= BTREE_PAGE_SIZE {
+        if merged <= BTREE_NODE_SIZE {
             return -1, leftChildNode
         }
     }
     if idx < node.nkeys() - 1 {
         rightChildPtr := node.getPtr(idx + 1)
-        rightChildNode := tree.Get(rightChildPtr)
+        rightChildNode := tree.get(rightChildPtr)
         merged := rightChildNode.nbytes() + updated.nbytes() - HEADER
-        if merged <= BTREE_PAGE_SIZE {
+        if merged <= BTREE_NODE_SIZE {
             return +1, rightChildNode
         }
     }
     return 0, BNode{}
 }
+
+// deallocate every page of the tree, which becomes empty.
+// On ErrCorruptPage the tree is partially deallocated and must be discarded.
+func (tree *BTree) Drop() (err error) {
+    if tree.Root == 0 {
+        return nil
+    }
+    defer recoverPageError(&err)
+    treeDrop(tree, tree.Root)
+    tree.Root = 0
+    return nil
+}
+
+// deallocate a subtree, returns the number of keys in it
+func treeDrop(tree *BTree, ptr uint64) int {
+    node := tree.get(ptr)
+    count := 0
+    switch node.btype() {
+    case BNODE_LEAF:
+        for i := uint16(0); i < node.nkeys(); i++ {
+            leafFreeVal(tree, node, i)
+        }
+        count = int(node.nkeys())
+    case BNODE_NODE:
+        for i := uint16(0); i < node.nkeys(); i++ {
+            count += treeDrop(tree, node.getPtr(i))
+        }
+    default:
+        panic(corruption("unrecognized node type %d", node.btype()))
+    }
+    tree.Del(ptr)
+    return count
+}
+
+// delete the keys in [start, end), a nil `end` means no upper bound.
+// returns the number of deleted keys.
+// subtrees within the range are deallocated without being rewritten, only
+// the paths to both ends of the range are.
+// On ErrCorruptPage the tree may be partially updated and must be discarded.
+func (tree *BTree) DeleteRange(start []byte, end []byte) (count int, err error) {
+    if len(start) == 0 {
+        start = []byte{0} // the smallest key except the dummy key
+    }
+    if tree.Root == 0 || (end != nil && bytes.Compare(start, end) >= 0) {
+        return 0, nil
+    }
+    defer recoverPageError(&err)
+
+    updated := treeDeleteRange(tree, tree.get(tree.Root), start, end, nil, &count)
+    if len(updated.Data) == 0 {
+        return 0, nil // nothing in the range
+    }
+    tree.Del(tree.Root)
+
+    nsplit, splited := nodeSplit3(updated)
+    if nsplit > 1 {
+        Root := BNode{Data: make([]byte, BTREE_PAGE_SIZE)}
+        Root.setHeader(BNODE_NODE, nsplit)
+        for i, node := range splited[:nsplit] {
+            nodeAppendKV(Root, uint16(i), tree.New(node), node.getKey(0), tree.kidVal(node))
+        }
+        tree.Root = tree.New(Root)
+        return count, nil
+    }
+    // remove the levels with a single kid
+    Root := splited[0]
+    if Root.btype() != BNODE_NODE || Root.nkeys() > 1 {
+        tree.Root = tree.New(Root)
+        return count, nil
+    }
+    ptr := Root.getPtr(0)
+    for {
+        node := tree.get(ptr)
+        if node.btype() != BNODE_NODE || node.nkeys() > 1 {
+            break
+        }
+        tree.Del(ptr)
+        ptr = node.getPtr(0)
+    }
+    tree.Root = ptr
+    return count, nil
+}
+
+// delete the keys in [start, end) from a node whose keys are less than `hi`
+// (nil for no bound). the result may be empty or larger than a page, an
+// empty BNode{} means nothing was deleted.
+func treeDeleteRange(
+    tree *BTree, node BNode, start []byte, end []byte, hi []byte, count *int,
+) BNode {
+    switch node.btype() {
+    case BNODE_LEAF:
+        return leafDeleteRange(tree, node, start, end, count)
+    case BNODE_NODE:
+        return nodeDeleteRange(tree, node, start, end, hi, count)
+    default:
+        panic(corruption("unrecognized node type %d", node.btype()))
+    }
+}
+
+// the deleted keys of a leaf are contiguous
+func leafDeleteRange(
+    tree *BTree, node BNode, start []byte, end []byte, count *int,
+) BNode {
+    from := nodeLookGE(node, start)
+    to := node.nkeys()
+    if end != nil {
+        to = nodeLookGE(node, end)
+    }
+    if from >= to {
+        return BNode{}
+    }
+    for i := from; i < to; i++ {
+        leafFreeVal(tree, node, i)
+    }
+    *count += int(to - from)
+    New := BNode{Data: make([]byte, BTREE_PAGE_SIZE)}
+    New.setHeader(BNODE_LEAF, node.nkeys() - (to - from))
+    nodeAppendRange(New, node, 0, 0, from)
+    nodeAppendRange(New, node, from, to, node.nkeys() - to)
+    return New
+}
+
+// kids entirely within the range are dropped, the ones at both ends are
+// updated recursively and then merged with a sibling if they are too small
+func nodeDeleteRange(
+    tree *BTree, node BNode, start []byte, end []byte, hi []byte, count *int,
+) BNode {
+    type kid struct {
+        key     []byte
+        ptr     uint64
+        val     []byte // the key count
+        updated bool
+    }
+    kids := []kid{}
+    changed := false
+    for i := uint16(0); i < node.nkeys(); i++ {
+        lo, next := node.getKey(i), hi
+        if i + 1 < node.nkeys() {
+            next = node.getKey(i + 1)
+        }
+        ptr := node.getPtr(i)
+        overlap := (end == nil || bytes.Compare(lo, end) < 0) &&
+            (next == nil || bytes.Compare(next, start) > 0)
+        inside := bytes.Compare(lo, start) >= 0 &&
+            (end == nil || (next != nil && bytes.Compare(next, end) <= 0))
+        switch {
+        case !overlap:
+            kids = append(kids, kid{key: lo, ptr: ptr, val: node.getVal(i)})
+        case inside:
+            *count += treeDrop(tree, ptr)
+            changed = true
+        default:
+            updated := treeDeleteRange(tree, tree.get(ptr), start, end, next, count)
+            if len(updated.Data) == 0 {
+                kids = append(kids, kid{key: lo, ptr: ptr, val: node.getVal(i)})
+                continue
+            }
+            tree.Del(ptr)
+            changed = true
+            if updated.nkeys() == 0 {
+                continue // the whole kid is deleted
+            }
+            nsplit, splited := nodeSplit3(updated)
+            for _, piece := range splited[:nsplit] {
+                kids = append(kids, kid{
+                    key: piece.getKey(0), ptr: tree.New(piece),
+                    val: tree.kidVal(piece), updated: true,
+                })
+            }
+        }
+    }
+    if !changed {
+        return BNode{}
+    }
+
+    New := BNode{Data: make([]byte, 2 * BTREE_PAGE_SIZE)}
+    New.setHeader(BNODE_NODE, uint16(len(kids)))
+    for i, k := range kids {
+        nodeAppendKV(New, uint16(i), k.ptr, k.key, k.val)
+    }
+    // from right to left, so that a merge doesn't move the kids to the left
+    for i := len(kids) - 1; i >= 0; i-- {
+        if !kids[i].updated || i >= int(New.nkeys()) {
+            continue
+        }
+        idx := uint16(i)
+        ptr := New.getPtr(idx)
+        updated := tree.get(ptr)
+        mergeDir, sibling := shouldMerge(tree, New, idx, updated)
+        if mergeDir == 0 {
+            continue
+        }
+        merged := BNode{Data: make([]byte, BTREE_PAGE_SIZE)}
+        if mergeDir < 0 {
+            nodeMerge(merged, sibling, updated)
+            tree.Del(New.getPtr(idx - 1))
+            idx--
+        } else {
+            nodeMerge(merged, updated, sibling)
+            tree.Del(New.getPtr(idx + 1))
+        }
+        tree.Del(ptr)
+        replaced := BNode{Data: make([]byte, 2 * BTREE_PAGE_SIZE)}
+        nodeReplace2Kid(
+            replaced, New, idx, tree.New(merged), merged.getKey(0), tree.kidVal(merged),
+        )
+        New = replaced
+    }
+    return New
+}
@@ -1,6 +1,9 @@
 package b_tree
 
 import (
+    "bytes"
+    "fmt"
+    "math/rand"
     "testing"
 
 	"github.com/stretchr/testify/assert"
@@ -46,24 +49,26 @@ func TestShouldMerge(t *testing.T) {
     //         Root: 0
     //  left: 0, 5
     Root := tree.Get(tree.Root)
-    key5 := make([]byte, 1000)
-    val5 := make([]byte, 3063)
+    // fill the leaf, values above BTREE_MAX_VALUE_SIZE would go to overflow
+    // pages so the key takes the rest
+    key5 := make([]byte, 1059)
+    val5 := make([]byte, 3000)
     key5[0] = byte(5)
-    Root = treeInsert(&tree, Root, key5, val5)
+    Root = treeInsert(&tree, Root, &insertReq{key: key5, val: val5})
     assert.Equal(t, Root.nkeys(), uint16(1))
 
     leftChild := tree.Get(Root.getPtr(0))
     assert.Equal(t, leftChild.nkeys(), uint16(2))
     assert.Equal(t, leftChild.getKey(1), key5)
     assert.Equal(t, leftChild.getVal(1), val5)
-    assert.Equal(t, leftChild.nbytes(), uint16(BTREE_PAGE_SIZE))
+    assert.Equal(t, leftChild.nbytes(), uint16(BTREE_NODE_SIZE))
 
     //          Root: 0, 7
     // left: 0, 5      right: 7
     key7 := []byte{byte(1)}
     val7 := []byte{byte(1)}
     key7[0] = byte(7)
-    Root = treeInsert(&tree, Root, key7, val7)
+    Root = treeInsert(&tree, Root, &insertReq{key: key7, val: val7})
     assert.Equal(t, Root.nkeys(), uint16(2))
     assert.Equal(t, Root.getKey(1), key7)
     rightChild := tree.Get(Root.getPtr(1))
@@ -102,14 +107,16 @@ func TestTreeDeleteAndNodeDelete(t *testing.T) {
     //      Root: 0, 7
     // left: 0, 5    right: 7
     Root := tree.Get(tree.Root)
-    key5 := make([]byte, 1000)
-    val5 := make([]byte, 3063)
+    // fill the leaf, values above BTREE_MAX_VALUE_SIZE would go to overflow
+    // pages so the key takes the rest
+    key5 := make([]byte, 1059)
+    val5 := make([]byte, 3000)
     key5[0] = byte(5)
-    Root = treeInsert(&tree, Root, key5, val5)
+    Root = treeInsert(&tree, Root, &insertReq{key: key5, val: val5})
     key7 := []byte{byte(1)}
     val7 := []byte{byte(1)}
     key7[0] = byte(7)
-    Root = treeInsert(&tree, Root, key7, val7)
+    Root = treeInsert(&tree, Root, &insertReq{key: key7, val: val7})
     
     //      Root: 0
     // left: 0, 7
@@ -140,3 +147,153 @@ func TestTreeDeleteAndNodeDelete(t *testing.T) {
     result := treeDelete(&tree, Root, key7)
     assert.Equal(t, result, BNode{})
 }
+
+func TestDeleteRun(t *testing.T) {
+    // deleting a run of keys leaves internal nodes with a single kid,
+    // which becomes empty in the end
+    tree, pages := newVerifyTree(t, 0)
+    for _, prefix := range []string{"a", "b", "c"} {
+        for i := 0; i < 300; i++ {
+            key := []byte(fmt.Sprintf("%s/%03d", prefix, i))
+            assert.Nil(t, tree.Insert(key, make([]byte, 50)))
+        }
+    }
+    for i := 0; i < 300; i++ {
+        deleted, err := tree.DeleteKey([]byte(fmt.Sprintf("b/%03d", i)))
+        assert.Nil(t, err)
+        assert.True(t, deleted)
+    }
+    assert.Empty(t, verifyAll(tree, len(*pages)))
+    count := 0
+    for iter := tree.First(); iter.Valid(); iter.Next() {
+        assert.False(t, bytes.HasPrefix(iter.Key(), []byte("b/")))
+        count++
+    }
+    assert.Equal(t, count, 601)
+}
+
+// a tree that tracks live pages, deallocating a page twice is an error
+func newPageMapTree(t *testing.T) (*BTree, map[uint64]BNode) {
+    pages := map[uint64]BNode{}
+    next := uint64(1)
+    tree := &BTree{
+        Get: func(ptr uint64) BNode {
+            return pages[ptr]
+        },
+        New: func(node BNode) uint64 {
+            pages[next] = node
+            next++
+            return next - 1
+        },
+        Del: func(ptr uint64) {
+            _, ok := pages[ptr]
+            assert.True(t, ok, "page %d deallocated twice", ptr)
+            delete(pages, ptr)
+        },
+    }
+    return tree, pages
+}
+
+func TestDeleteRange(t *testing.T) {
+    tree, pages := newPageMapTree(t)
+    ref := map[string]bool{}
+    const n = 5000
+    for i := 0; i < n; i++ {
+        key := fmt.Sprintf("key%05d", i)
+        val := make([]byte, i % 200)
+        if i % 700 == 0 {
+            val = largeVal(5000, byte(i))
+        }
+        assert.Nil(t, tree.Insert([]byte(key), val))
+        ref[key] = true
+    }
+    check := func() {
+        assert.Empty(t, verifyAll(tree, int(^uint32(0))))
+        // every page is reachable
+        seen := 0
+        tree.Verify(uint64(^uint32(0)), func(uint64) bool { seen++; return true })
+        assert.Equal(t, seen, len(pages))
+        count := 0
+        for iter := tree.First(); iter.Valid(); iter.Next() {
+            assert.True(t, ref[string(iter.Key())])
+            count++
+        }
+        assert.Equal(t, count, len(ref))
+    }
+    deleteRange := func(start string, end string, expected int) {
+        var endKey []byte
+        if end != "" {
+            endKey = []byte(end)
+        }
+        count, err := tree.DeleteRange([]byte(start), endKey)
+        assert.Nil(t, err)
+        assert.Equal(t, count, expected)
+        for key := range ref {
+            if key >= start && (end == "" || key < end) {
+                delete(ref, key)
+            }
+        }
+        check()
+    }
+
+    deleteRange("key00100", "key00100", 0)
+    deleteRange("key00100", "key00101", 1)
+    deleteRange("key00100", "key00200", 99)
+    // spans many leaves
+    deleteRange("key00999", "key03001", 2002)
+    deleteRange("key00990", "key03010", 18)
+    deleteRange("zzz", "", 0)
+    deleteRange("key04500", "", 500)
+    // from the first key
+    deleteRange("", "key00050", 50)
+    // everything
+    deleteRange("", "", len(ref))
+    assert.Equal(t, len(pages), 1)
+
+    // reinsert after emptying
+    assert.Nil(t, tree.Insert([]byte("k"), []byte("v")))
+    val, ok, err := tree.GetKey([]byte("k"))
+    assert.Nil(t, err)
+    assert.True(t, ok)
+    assert.Equal(t, val, []byte("v"))
+}
+
+func TestDeleteRangeRandom(t *testing.T) {
+    rng := rand.New(rand.NewSource(1))
+    for round := 0; round < 20; round++ {
+        tree, pages := newPageMapTree(t)
+        ref := map[int]bool{}
+        for i := 0; i < 3000; i++ {
+            k := rng.Intn(10000)
+            // long keys to vary the fanout
+            key := []byte(fmt.Sprintf("%05d%s", k, make([]byte, k % 300)))
+            assert.Nil(t, tree.Insert(key, make([]byte, rng.Intn(100))))
+            ref[k] = true
+        }
+        for j := 0; j < 5; j++ {
+            lo := rng.Intn(10000)
+            hi := lo + rng.Intn(3000)
+            expected := 0
+            for k := range ref {
+                if k >= lo && k < hi {
+                    expected++
+                    delete(ref, k)
+                }
+            }
+            count, err := tree.DeleteRange(
+                []byte(fmt.Sprintf("%05d", lo)), []byte(fmt.Sprintf("%05d", hi)),
+            )
+            assert.Nil(t, err)
+            assert.Equal(t, count, expected)
+            assert.Empty(t, verifyAll(tree, int(^uint32(0))))
+        }
+        seen := 0
+        tree.Verify(uint64(^uint32(0)), func(uint64) bool { seen++; return true })
+        assert.Equal(t, seen, len(pages))
+        count := 0
+        for iter := tree.First(); iter.Valid(); iter.Next() {
+            count++
+        }
+        assert.Equal(t, count, len(ref))
+    }
+}
@@ -0,0 +1,112 @@
+package b_tree
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+var (
+    ErrEmptyKey      = errors.New("empty key")
+    ErrKeyTooLarge   = errors.New("key too large")
+    ErrValueTooLarge = errors.New("value too large")
+    ErrCorruptPage   = errors.New("corrupt page")
+    // InsertEx modes
+    ErrKeyExists   = errors.New("key already exists")
+    ErrKeyNotFound = errors.New("key not found")
+)
+
+// corruption found deep inside the recursive helpers is raised as a panic
+// and turned into an error by the exported methods, so that the helpers
+// don't have to thread it through every level.
+type pageError struct {
+    err error
+}
+
+// used as `panic(corruption(...))`
+func corruption(format string, args ...interface{}) pageError {
+    return pageError{fmt.Errorf("%w: %s", ErrCorruptPage, fmt.Sprintf(format, args...))}
+}
+
+// CorruptPage reports a damaged page from the Get callback, e.g. a checksum
+// mismatch detected by the storage. it doesn't return, the BTree method
+// being executed fails with ErrCorruptPage.
+func CorruptPage(ptr uint64, reason string) {
+    panic(corruption("page %d: %s", ptr, reason))
+}
+
+// deferred by the exported methods, other panics are bugs and propagate
+func recoverPageError(err *error) {
+    if r := recover(); r != nil {
+        perr, ok := r.(pageError)
+        if !ok {
+            panic(r)
+        }
+        *err = perr.err
+    }
+}
+
+func checkKey(key []byte) error {
+    if len(key) == 0 {
+        return ErrEmptyKey
+    }
+    if len(key) > BTREE_MAX_KEY_SIZE {
+        return fmt.Errorf("%w: %d > %d", ErrKeyTooLarge, len(key), BTREE_MAX_KEY_SIZE)
+    }
+    return nil
+}
+
+func checkVal(val []byte) error {
+    if len(val) > BTREE_MAX_LARGE_VALUE_SIZE {
+        return fmt.Errorf(
+            "%w: %d > %d", ErrValueTooLarge, len(val), BTREE_MAX_LARGE_VALUE_SIZE,
+        )
+    }
+    return nil
+}
+
+// validate a node read through the Get callback, so that decoding it
+// can't go out of the page.
+func nodeCheck(node BNode) error {
+    if len(node.Data) < BTREE_PAGE_SIZE {
+        return errors.New("bad pointer")
+    }
+    if t := node.btype(); t != BNODE_LEAF && t != BNODE_NODE {
+        return fmt.Errorf("unrecognized node type %d", t)
+    }
+    nkeys := int(node.nkeys())
+    kvStart := HEADER + nkeys * 8 + nkeys * 2
+    if nkeys == 0 || kvStart > BTREE_PAGE_SIZE {
+        return fmt.Errorf("bad number of keys %d", nkeys)
+    }
+    // every KV pair lies within the page and matches the offset list.
+    // new nodes leave room for the trailer, but the nodes of files without
+    // page checksums may use the whole page.
+    for i := 0; i < nkeys; i++ {
+        pos := kvStart + int(node.getOffset(uint16(i)))
+        if pos + 4 > BTREE_PAGE_SIZE {
+            return fmt.Errorf("bad offset of key %d", i)
+        }
+        klen := int(binary.LittleEndian.Uint16(node.Data[pos:]))
+        vlen := int(binary.LittleEndian.Uint16(node.Data[pos + 2:]))
+        end := kvStart + int(node.getOffset(uint16(i + 1)))
+        if end != pos + 4 + klen + vlen & VLEN_MASK || end > BTREE_PAGE_SIZE {
+            return fmt.Errorf("bad offset of key %d", i)
+        }
+        if vlen & VLEN_OVERFLOW != 0 {
+            if node.btype() != BNODE_LEAF || vlen & VLEN_MASK != OVERFLOW_REF_SIZE {
+                return fmt.Errorf("bad overflow reference of key %d", i)
+            }
+        }
+    }
+    return nil
+}
+
+// dereference a pointer and validate the node
+func (tree *BTree) get(ptr uint64) BNode {
+    node := tree.Get(ptr)
+    if err := nodeCheck(node); err != nil {
+        panic(corruption("page %d: %s", ptr, err.Error()))
+    }
+    return node
+}
@@ -0,0 +1,93 @@
+package b_tree
+
+import (
+	"encoding/binary"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNodeCheck(t *testing.T) {
+    node := BNode{Data: make([]byte, BTREE_PAGE_SIZE)}
+    node.setHeader(BNODE_LEAF, 2)
+    nodeAppendKV(node, 0, 0, []byte("a"), []byte("1"))
+    nodeAppendKV(node, 1, 0, []byte("b"), []byte("2"))
+    assert.Nil(t, nodeCheck(node))
+
+    assert.NotNil(t, nodeCheck(BNode{}))
+
+    bad := BNode{Data: append([]byte(nil), node.Data...)}
+    bad.setHeader(3, 2)
+    assert.NotNil(t, nodeCheck(bad))
+
+    bad = BNode{Data: append([]byte(nil), node.Data...)}
+    bad.setHeader(BNODE_LEAF, 0)
+    assert.NotNil(t, nodeCheck(bad))
+
+    bad = BNode{Data: append([]byte(nil), node.Data...)}
+    bad.setHeader(BNODE_NODE, 1000)
+    assert.NotNil(t, nodeCheck(bad))
+
+    // the key length doesn't match the offsets
+    bad = BNode{Data: append([]byte(nil), node.Data...)}
+    binary.LittleEndian.PutUint16(bad.Data[bad.kvPos(1):], 100)
+    assert.NotNil(t, nodeCheck(bad))
+
+    // the offset points out of the page
+    bad = BNode{Data: append([]byte(nil), node.Data...)}
+    bad.setOffset(2, 0xffff)
+    assert.NotNil(t, nodeCheck(bad))
+}
+
+func TestCorruptPageError(t *testing.T) {
+    container := newC()
+    for i := 0; i < 100; i++ {
+        key := []byte(fmt.Sprintf("key%03d", i))
+        assert.Nil(t, container.tree.Insert(key, make([]byte, 100)))
+    }
+    root := container.tree.Get(container.tree.Root)
+    assert.Equal(t, root.btype(), uint16(BNODE_NODE))
+
+    // damage the leaf holding the first key
+    ptr := root.getPtr(nodeLookLE(root, []byte("key000")))
+    leaf := container.tree.Get(ptr)
+    leaf.setHeader(9, leaf.nkeys())
+
+    _, _, err := container.tree.GetKey([]byte("key000"))
+    assert.ErrorIs(t, err, ErrCorruptPage)
+    assert.ErrorContains(t, err, fmt.Sprintf("page %d", ptr))
+
+    iter := container.tree.SeekLE([]byte("key000"))
+    assert.False(t, iter.Valid())
+    assert.ErrorIs(t, iter.Err(), ErrCorruptPage)
+
+    // the other leaves are still readable
+    _, found, err := container.tree.GetKey([]byte("key099"))
+    assert.Nil(t, err)
+    assert.True(t, found)
+
+    // updates fail too, the tree is left unusable
+    err = container.tree.Insert([]byte("key000"), nil)
+    assert.ErrorIs(t, err, ErrCorruptPage)
+}
+
+func TestCorruptKeyOrder(t *testing.T) {
+    container := newC()
+    for i := 0; i < 100; i++ {
+        key := []byte(fmt.Sprintf("key%03d", i))
+        assert.Nil(t, container.tree.Insert(key, make([]byte, 100)))
+    }
+    root := container.tree.Get(container.tree.Root)
+    assert.Equal(t, root.btype(), uint16(BNODE_NODE))
+
+    // the first key of the second leaf becomes greater than the key in the
+    // parent, "key0xx" -> "kez0xx"
+    first := root.getKey(1)
+    leaf := container.tree.Get(root.getPtr(1))
+    assert.Equal(t, leaf.getKey(0), first)
+    leaf.Data[leaf.kvPos(0) + 4 + 2] = 'z'
+
+    _, _, err := container.tree.GetKey(first)
+    assert.ErrorIs(t, err, ErrCorruptPage)
+}
@@ -9,14 +9,14 @@ func treeGet(tree *BTree, node BNode, key[]byte) ([]byte, bool) {
     switch node.btype() {
     case BNODE_LEAF:
         if bytes.Equal(key, node.getKey(idx)) {
-            return node.getVal(idx), true
+            return leafGetVal(tree, node, idx), true
         } else {
             return nil, false // not found
         }
     case BNODE_NODE:
-        childNode := tree.Get(node.getPtr(idx))
+        childNode := tree.get(node.getPtr(idx))
         return treeGet(tree, childNode, key)
     default:
-        panic("unrecognized node type")
+        panic(corruption("unrecognized node type %d", node.btype()))
     }
 }
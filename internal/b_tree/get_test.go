@@ -11,7 +11,7 @@ func TestGet(t *testing.T) {
     SetUpMockBTree(t, &tree)
     root := tree.Get(tree.Root)
     
-    root = treeInsert(&tree, root, []byte("hello"), []byte("world"))
+    root = treeInsert(&tree, root, &insertReq{key: []byte("hello"), val: []byte("world")})
     val, exist := treeGet(&tree, root, []byte("hello"))
     assert.True(t, exist)
     assert.Equal(t, val, []byte("world"))
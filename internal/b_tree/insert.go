@@ -25,43 +25,69 @@ func leafUpdate(new BNode, old BNode, idx uint16, key []byte, val []byte) {
 // insert a KV into a node, the result might be split into 2 nodes.
 // the caller is responsible for deallocating the input node
 // and splitting and allocating result nodes.
-func treeInsert(tree *BTree, node BNode, key []byte, val []byte) BNode {
+// returns an empty node if the mode fails, nothing is allocated or freed then.
+func treeInsert(tree *BTree, node BNode, req *insertReq) BNode {
     // the result node, 
     // it's allowed to be greater than one page and will be splitted if so
     new := BNode{Data: make([]byte, 2 * BTREE_PAGE_SIZE)}
 
     // where to insert the key
-    idx := nodeLookLE(node, key)
+    idx := nodeLookLE(node, req.key)
     // act based on node type
     switch node.btype() {
     case BNODE_LEAF: 
         // leaf, node.getKey(idx) <= key
-        if bytes.Equal(key, node.getKey(idx)) {
-            leafUpdate(new, node, idx, key, val)
+        found := bytes.Equal(req.key, node.getKey(idx))
+        if found && req.withOld {
+            // the old value is read before the update releases its pages
+            req.res.Old = append([]byte(nil), leafGetVal(tree, node, idx)...)
+        }
+        if found && req.mode == MODE_INSERT_ONLY {
+            req.err = ErrKeyExists
+            return BNode{}
+        }
+        if !found && req.mode == MODE_UPDATE_ONLY {
+            req.err = ErrKeyNotFound
+            return BNode{}
+        }
+        req.res.Added, req.res.Updated = !found, found
+        stored := leafStoreVal(tree, req.val)
+        if found {
+            leafFreeVal(tree, node, idx) // the old value is overwritten
+            leafUpdate(new, node, idx, req.key, stored)
         } else {
-            leafInsert(new, node, idx + 1, key, val)
+            idx++
+            leafInsert(new, node, idx, req.key, stored)
+        }
+        if len(req.val) > BTREE_MAX_VALUE_SIZE {
+            new.setOverflow(idx)
         }
     case BNODE_NODE:
-        nodeInsert(tree, new, node, idx, key, val)
+        if !nodeInsert(tree, new, node, idx, req) {
+            return BNode{}
+        }
     default:
-        panic("unrecognized node type")
+        panic(corruption("unrecognized node type %d", node.btype()))
     }
     return new
 }
 
+// returns false if the mode fails
 func nodeInsert(
     tree *BTree, new BNode, node BNode, 
-    idx uint16, key []byte, val []byte,
-) {
-    // Get and deallocate the kid node
+    idx uint16, req *insertReq,
+) bool {
+    // recursive insertion to the kid node
     kptr := node.getPtr(idx)
-    knode := tree.Get(kptr)
+    knode := treeInsert(tree, tree.get(kptr), req)
+    if len(knode.Data) == 0 {
+        return false
+    }
+    // deallocate the kid node
     tree.Del(kptr)
-    // recursive insertion to the kid node
-    knode = treeInsert(tree, knode, key, val)
     // split the result
     nsplit, splited := nodeSplit3(knode)
     // update the kid links
     nodeReplaceKidN(tree, new, node, idx, splited[:nsplit]...)
+    return true
 }
-
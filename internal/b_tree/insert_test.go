@@ -1,6 +1,7 @@
 package b_tree
 
 import (
+    "fmt"
     "testing"
 
 	"github.com/stretchr/testify/assert"
@@ -75,7 +76,7 @@ func TestNodeInsertAndTreeInsert(t *testing.T) {
     tree := BTree{}
     SetUpMockBTree(t, &tree)
     root := tree.Get(0)
-    root = treeInsert(&tree, root, []byte{byte(10)}, []byte{byte(10)})
+    root = treeInsert(&tree, root, &insertReq{key: []byte{byte(10)}, val: []byte{byte(10)}})
     assert.Equal(t, root.nkeys(), uint16(1))
     child0 := tree.Get(root.getPtr(0))
     // root, deleted_child0, actual_child0
@@ -86,10 +87,10 @@ func TestNodeInsertAndTreeInsert(t *testing.T) {
     // insert a big node such that it needs to split
     //         root: 0, 15
     // left: 0, 10      right: 15
-    key := make([]byte, 1000)
+    key := make([]byte, 1059)
     key[0] = byte(15)
-    val := make([]byte, 3063)
-    root = treeInsert(&tree, root, key, val)
+    val := make([]byte, 3000)
+    root = treeInsert(&tree, root, &insertReq{key: key, val: val})
     assert.Equal(t, root.nkeys(), uint16(2))
     assert.Equal(t, root.getKey(1), key)
     assert.Equal(t, root.getVal(1), []byte{})
@@ -107,7 +108,7 @@ func TestNodeInsertAndTreeInsert(t *testing.T) {
     // insert a small node
     //         root: 0, 15
     // left: 0, 10      right: 15, 17
-    root = treeInsert(&tree, root, []byte{byte(17)}, nil)
+    root = treeInsert(&tree, root, &insertReq{key: []byte{byte(17)}, val: nil})
     assert.Equal(t, root.nkeys(), uint16(2))
     assert.Equal(t, root.getKey(1), key)
     assert.Equal(t, root.getVal(1), []byte{})
@@ -120,10 +121,10 @@ func TestNodeInsertAndTreeInsert(t *testing.T) {
     // insert a super big node, trigger a double split
     //      root: 0, 15, 16, 17
     // left: 0, 10    middle: 15  right1: 16 right2: 17
-    key1 := make([]byte, 1000)
-    val1 := make([]byte, 3078)
+    key1 := make([]byte, 1074)
+    val1 := make([]byte, 3000)
     key1[0] = byte(16)
-    root = treeInsert(&tree, root, key1, val1)
+    root = treeInsert(&tree, root, &insertReq{key: key1, val: val1})
 
     assert.Equal(t, root.nkeys(), uint16(4))
     left_child = tree.Get(root.getPtr(0))
@@ -139,3 +140,84 @@ func TestNodeInsertAndTreeInsert(t *testing.T) {
     assert.Equal(t, right1_child.getKey(0), key1)
     assert.Equal(t, right2_child.getKey(0), []byte{byte(17)})
 }
+
+func TestInsertEx(t *testing.T) {
+    tree, pages := newPageMapTree(t)
+    tree.Counted = true
+
+    res, err := tree.InsertEx([]byte("k1"), []byte("v1"), MODE_UPDATE_ONLY)
+    assert.ErrorIs(t, err, ErrKeyNotFound)
+    assert.Equal(t, res, InsertResult{})
+    assert.Equal(t, tree.Root, uint64(0))
+
+    res, err = tree.InsertEx([]byte("k1"), []byte("v1"), MODE_INSERT_ONLY)
+    assert.Nil(t, err)
+    assert.Equal(t, res, InsertResult{Added: true})
+
+    res, err = tree.InsertEx([]byte("k1"), []byte("v2"), MODE_INSERT_ONLY)
+    assert.ErrorIs(t, err, ErrKeyExists)
+    assert.Equal(t, res.Old, []byte("v1"))
+    val, _, _ := tree.GetKey([]byte("k1"))
+    assert.Equal(t, val, []byte("v1"))
+
+    res, err = tree.InsertEx([]byte("k1"), []byte("v2"), MODE_UPDATE_ONLY)
+    assert.Nil(t, err)
+    assert.Equal(t, res, InsertResult{Updated: true, Old: []byte("v1")})
+
+    res, err = tree.InsertEx([]byte("k1"), []byte("v3"), MODE_UPSERT)
+    assert.Nil(t, err)
+    assert.Equal(t, res, InsertResult{Updated: true, Old: []byte("v2")})
+    res, err = tree.InsertEx([]byte("k2"), []byte("v"), MODE_UPSERT)
+    assert.Nil(t, err)
+    assert.Equal(t, res, InsertResult{Added: true})
+
+    // the old value is read before its overflow pages are released
+    big := largeVal(10000, 1)
+    _, err = tree.InsertEx([]byte("k3"), big, MODE_UPSERT)
+    assert.Nil(t, err)
+    res, err = tree.InsertEx([]byte("k3"), largeVal(10000, 2), MODE_UPDATE_ONLY)
+    assert.Nil(t, err)
+    assert.Equal(t, res.Old, big)
+
+    // failed modes leave the tree intact in a larger tree
+    for i := 0; i < 1000; i++ {
+        key := []byte(fmt.Sprintf("key%03d", i))
+        _, err := tree.InsertEx(key, make([]byte, 100), MODE_INSERT_ONLY)
+        assert.Nil(t, err)
+    }
+    npages := len(pages)
+    root := tree.Root
+    _, err = tree.InsertEx([]byte("key500"), nil, MODE_INSERT_ONLY)
+    assert.ErrorIs(t, err, ErrKeyExists)
+    _, err = tree.InsertEx([]byte("key5000"), nil, MODE_UPDATE_ONLY)
+    assert.ErrorIs(t, err, ErrKeyNotFound)
+    assert.Equal(t, len(pages), npages)
+    assert.Equal(t, tree.Root, root)
+    assert.Empty(t, verifyAll(tree, int(^uint32(0))))
+}
+
+func TestInsertSingleLookup(t *testing.T) {
+    tree, _ := newPageMapTree(t)
+    for i := 0; i < 1000; i++ {
+        assert.Nil(t, tree.Insert([]byte(fmt.Sprintf("key%03d", i)), make([]byte, 100)))
+    }
+    get := tree.Get
+    reads := 0
+    tree.Get = func(ptr uint64) BNode {
+        reads++
+        return get(ptr)
+    }
+
+    // the key is looked up once, along with the mode and the old value
+    assert.Nil(t, tree.Insert([]byte("key500"), []byte("v")))
+    depth := reads
+    reads = 0
+    res, err := tree.InsertEx([]byte("key501"), []byte("v"), MODE_UPSERT)
+    assert.Nil(t, err)
+    assert.Equal(t, res, InsertResult{Updated: true, Old: make([]byte, 100)})
+    assert.Equal(t, reads, depth)
+    reads = 0
+    _, err = tree.InsertEx([]byte("key502"), nil, MODE_INSERT_ONLY)
+    assert.ErrorIs(t, err, ErrKeyExists)
+    assert.Equal(t, reads, depth)
+}
@@ -0,0 +1,154 @@
+package b_tree
+
+import (
+	"bytes"
+
+	"github.com/connnorchen/MyDb/internal/util"
+)
+
+// B-tree iterator, a path from the root to a leaf.
+// the iterator is invalidated by any update to the tree.
+type BIter struct {
+    tree *BTree
+    path []BNode  // from root to leaf
+    pos  []uint16 // indexes into nodes
+    err  error    // a corrupt page stops the iterator
+}
+
+// find the closest position that is less or equal to the input key
+func (tree *BTree) SeekLE(key []byte) *BIter {
+    return tree.seek(func(node BNode) uint16 {
+        return nodeLookLE(node, key)
+    })
+}
+
+// find the closest position that is greater or equal to the input key
+func (tree *BTree) SeekGE(key []byte) *BIter {
+    iter := tree.SeekLE(key)
+    if !iter.Valid() || bytes.Compare(iter.Key(), key) < 0 {
+        iter.Next()
+    }
+    return iter
+}
+
+// the smallest key, invalid if the tree is empty
+func (tree *BTree) First() *BIter {
+    iter := tree.SeekLE([]byte{}) // the dummy key
+    iter.Next()
+    return iter
+}
+
+// the largest key, invalid if the tree is empty
+func (tree *BTree) Last() *BIter {
+    return tree.seek(func(node BNode) uint16 {
+        return node.nkeys() - 1
+    })
+}
+
+// descend from the root to a leaf, `pick` chooses the position in each node
+func (tree *BTree) seek(pick func(node BNode) uint16) (iter *BIter) {
+    iter = &BIter{tree: tree}
+    defer recoverPageError(&iter.err)
+    for ptr := tree.Root; ptr != 0; {
+        node := tree.get(ptr)
+        idx := pick(node)
+        iter.path = append(iter.path, node)
+        iter.pos = append(iter.pos, idx)
+        switch node.btype() {
+        case BNODE_LEAF:
+            ptr = 0
+        case BNODE_NODE:
+            ptr = node.getPtr(idx)
+        default:
+            panic(corruption("unrecognized node type %d", node.btype()))
+        }
+    }
+    return iter
+}
+
+// move to the next position of `level`, returns false if there is none.
+// the upper levels are left untouched on failure.
+func iterNext(iter *BIter, level int) bool {
+    if iter.pos[level] + 1 < iter.path[level].nkeys() {
+        iter.pos[level]++ // move within this node
+    } else if level > 0 && iterNext(iter, level - 1) {
+        // move to the first kid of the next sibling
+        kid := iter.tree.get(iter.path[level - 1].getPtr(iter.pos[level - 1]))
+        iter.path[level] = kid
+        iter.pos[level] = 0
+    } else {
+        return false // past the last key
+    }
+    return true
+}
+
+// move to the previous position of `level`, returns false if there is none.
+func iterPrev(iter *BIter, level int) bool {
+    if iter.pos[level] > 0 {
+        iter.pos[level]-- // move within this node
+    } else if level > 0 && iterPrev(iter, level - 1) {
+        // move to the last kid of the previous sibling
+        kid := iter.tree.get(iter.path[level - 1].getPtr(iter.pos[level - 1]))
+        iter.path[level] = kid
+        iter.pos[level] = kid.nkeys() - 1
+    } else {
+        return false // at the dummy first key
+    }
+    return true
+}
+
+// move forward, past the last key the iterator becomes invalid.
+func (iter *BIter) Next() {
+    if len(iter.path) == 0 || iter.err != nil {
+        return
+    }
+    defer recoverPageError(&iter.err)
+    level := len(iter.path) - 1
+    if iter.pos[level] >= iter.path[level].nkeys() {
+        return // already past the end
+    }
+    if !iterNext(iter, level) {
+        iter.pos[level] = iter.path[level].nkeys()
+    }
+}
+
+// move backward, before the first key the iterator becomes invalid.
+func (iter *BIter) Prev() {
+    if len(iter.path) == 0 || iter.err != nil {
+        return
+    }
+    defer recoverPageError(&iter.err)
+    iterPrev(iter, len(iter.path) - 1)
+}
+
+// whether the iterator points to a key
+func (iter *BIter) Valid() bool {
+    if len(iter.path) == 0 || iter.err != nil {
+        return false // empty tree or stopped
+    }
+    leaf := iter.path[len(iter.path) - 1]
+    pos := iter.pos[len(iter.pos) - 1]
+    // the dummy first key is empty, it's never visible to callers
+    return pos < leaf.nkeys() && len(leaf.getKey(pos)) > 0
+}
+
+// the error that stopped the iterator, if any
+func (iter *BIter) Err() error {
+    return iter.err
+}
+
+// the key at the current position
+func (iter *BIter) Key() []byte {
+    util.Assert(iter.Valid())
+    leaf := iter.path[len(iter.path) - 1]
+    return leaf.getKey(iter.pos[len(iter.pos) - 1])
+}
+
+// the value at the current position.
+// a corrupt overflow page stops the iterator and yields nil.
+func (iter *BIter) Val() (val []byte) {
+    util.Assert(iter.Valid())
+    defer recoverPageError(&iter.err)
+    leaf := iter.path[len(iter.path) - 1]
+    return leafGetVal(iter.tree, leaf, iter.pos[len(iter.pos) - 1])
+}
@@ -0,0 +1,132 @@
+package b_tree
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIterEmptyTree(t *testing.T) {
+    container := newC()
+    iter := container.tree.SeekLE([]byte("a"))
+    assert.False(t, iter.Valid())
+    iter.Next()
+    assert.False(t, iter.Valid())
+    iter.Prev()
+    assert.False(t, iter.Valid())
+
+    // only the dummy key is left
+    container.tree.Insert([]byte("a"), []byte("a"))
+    container.tree.DeleteKey([]byte("a"))
+    iter = container.tree.SeekLE([]byte("a"))
+    assert.False(t, iter.Valid())
+    iter.Next()
+    assert.False(t, iter.Valid())
+}
+
+func TestIterSeekLE(t *testing.T) {
+    container := newC()
+    // 0, 2, 4, ... 198
+    for i := 0; i < 200; i += 2 {
+        key := []byte(fmt.Sprintf("key%03d", i))
+        container.tree.Insert(key, make([]byte, 100))
+    }
+    // the tree has more than one level
+    assert.Equal(t, container.tree.Get(container.tree.Root).btype(), uint16(BNODE_NODE))
+
+    iter := container.tree.SeekLE([]byte("key010"))
+    assert.True(t, iter.Valid())
+    assert.Equal(t, iter.Key(), []byte("key010"))
+
+    iter = container.tree.SeekLE([]byte("key011"))
+    assert.True(t, iter.Valid())
+    assert.Equal(t, iter.Key(), []byte("key010"))
+
+    iter = container.tree.SeekLE([]byte("zzz"))
+    assert.True(t, iter.Valid())
+    assert.Equal(t, iter.Key(), []byte("key198"))
+
+    // less than all keys, lands on the dummy key
+    iter = container.tree.SeekLE([]byte("a"))
+    assert.False(t, iter.Valid())
+    iter.Next()
+    assert.True(t, iter.Valid())
+    assert.Equal(t, iter.Key(), []byte("key000"))
+}
+
+func TestIterNextPrev(t *testing.T) {
+    container := newC()
+    n := 300
+    for i := 0; i < n; i++ {
+        key := []byte(fmt.Sprintf("key%03d", i))
+        val := []byte(fmt.Sprintf("val%03d", i))
+        container.tree.Insert(key, append(val, make([]byte, 100)...))
+    }
+
+    // forward
+    iter := container.tree.SeekLE([]byte("key000"))
+    for i := 0; i < n; i++ {
+        assert.True(t, iter.Valid())
+        assert.Equal(t, iter.Key(), []byte(fmt.Sprintf("key%03d", i)))
+        assert.Equal(t, iter.Val()[:6], []byte(fmt.Sprintf("val%03d", i)))
+        iter.Next()
+    }
+    assert.False(t, iter.Valid())
+    iter.Next()
+    assert.False(t, iter.Valid())
+
+    // backward from past the end
+    for i := n - 1; i >= 0; i-- {
+        iter.Prev()
+        assert.True(t, iter.Valid())
+        assert.Equal(t, iter.Key(), []byte(fmt.Sprintf("key%03d", i)))
+    }
+    iter.Prev()
+    assert.False(t, iter.Valid())
+    iter.Prev()
+    assert.False(t, iter.Valid())
+    iter.Next()
+    assert.Equal(t, iter.Key(), []byte("key000"))
+}
+
+func TestIterSeekGEFirstLast(t *testing.T) {
+    container := newC()
+    assert.False(t, container.tree.First().Valid())
+    assert.False(t, container.tree.Last().Valid())
+    assert.False(t, container.tree.SeekGE([]byte("a")).Valid())
+
+    // 0, 2, 4, ... 198
+    for i := 0; i < 200; i += 2 {
+        key := []byte(fmt.Sprintf("key%03d", i))
+        container.tree.Insert(key, make([]byte, 100))
+    }
+    assert.Equal(t, container.tree.First().Key(), []byte("key000"))
+    iter := container.tree.Last()
+    assert.Equal(t, iter.Key(), []byte("key198"))
+    iter.Next()
+    assert.False(t, iter.Valid())
+
+    iter = container.tree.SeekGE([]byte("key010"))
+    assert.Equal(t, iter.Key(), []byte("key010"))
+    iter = container.tree.SeekGE([]byte("key011"))
+    assert.Equal(t, iter.Key(), []byte("key012"))
+    iter = container.tree.SeekGE([]byte("a"))
+    assert.Equal(t, iter.Key(), []byte("key000"))
+    assert.False(t, container.tree.SeekGE([]byte("key199")).Valid())
+
+    // descending from the last key
+    count := 0
+    for iter := container.tree.Last(); iter.Valid(); iter.Prev() {
+        assert.Equal(t, iter.Key(), []byte(fmt.Sprintf("key%03d", 198 - count * 2)))
+        count++
+    }
+    assert.Equal(t, count, 100)
+
+    // only the dummy key is left
+    container = newC()
+    container.tree.Insert([]byte("a"), []byte("a"))
+    container.tree.DeleteKey([]byte("a"))
+    assert.False(t, container.tree.First().Valid())
+    assert.False(t, container.tree.Last().Valid())
+}
@@ -0,0 +1,111 @@
+package b_tree
+
+import (
+	"encoding/binary"
+)
+
+// values larger than BTREE_MAX_VALUE_SIZE are stored in a chain of overflow
+// pages, the leaf keeps a reference to the chain as its value and flags it
+// in vlen.
+// overflow page format:
+// | type | unused | next | data |
+// |  2B  |   2B   |  8B  | ...  |
+// reference format:
+// | size | first page |
+// |  8B  |     8B     |
+const (
+    OVERFLOW_HEADER = 12
+    OVERFLOW_CAP = BTREE_NODE_SIZE - OVERFLOW_HEADER
+    OVERFLOW_REF_SIZE = 16
+)
+
+// write a large value to overflow pages and return the reference
+func overflowWrite(tree *BTree, val []byte) []byte {
+    // allocate from the last page so that each page knows the next one
+    next := uint64(0)
+    npages := (len(val) + OVERFLOW_CAP - 1) / OVERFLOW_CAP
+    for i := npages - 1; i >= 0; i-- {
+        page := BNode{Data: make([]byte, BTREE_PAGE_SIZE)}
+        binary.LittleEndian.PutUint16(page.Data, BNODE_OVERFLOW)
+        binary.LittleEndian.PutUint64(page.Data[4:], next)
+        copy(page.Data[OVERFLOW_HEADER:], val[i * OVERFLOW_CAP:])
+        next = tree.New(page)
+    }
+
+    ref := make([]byte, OVERFLOW_REF_SIZE)
+    binary.LittleEndian.PutUint64(ref[0:], uint64(len(val)))
+    binary.LittleEndian.PutUint64(ref[8:], next)
+    return ref
+}
+
+// dereference an overflow page and validate it
+func (tree *BTree) getOverflow(ptr uint64) BNode {
+    page := tree.Get(ptr)
+    if len(page.Data) < BTREE_PAGE_SIZE {
+        panic(corruption("page %d: bad pointer", ptr))
+    }
+    if page.btype() != BNODE_OVERFLOW {
+        panic(corruption("page %d: not an overflow page", ptr))
+    }
+    return page
+}
+
+// visit the pages of a reference in order
+func overflowWalk(tree *BTree, ref []byte, fn func(ptr uint64, data []byte)) {
+    size := binary.LittleEndian.Uint64(ref[0:])
+    ptr := binary.LittleEndian.Uint64(ref[8:])
+    if size <= BTREE_MAX_VALUE_SIZE || size > BTREE_MAX_LARGE_VALUE_SIZE {
+        panic(corruption("bad overflow value size %d", size))
+    }
+    for remain := int(size); remain > 0; {
+        page := tree.getOverflow(ptr)
+        n := remain
+        if n > OVERFLOW_CAP {
+            n = OVERFLOW_CAP
+        }
+        next := binary.LittleEndian.Uint64(page.Data[4:])
+        fn(ptr, page.Data[OVERFLOW_HEADER:][:n])
+        remain -= n
+        ptr = next
+    }
+}
+
+// read a large value back
+func overflowRead(tree *BTree, ref []byte) []byte {
+    val := make([]byte, 0, binary.LittleEndian.Uint64(ref[0:]))
+    overflowWalk(tree, ref, func(ptr uint64, data []byte) {
+        val = append(val, data...)
+    })
+    return val
+}
+
+// deallocate the pages of a large value
+func overflowFree(tree *BTree, ref []byte) {
+    overflowWalk(tree, ref, func(ptr uint64, data []byte) {
+        tree.Del(ptr)
+    })
+}
+
+// the value to store in a leaf, either the value itself or a reference
+// to overflow pages. the caller flags the latter with setOverflow.
+func leafStoreVal(tree *BTree, val []byte) []byte {
+    if len(val) > BTREE_MAX_VALUE_SIZE {
+        return overflowWrite(tree, val)
+    }
+    return val
+}
+
+// the value of a leaf KV pair, following the overflow reference if any
+func leafGetVal(tree *BTree, node BNode, idx uint16) []byte {
+    if node.isOverflow(idx) {
+        return overflowRead(tree, node.getVal(idx))
+    }
+    return node.getVal(idx)
+}
+
+// deallocate the overflow pages of a leaf KV pair being removed
+func leafFreeVal(tree *BTree, node BNode, idx uint16) {
+    if node.isOverflow(idx) {
+        overflowFree(tree, node.getVal(idx))
+    }
+}
@@ -0,0 +1,110 @@
+package b_tree
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func largeVal(size int, seed byte) []byte {
+    val := make([]byte, size)
+    for i := range val {
+        val[i] = byte(i) + seed
+    }
+    return val
+}
+
+func TestOverflowInsertGet(t *testing.T) {
+    container := newC()
+    tree := &container.tree
+
+    // the first key of an empty tree
+    big := largeVal(3 * OVERFLOW_CAP + 10, 1)
+    assert.Nil(t, tree.Insert([]byte("a"), big))
+    // the leaf plus 4 overflow pages
+    assert.Equal(t, len(container.pages), 5)
+
+    // at the inline limit and just above it
+    inline := largeVal(BTREE_MAX_VALUE_SIZE, 2)
+    above := largeVal(BTREE_MAX_VALUE_SIZE + 1, 3)
+    assert.Nil(t, tree.Insert([]byte("b"), inline))
+    assert.Nil(t, tree.Insert([]byte("c"), above))
+
+    for key, want := range map[string][]byte{"a": big, "b": inline, "c": above} {
+        val, found, err := tree.GetKey([]byte(key))
+        assert.Nil(t, err)
+        assert.True(t, found)
+        assert.True(t, bytes.Equal(val, want), key)
+    }
+}
+
+func TestOverflowUpdateDelete(t *testing.T) {
+    container := newC()
+    tree := &container.tree
+    for i := 0; i < 20; i++ {
+        key := []byte(fmt.Sprintf("key%02d", i))
+        assert.Nil(t, tree.Insert(key, []byte("small")))
+    }
+    base := len(container.pages)
+
+    // the overflow pages are freed on update and delete
+    key := []byte("key05")
+    assert.Nil(t, tree.Insert(key, largeVal(50000, 1)))
+    assert.Nil(t, tree.Insert(key, largeVal(20000, 2)))
+    assert.Equal(t, len(container.pages), base + (20000 + OVERFLOW_CAP - 1) / OVERFLOW_CAP)
+    val, _, _ := tree.GetKey(key)
+    assert.True(t, bytes.Equal(val, largeVal(20000, 2)))
+
+    assert.Nil(t, tree.Insert(key, []byte("small again")))
+    assert.Equal(t, len(container.pages), base)
+
+    assert.Nil(t, tree.Insert(key, largeVal(20000, 3)))
+    assert.True(t, mustDelete(t, tree, key))
+    _, found, _ := tree.GetKey(key)
+    assert.False(t, found)
+    assert.Equal(t, len(container.pages), base)
+}
+
+func TestOverflowIter(t *testing.T) {
+    container := newC()
+    tree := &container.tree
+    for i := 0; i < 10; i++ {
+        key := []byte(fmt.Sprintf("key%02d", i))
+        assert.Nil(t, tree.Insert(key, largeVal(5000 + i, byte(i))))
+    }
+    iter := tree.SeekLE([]byte("key00"))
+    for i := 0; i < 10; i++ {
+        assert.True(t, iter.Valid())
+        assert.True(t, bytes.Equal(iter.Val(), largeVal(5000 + i, byte(i))))
+        iter.Next()
+    }
+    assert.False(t, iter.Valid())
+    assert.Nil(t, iter.Err())
+}
+
+func TestOverflowCorrupt(t *testing.T) {
+    container := newC()
+    tree := &container.tree
+    key := []byte("key")
+    assert.Nil(t, tree.Insert(key, largeVal(10000, 1)))
+
+    // find the first overflow page through the leaf reference
+    leaf := tree.Get(tree.Root)
+    assert.True(t, leaf.isOverflow(1))
+    ptr := binary.LittleEndian.Uint64(leaf.getVal(1)[8:])
+    page := container.pages[ptr]
+    binary.LittleEndian.PutUint16(page.Data, BNODE_LEAF)
+
+    _, _, err := tree.GetKey(key)
+    assert.ErrorIs(t, err, ErrCorruptPage)
+    assert.Contains(t, err.Error(), fmt.Sprintf("page %d", ptr))
+
+    iter := tree.SeekLE(key)
+    assert.True(t, iter.Valid())
+    assert.Nil(t, iter.Val())
+    assert.ErrorIs(t, iter.Err(), ErrCorruptPage)
+    assert.False(t, iter.Valid())
+}
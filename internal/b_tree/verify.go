@@ -0,0 +1,168 @@
+package b_tree
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+// structural checks of a whole tree for the offline verifier.
+// unlike the normal operations, problems are collected instead of stopping
+// at the first one, a damaged node is reported and not descended into.
+type verifier struct {
+    tree     *BTree
+    npages   uint64
+    mark     func(ptr uint64) bool
+    problems []error
+    depth    int // depth of the leaves, -1 until the first leaf
+}
+
+func (v *verifier) report(ptr uint64, format string, args ...interface{}) {
+    v.problems = append(v.problems, fmt.Errorf(
+        "%w: page %d: %s", ErrCorruptPage, ptr, fmt.Sprintf(format, args...),
+    ))
+}
+
+// dereference a pointer, returns false if the Get callback reported the
+// page as corrupt (see CorruptPage)
+func (v *verifier) get(ptr uint64) (node BNode, ok bool) {
+    defer func() {
+        if r := recover(); r != nil {
+            perr, isPageError := r.(pageError)
+            if !isPageError {
+                panic(r)
+            }
+            v.problems = append(v.problems, perr.err)
+            node, ok = BNode{}, false
+        }
+    }()
+    return v.tree.Get(ptr), true
+}
+
+// check a pointer stored in page `from`, returns false if it can't be followed
+func (v *verifier) follow(from uint64, ptr uint64) bool {
+    if ptr == 0 || ptr >= v.npages {
+        v.report(from, "pointer %d out of range", ptr)
+        return false
+    }
+    if !v.mark(ptr) {
+        v.report(ptr, "referenced more than once, again by page %d", from)
+        return false
+    }
+    return true
+}
+
+// Verify walks the whole tree and returns every problem found.
+// pointers must be in [1, npages). `mark` is called on every page reachable
+// from the root, including overflow pages, it returns false for a page that
+// is already marked, so the caller can also detect pages shared with other
+// structures.
+// the checks: node types and offsets (nodeCheck), keys sorted within a
+// node and within the range of the parent, the first key of a node equals
+// its key in the parent (the root starts with the empty key), all leaves at
+// the same depth, overflow chains matching the value sizes, and the key
+// counts of internal nodes with `Counted`.
+func (tree *BTree) Verify(npages uint64, mark func(ptr uint64) bool) []error {
+    v := &verifier{tree: tree, npages: npages, mark: mark, depth: -1}
+    if tree.Root != 0 && v.follow(0, tree.Root) {
+        v.verifyNode(tree.Root, 0, []byte{}, nil)
+    }
+    return v.problems
+}
+
+// check the subtree at `ptr`, whose keys must be in [lo, hi).
+// a nil `hi` means no upper bound. returns the number of keys found, or
+// false if the subtree is damaged and the count is unknown.
+func (v *verifier) verifyNode(ptr uint64, depth int, lo []byte, hi []byte) (uint64, bool) {
+    node, ok := v.get(ptr)
+    if !ok {
+        return 0, false
+    }
+    if err := nodeCheck(node); err != nil {
+        v.report(ptr, "%s", err.Error())
+        return 0, false
+    }
+
+    nkeys := node.nkeys()
+    if !bytes.Equal(node.getKey(0), lo) {
+        v.report(ptr, "the first key doesn't match the parent key")
+    }
+    for i := uint16(0); i < nkeys; i++ {
+        key := node.getKey(i)
+        if i > 0 && bytes.Compare(node.getKey(i - 1), key) >= 0 {
+            v.report(ptr, "key %d out of order", i)
+        }
+        if hi != nil && bytes.Compare(key, hi) >= 0 {
+            v.report(ptr, "key %d not less than the next parent key", i)
+        }
+    }
+
+    count, known := uint64(0), true
+    switch node.btype() {
+    case BNODE_LEAF:
+        count = uint64(nkeys)
+        if v.depth == -1 {
+            v.depth = depth
+        } else if depth != v.depth {
+            v.report(ptr, "leaf at depth %d, other leaves at %d", depth, v.depth)
+        }
+        for i := uint16(0); i < nkeys; i++ {
+            if node.isOverflow(i) {
+                v.verifyOverflow(ptr, i, node.getVal(i))
+            }
+        }
+    case BNODE_NODE:
+        for i := uint16(0); i < nkeys; i++ {
+            val := node.getVal(i)
+            if v.tree.Counted && len(val) != 8 {
+                v.report(ptr, "internal node without a key count at key %d", i)
+            } else if !v.tree.Counted && len(val) != 0 {
+                v.report(ptr, "internal node with a value at key %d", i)
+            }
+            next := hi
+            if i + 1 < nkeys {
+                next = node.getKey(i + 1)
+            }
+            kid := node.getPtr(i)
+            if !v.follow(ptr, kid) {
+                known = false
+                continue
+            }
+            kidKeys, ok := v.verifyNode(kid, depth + 1, node.getKey(i), next)
+            known = known && ok
+            if ok && len(val) == 8 && binary.LittleEndian.Uint64(val) != kidKeys {
+                v.report(ptr, "key %d: key count %d, the kid has %d keys",
+                    i, binary.LittleEndian.Uint64(val), kidKeys)
+            }
+            count += kidKeys
+        }
+    }
+    return count, known
+}
+
+// check the overflow chain of key `idx` in leaf `from`
+func (v *verifier) verifyOverflow(from uint64, idx uint16, ref []byte) {
+    size := binary.LittleEndian.Uint64(ref[0:])
+    ptr := binary.LittleEndian.Uint64(ref[8:])
+    if size <= BTREE_MAX_VALUE_SIZE || size > BTREE_MAX_LARGE_VALUE_SIZE {
+        v.report(from, "key %d: bad overflow value size %d", idx, size)
+        return
+    }
+    for remain := int(size); remain > 0; remain -= OVERFLOW_CAP {
+        if !v.follow(from, ptr) {
+            return
+        }
+        page, ok := v.get(ptr)
+        if !ok {
+            return
+        }
+        if len(page.Data) < BTREE_PAGE_SIZE || page.btype() != BNODE_OVERFLOW {
+            v.report(ptr, "not an overflow page")
+            return
+        }
+        from, ptr = ptr, binary.LittleEndian.Uint64(page.Data[4:])
+    }
+    if ptr != 0 {
+        v.report(from, "overflow chain longer than the value")
+    }
+}
@@ -0,0 +1,106 @@
+package b_tree
+
+import (
+	"encoding/binary"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// a tree in a page array, so that pointers are small and bounded
+func newVerifyTree(t *testing.T, n int) (*BTree, *[]BNode) {
+    pages := []BNode{{}} // page 0 is reserved like the master page
+    tree := &BTree{
+        Get: func(ptr uint64) BNode {
+            if ptr >= uint64(len(pages)) {
+                return BNode{}
+            }
+            return pages[ptr]
+        },
+        New: func(node BNode) uint64 {
+            pages = append(pages, node)
+            return uint64(len(pages) - 1)
+        },
+        Del: func(ptr uint64) {},
+    }
+    for i := 0; i < n; i++ {
+        key := []byte(fmt.Sprintf("key%03d", i))
+        assert.Nil(t, tree.Insert(key, make([]byte, 100)))
+    }
+    assert.Nil(t, tree.Insert([]byte("big"), make([]byte, 10000)))
+    return tree, &pages
+}
+
+func verifyAll(tree *BTree, npages int) []error {
+    seen := map[uint64]bool{}
+    return tree.Verify(uint64(npages), func(ptr uint64) bool {
+        if seen[ptr] {
+            return false
+        }
+        seen[ptr] = true
+        return true
+    })
+}
+
+func TestVerifyOK(t *testing.T) {
+    tree, pages := newVerifyTree(t, 200)
+    assert.Empty(t, verifyAll(tree, len(*pages)))
+    assert.Empty(t, verifyAll(&BTree{}, 1))
+}
+
+func TestVerifyProblems(t *testing.T) {
+    tree, pages := newVerifyTree(t, 200)
+    root := tree.Get(tree.Root)
+    assert.Equal(t, root.btype(), uint16(BNODE_NODE))
+    assert.Greater(t, root.nkeys(), uint16(3))
+    assert.Equal(t, tree.Get(root.getPtr(0)).btype(), uint16(BNODE_LEAF))
+
+    // two kids of the root point to the same page
+    kid1 := root.getPtr(1)
+    root.setPtr(2, kid1)
+    // a pointer out of range
+    root.setPtr(3, uint64(len(*pages)))
+    // a leaf with an unordered key
+    idx := uint16(4)
+    for tree.Get(root.getPtr(idx)).nkeys() < 3 {
+        idx++
+    }
+    leaf := tree.Get(root.getPtr(idx))
+    copy(leaf.getKey(2), leaf.getKey(1))
+
+    problems := verifyAll(tree, len(*pages))
+    msgs := ""
+    for _, p := range problems {
+        assert.ErrorIs(t, p, ErrCorruptPage)
+        msgs += p.Error() + "\n"
+    }
+    assert.Len(t, problems, 3, msgs)
+    assert.Contains(t, msgs, fmt.Sprintf("page %d: key 2 out of order", root.getPtr(idx)))
+    assert.Contains(t, msgs, fmt.Sprintf("page %d: referenced more than once, again by page %d", kid1, tree.Root))
+    assert.Contains(t, msgs, fmt.Sprintf("page %d: pointer %d out of range", tree.Root, len(*pages)))
+
+    // the first key of a kid doesn't match the parent key
+    tree, pages = newVerifyTree(t, 200)
+    root = tree.Get(tree.Root)
+    kid := tree.Get(root.getPtr(1))
+    kid.getKey(0)[0] = 'a'
+    problems = verifyAll(tree, len(*pages))
+    assert.Len(t, problems, 1)
+    assert.ErrorContains(t, problems[0], fmt.Sprintf("page %d: the first key doesn't match", root.getPtr(1)))
+}
+
+func TestVerifyOverflow(t *testing.T) {
+    tree, pages := newVerifyTree(t, 10)
+    leaf := tree.Get(tree.Root)
+    idx := nodeLookLE(leaf, []byte("big"))
+    assert.True(t, leaf.isOverflow(idx))
+    first := binary.LittleEndian.Uint64(leaf.getVal(idx)[8:])
+
+    // the second page of the chain is not an overflow page
+    second := binary.LittleEndian.Uint64((*pages)[first].Data[4:])
+    (*pages)[second].Data[0] = BNODE_LEAF
+    problems := verifyAll(tree, len(*pages))
+    assert.Len(t, problems, 1)
+    assert.ErrorContains(t, problems[0], fmt.Sprintf("page %d: not an overflow page", second))
+}
@@ -0,0 +1,75 @@
+package kvstore
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math"
+)
+
+// read-modify-write updates. they run in a transaction, which holds the
+// writer lock, so no other update can come in between.
+
+var (
+    ErrNotInt64 = errors.New("value is not an int64")
+    ErrOverflow = errors.New("int64 overflow")
+)
+
+// set the key to `val` only if its value equals `expected`, a nil
+// `expected` matches a missing key. returns whether the value is swapped.
+func (db *KV) CompareAndSwap(key []byte, expected []byte, val []byte) (bool, error) {
+    tx := db.Begin()
+    swapped, err := tx.CompareAndSwap(key, expected, val)
+    if err != nil || !swapped {
+        tx.Abort()
+        return false, err
+    }
+    return true, tx.Commit()
+}
+
+func (tx *KVTX) CompareAndSwap(key []byte, expected []byte, val []byte) (bool, error) {
+    cur, found, err := tx.Get(key)
+    if err != nil {
+        return false, err
+    }
+    if expected == nil && found {
+        return false, nil
+    }
+    if expected != nil && (!found || !bytes.Equal(cur, expected)) {
+        return false, nil
+    }
+    return true, tx.Set(key, val)
+}
+
+// add `delta` to the int64 value of the key and return the result.
+// a missing key counts as 0, values are 8 bytes in little endian.
+func (db *KV) Increment(key []byte, delta int64) (int64, error) {
+    tx := db.Begin()
+    n, err := tx.Increment(key, delta)
+    if err != nil {
+        tx.Abort()
+        return 0, err
+    }
+    return n, tx.Commit()
+}
+
+func (tx *KVTX) Increment(key []byte, delta int64) (int64, error) {
+    cur, found, err := tx.Get(key)
+    if err != nil {
+        return 0, err
+    }
+    n := int64(0)
+    if found {
+        if len(cur) != 8 {
+            return 0, ErrNotInt64
+        }
+        n = int64(binary.LittleEndian.Uint64(cur))
+    }
+    if (delta > 0 && n > math.MaxInt64 - delta) || (delta < 0 && n < math.MinInt64 - delta) {
+        return 0, ErrOverflow
+    }
+    n += delta
+    val := make([]byte, 8)
+    binary.LittleEndian.PutUint64(val, uint64(n))
+    return n, tx.Set(key, val)
+}
@@ -0,0 +1,79 @@
+package kvstore
+
+import (
+	"encoding/binary"
+	"math"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKVCompareAndSwap(t *testing.T) {
+    db := newTestKV(t)
+    key := []byte("lease")
+    // nil matches a missing key
+    swapped, err := db.CompareAndSwap(key, nil, []byte("node1"))
+    assert.Nil(t, err)
+    assert.True(t, swapped)
+    swapped, err = db.CompareAndSwap(key, nil, []byte("node2"))
+    assert.Nil(t, err)
+    assert.False(t, swapped)
+    swapped, err = db.CompareAndSwap(key, []byte("node2"), []byte("node3"))
+    assert.Nil(t, err)
+    assert.False(t, swapped)
+    swapped, err = db.CompareAndSwap(key, []byte("node1"), []byte("node2"))
+    assert.Nil(t, err)
+    assert.True(t, swapped)
+    // an empty value is not a missing key
+    swapped, err = db.CompareAndSwap([]byte("missing"), []byte{}, []byte("v"))
+    assert.Nil(t, err)
+    assert.False(t, swapped)
+
+    db = reopen(t, db)
+    val, _, err := db.Get(key)
+    assert.Nil(t, err)
+    assert.Equal(t, val, []byte("node2"))
+}
+
+func TestKVIncrement(t *testing.T) {
+    db := newTestKV(t)
+    key := []byte("counter")
+    n, err := db.Increment(key, 5)
+    assert.Nil(t, err)
+    assert.Equal(t, n, int64(5))
+    n, err = db.Increment(key, -7)
+    assert.Nil(t, err)
+    assert.Equal(t, n, int64(-2))
+    val, _, err := db.Get(key)
+    assert.Nil(t, err)
+    assert.Equal(t, int64(binary.LittleEndian.Uint64(val)), int64(-2))
+
+    assert.Nil(t, db.Set([]byte("text"), []byte("12")))
+    _, err = db.Increment([]byte("text"), 1)
+    assert.ErrorIs(t, err, ErrNotInt64)
+
+    _, err = db.Increment([]byte("big"), math.MaxInt64)
+    assert.Nil(t, err)
+    _, err = db.Increment([]byte("big"), 1)
+    assert.ErrorIs(t, err, ErrOverflow)
+    _, err = db.Increment(key, math.MinInt64)
+    assert.ErrorIs(t, err, ErrOverflow)
+
+    // concurrent increments don't lose updates
+    var wg sync.WaitGroup
+    for i := 0; i < 8; i++ {
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            for j := 0; j < 50; j++ {
+                _, err := db.Increment([]byte("shared"), 1)
+                assert.Nil(t, err)
+            }
+        }()
+    }
+    wg.Wait()
+    n, err = db.Increment([]byte("shared"), 0)
+    assert.Nil(t, err)
+    assert.Equal(t, n, int64(400))
+}
@@ -0,0 +1,46 @@
+package kvstore
+
+// a list of updates applied atomically by KV.ApplyBatch.
+// the keys and values are copied, the caller can reuse its buffers.
+type Batch struct {
+    ops []walOp
+}
+
+func (b *Batch) Set(key []byte, val []byte) {
+    b.ops = append(b.ops, walOp{
+        op: WAL_OP_SET, key: append([]byte(nil), key...), val: append([]byte(nil), val...),
+    })
+}
+
+func (b *Batch) Del(key []byte) {
+    b.ops = append(b.ops, walOp{op: WAL_OP_DEL, key: append([]byte(nil), key...)})
+}
+
+// number of updates
+func (b *Batch) Len() int {
+    return len(b.ops)
+}
+
+// empty the batch for reuse
+func (b *Batch) Reset() {
+    b.ops = b.ops[:0]
+}
+
+// apply the updates in order with a single commit. either all of them are
+// applied or none, a failed update returns its error.
+func (db *KV) ApplyBatch(b *Batch) error {
+    tx := db.Begin()
+    for _, op := range b.ops {
+        var err error
+        if op.op == WAL_OP_SET {
+            err = tx.Set(op.key, op.val)
+        } else {
+            _, err = tx.Del(op.key)
+        }
+        if err != nil {
+            tx.Abort()
+            return err
+        }
+    }
+    return tx.Commit()
+}
@@ -0,0 +1,67 @@
+package kvstore
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/connnorchen/MyDb/internal/b_tree"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKVApplyBatch(t *testing.T) {
+    db := newTestKV(t)
+    assert.Nil(t, db.Set([]byte("k0"), []byte("old")))
+    assert.Nil(t, db.Set([]byte("gone"), []byte("v")))
+
+    b := &Batch{}
+    key := []byte("k0")
+    for i := 0; i < 1000; i++ {
+        key = []byte(fmt.Sprintf("k%d", i))
+        b.Set(key, key)
+    }
+    key[0] = 'x' // copied by the batch
+    b.Del([]byte("gone"))
+    b.Del([]byte("missing"))
+    assert.Equal(t, b.Len(), 1002)
+
+    // a single commit
+    masterSeq := db.masterSeq
+    assert.Nil(t, db.ApplyBatch(b))
+    assert.Equal(t, db.masterSeq, masterSeq + 1)
+
+    db = reopen(t, db)
+    for i := 0; i < 1000; i++ {
+        key := []byte(fmt.Sprintf("k%d", i))
+        val, ok, err := db.Get(key)
+        assert.Nil(t, err)
+        assert.True(t, ok)
+        assert.Equal(t, val, key)
+    }
+    _, ok, err := db.Get([]byte("gone"))
+    assert.Nil(t, err)
+    assert.False(t, ok)
+
+    // an empty batch
+    b.Reset()
+    assert.Nil(t, db.ApplyBatch(b))
+}
+
+func TestKVApplyBatchAtomic(t *testing.T) {
+    db := newTestKV(t)
+    assert.Nil(t, db.Set([]byte("k1"), []byte("old")))
+
+    b := &Batch{}
+    b.Set([]byte("k1"), []byte("new"))
+    b.Set([]byte("k2"), []byte("v2"))
+    b.Set(make([]byte, b_tree.BTREE_MAX_KEY_SIZE + 1), nil)
+    b.Del([]byte("k1"))
+    assert.NotNil(t, db.ApplyBatch(b))
+
+    val, ok, err := db.Get([]byte("k1"))
+    assert.Nil(t, err)
+    assert.True(t, ok)
+    assert.Equal(t, val, []byte("old"))
+    _, ok, err = db.Get([]byte("k2"))
+    assert.Nil(t, err)
+    assert.False(t, ok)
+}
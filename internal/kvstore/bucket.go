@@ -0,0 +1,339 @@
+package kvstore
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/connnorchen/MyDb/internal/b_tree"
+)
+
+// named buckets, each one is a separate B-tree in the same file.
+// the catalog is another B-tree whose root is in the master page, it maps
+// bucket names to the roots of their trees (8B). the default keyspace used
+// by Get/Set/Del is not a bucket.
+// a transaction keeps the trees of the buckets it uses, and stores their
+// roots in the catalog on commit.
+// names starting with 0x00 are reserved for the KV itself (see ttl.go), they
+// can't be created or dropped by users and are not listed.
+
+var (
+    ErrBucketExists   = errors.New("bucket already exists")
+    ErrBucketNotFound = errors.New("bucket not found")
+    ErrReadOnly       = errors.New("read-only bucket")
+    ErrReservedBucket = errors.New("reserved bucket name")
+)
+
+func isReservedBucket(name []byte) bool {
+    return len(name) > 0 && name[0] == 0
+}
+
+// a bucket opened by a transaction or a reader, valid until it ends
+type Bucket struct {
+    name []byte
+    tree b_tree.BTree
+    root uint64 // the root in the catalog
+    tx   *KVTX  // nil for a reader
+    dropped bool
+}
+
+func decodeBucketRoot(val []byte) (uint64, error) {
+    if len(val) != 8 {
+        return 0, fmt.Errorf("%w: bad bucket root", b_tree.ErrCorruptPage)
+    }
+    return binary.LittleEndian.Uint64(val), nil
+}
+
+func encodeBucketRoot(root uint64) []byte {
+    val := make([]byte, 8)
+    binary.LittleEndian.PutUint64(val, root)
+    return val
+}
+
+// look up a bucket in a catalog, `tree` provides the callbacks
+func openBucket(catalog *b_tree.BTree, tree b_tree.BTree, name []byte) (*Bucket, error) {
+    val, found, err := catalog.GetKey(name)
+    if err != nil {
+        return nil, err
+    }
+    if !found {
+        return nil, ErrBucketNotFound
+    }
+    root, err := decodeBucketRoot(val)
+    if err != nil {
+        return nil, err
+    }
+    b := &Bucket{name: append([]byte(nil), name...), tree: tree, root: root}
+    b.tree.Root = root
+    return b, nil
+}
+
+// list the names in a catalog, except for the reserved ones
+func listBuckets(catalog *b_tree.BTree) ([][]byte, error) {
+    names := [][]byte{}
+    iter := catalog.First()
+    for ; iter.Valid(); iter.Next() {
+        if !isReservedBucket(iter.Key()) {
+            names = append(names, append([]byte(nil), iter.Key()...))
+        }
+    }
+    return names, iter.Err()
+}
+
+// open a bucket for reading and updating
+func (tx *KVTX) Bucket(name []byte) (*Bucket, error) {
+    if tx.err != nil {
+        return nil, tx.err
+    }
+    if b, ok := tx.buckets[string(name)]; ok {
+        return b, nil
+    }
+    b, err := openBucket(&tx.catalog, tx.tree, name)
+    if err != nil {
+        return nil, tx.check(err)
+    }
+    b.tx = tx
+    tx.buckets[string(name)] = b
+    return b, nil
+}
+
+func (tx *KVTX) CreateBucket(name []byte) error {
+    if isReservedBucket(name) {
+        return ErrReservedBucket
+    }
+    return tx.createBucket(name)
+}
+
+func (tx *KVTX) createBucket(name []byte) error {
+    if tx.err != nil {
+        return tx.err
+    }
+    _, err := tx.catalog.InsertEx(name, encodeBucketRoot(0), b_tree.MODE_INSERT_ONLY)
+    if errors.Is(err, b_tree.ErrKeyExists) {
+        return ErrBucketExists
+    }
+    if err != nil {
+        return tx.check(err)
+    }
+    tx.noTTL = false
+    tx.logBucketOp(nil, WAL_OP_CREATE_BUCKET, name, nil)
+    return nil
+}
+
+// delete a bucket and deallocate all its pages
+func (tx *KVTX) DropBucket(name []byte) error {
+    if isReservedBucket(name) {
+        return ErrReservedBucket
+    }
+    return tx.dropBucket(name)
+}
+
+func (tx *KVTX) dropBucket(name []byte) error {
+    b, err := tx.Bucket(name)
+    if err != nil {
+        return err
+    }
+    if err := tx.check(b.tree.Drop()); err != nil {
+        return err
+    }
+    if _, err := tx.catalog.DeleteKey(name); err != nil {
+        return tx.check(err)
+    }
+    delete(tx.buckets, string(name))
+    b.dropped = true
+    tx.logBucketOp(nil, WAL_OP_DROP_BUCKET, name, nil)
+    return nil
+}
+
+func (tx *KVTX) ListBuckets() ([][]byte, error) {
+    if tx.err != nil {
+        return nil, tx.err
+    }
+    names, err := listBuckets(&tx.catalog)
+    return names, tx.check(err)
+}
+
+// store the roots of the updated buckets in the catalog
+func (tx *KVTX) flushBuckets() {
+    for _, b := range tx.buckets {
+        if tx.err != nil {
+            return
+        }
+        if b.tree.Root == b.root {
+            continue
+        }
+        tx.check(tx.catalog.Insert(b.name, encodeBucketRoot(b.tree.Root)))
+        b.root = b.tree.Root
+    }
+}
+
+// open a bucket in the snapshot
+func (reader *KVReader) Bucket(name []byte) (*Bucket, error) {
+    return openBucket(&reader.catalog, reader.tree, name)
+}
+
+func (reader *KVReader) ListBuckets() ([][]byte, error) {
+    return listBuckets(&reader.catalog)
+}
+
+func (b *Bucket) Get(key []byte) ([]byte, bool, error) {
+    if b.dropped {
+        return nil, false, ErrBucketNotFound
+    }
+    if b.tx != nil && b.tx.err != nil {
+        return nil, false, b.tx.err
+    }
+    val, found, err := b.tree.GetKey(key)
+    if b.tx != nil {
+        err = b.tx.check(err)
+    }
+    return val, found, err
+}
+
+// keys in [start, end), see KV.Scan
+func (b *Bucket) Scan(start []byte, end []byte) *Scanner {
+    return newScanner(&b.tree, start, end)
+}
+
+// keys in [start, end) in descending order, see KV.ScanDesc
+func (b *Bucket) ScanDesc(start []byte, end []byte) *Scanner {
+    return newScannerDesc(&b.tree, start, end)
+}
+
+func (b *Bucket) Set(key []byte, val []byte) error {
+    tx := b.tx
+    if b.dropped {
+        return ErrBucketNotFound
+    }
+    if tx == nil {
+        return ErrReadOnly
+    }
+    if tx.err != nil {
+        return tx.err
+    }
+    if err := tx.check(b.tree.Insert(key, val)); err != nil {
+        return err
+    }
+    tx.logBucketOp(b.name, WAL_OP_SET, key, val)
+    return nil
+}
+
+// insert or update depending on the mode, see KVTX.SetEx
+func (b *Bucket) SetEx(key []byte, val []byte, mode int) (b_tree.InsertResult, error) {
+    tx := b.tx
+    if b.dropped {
+        return b_tree.InsertResult{}, ErrBucketNotFound
+    }
+    if tx == nil {
+        return b_tree.InsertResult{}, ErrReadOnly
+    }
+    if tx.err != nil {
+        return b_tree.InsertResult{}, tx.err
+    }
+    res, err := b.tree.InsertEx(key, val, mode)
+    if err != nil {
+        return res, tx.check(err)
+    }
+    tx.logBucketOp(b.name, WAL_OP_SET, key, val)
+    return res, nil
+}
+
+func (b *Bucket) Del(key []byte) (bool, error) {
+    tx := b.tx
+    if b.dropped {
+        return false, ErrBucketNotFound
+    }
+    if tx == nil {
+        return false, ErrReadOnly
+    }
+    if tx.err != nil {
+        return false, tx.err
+    }
+    deleted, err := b.tree.DeleteKey(key)
+    if err != nil {
+        return false, tx.check(err)
+    }
+    if deleted {
+        tx.logBucketOp(b.name, WAL_OP_DEL, key, nil)
+    }
+    return deleted, nil
+}
+
+// create a bucket in a single commit
+func (db *KV) CreateBucket(name []byte) error {
+    tx := db.Begin()
+    if err := tx.CreateBucket(name); err != nil {
+        tx.Abort()
+        return err
+    }
+    return tx.Commit()
+}
+
+// delete a bucket with its keys in a single commit
+func (db *KV) DropBucket(name []byte) error {
+    tx := db.Begin()
+    if err := tx.DropBucket(name); err != nil {
+        tx.Abort()
+        return err
+    }
+    return tx.Commit()
+}
+
+// the bucket names of the last commit in ascending order
+func (db *KV) ListBuckets() ([][]byte, error) {
+    reader := db.BeginRead()
+    defer reader.Close()
+    return reader.ListBuckets()
+}
+
+// read a key of a bucket from the last commit, the value is copied
+func (db *KV) BucketGet(name []byte, key []byte) ([]byte, bool, error) {
+    reader := db.BeginRead()
+    defer reader.Close()
+    b, err := reader.Bucket(name)
+    if err != nil {
+        return nil, false, err
+    }
+    val, ok, err := b.Get(key)
+    return append([]byte(nil), val...), ok, err
+}
+
+func (db *KV) BucketSet(name []byte, key []byte, val []byte) error {
+    tx := db.Begin()
+    b, err := tx.Bucket(name)
+    if err == nil {
+        err = b.Set(key, val)
+    }
+    if err != nil {
+        tx.Abort()
+        return err
+    }
+    return tx.Commit()
+}
+
+func (db *KV) BucketDel(name []byte, key []byte) (bool, error) {
+    tx := db.Begin()
+    b, err := tx.Bucket(name)
+    deleted := false
+    if err == nil {
+        deleted, err = b.Del(key)
+    }
+    if err != nil {
+        tx.Abort()
+        return false, err
+    }
+    return deleted, tx.Commit()
+}
+
+// scan a bucket of the last commit, see KV.Scan
+func (db *KV) BucketScan(name []byte, start []byte, end []byte) (*Scanner, error) {
+    reader := db.BeginRead()
+    b, err := reader.Bucket(name)
+    if err != nil {
+        reader.Close()
+        return nil, err
+    }
+    sc := b.Scan(start, end)
+    sc.reader = reader
+    return sc, nil
+}
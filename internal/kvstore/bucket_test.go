@@ -0,0 +1,210 @@
+package kvstore
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/connnorchen/MyDb/internal/b_tree"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBucket(t *testing.T) {
+    db := newTestKV(t)
+    assert.Nil(t, db.CreateBucket([]byte("users")))
+    assert.Nil(t, db.CreateBucket([]byte("orders")))
+    assert.ErrorIs(t, db.CreateBucket([]byte("users")), ErrBucketExists)
+    names, err := db.ListBuckets()
+    assert.Nil(t, err)
+    assert.Equal(t, names, [][]byte{[]byte("orders"), []byte("users")})
+
+    // the keyspaces are separate
+    assert.Nil(t, db.Set([]byte("k"), []byte("default")))
+    assert.Nil(t, db.BucketSet([]byte("users"), []byte("k"), []byte("user")))
+    assert.Nil(t, db.BucketSet([]byte("orders"), []byte("k"), []byte("order")))
+    assert.ErrorIs(t, db.BucketSet([]byte("none"), []byte("k"), []byte("v")), ErrBucketNotFound)
+    check := func(db *KV) {
+        val, ok, err := db.Get([]byte("k"))
+        assert.Nil(t, err)
+        assert.True(t, ok)
+        assert.Equal(t, val, []byte("default"))
+        val, ok, err = db.BucketGet([]byte("users"), []byte("k"))
+        assert.Nil(t, err)
+        assert.True(t, ok)
+        assert.Equal(t, val, []byte("user"))
+        val, ok, err = db.BucketGet([]byte("orders"), []byte("k"))
+        assert.Nil(t, err)
+        assert.True(t, ok)
+        assert.Equal(t, val, []byte("order"))
+    }
+    check(db)
+    db = reopen(t, db)
+    check(db)
+
+    // many keys in one transaction
+    tx := db.Begin()
+    b, err := tx.Bucket([]byte("orders"))
+    assert.Nil(t, err)
+    for i := 0; i < 1000; i++ {
+        assert.Nil(t, b.Set([]byte(fmt.Sprintf("o%04d", i)), make([]byte, 100)))
+    }
+    _, err = b.SetEx([]byte("o0000"), nil, b_tree.MODE_INSERT_ONLY)
+    assert.ErrorIs(t, err, b_tree.ErrKeyExists)
+    deleted, err := b.Del([]byte("k"))
+    assert.Nil(t, err)
+    assert.True(t, deleted)
+    assert.Nil(t, tx.Commit())
+    sc, err := db.BucketScan([]byte("orders"), []byte("o0100"), []byte("o0200"))
+    assert.Nil(t, err)
+    count := 0
+    for ; sc.Valid(); sc.Next() {
+        assert.Equal(t, sc.Key(), []byte(fmt.Sprintf("o%04d", 100 + count)))
+        count++
+    }
+    assert.Nil(t, sc.Err())
+    sc.Close()
+    assert.Equal(t, count, 100)
+
+    // readers can't update
+    reader := db.BeginRead()
+    b, err = reader.Bucket([]byte("users"))
+    assert.Nil(t, err)
+    assert.ErrorIs(t, b.Set([]byte("k"), []byte("v")), ErrReadOnly)
+    reader.Close()
+    verifyOK(t, db.Path)
+}
+
+func TestBucketDrop(t *testing.T) {
+    db := newTestKV(t)
+    assert.Nil(t, db.CreateBucket([]byte("tmp")))
+    tx := db.Begin()
+    b, err := tx.Bucket([]byte("tmp"))
+    assert.Nil(t, err)
+    for i := 0; i < 2000; i++ {
+        assert.Nil(t, b.Set([]byte(fmt.Sprintf("key%04d", i)), make([]byte, 200)))
+    }
+    assert.Nil(t, b.Set([]byte("large"), make([]byte, 20000)))
+    assert.Nil(t, tx.Commit())
+    free := db.free.Total()
+
+    // a reader keeps the bucket of its snapshot
+    reader := db.BeginRead()
+    assert.Nil(t, db.DropBucket([]byte("tmp")))
+    b, err = reader.Bucket([]byte("tmp"))
+    assert.Nil(t, err)
+    _, ok, err := b.Get([]byte("key0000"))
+    assert.Nil(t, err)
+    assert.True(t, ok)
+    reader.Close()
+
+    _, _, err = db.BucketGet([]byte("tmp"), []byte("key0000"))
+    assert.ErrorIs(t, err, ErrBucketNotFound)
+    assert.ErrorIs(t, db.DropBucket([]byte("tmp")), ErrBucketNotFound)
+    names, err := db.ListBuckets()
+    assert.Nil(t, err)
+    assert.Empty(t, names)
+    // the pages of the bucket are freed
+    assert.Nil(t, db.Set([]byte("k"), []byte("v")))
+    assert.Greater(t, db.free.Total(), free + 100)
+    verifyOK(t, db.Path)
+
+    // a handle of a dropped bucket is unusable
+    assert.Nil(t, db.CreateBucket([]byte("tmp")))
+    tx = db.Begin()
+    b, err = tx.Bucket([]byte("tmp"))
+    assert.Nil(t, err)
+    assert.Nil(t, tx.DropBucket([]byte("tmp")))
+    assert.ErrorIs(t, b.Set([]byte("k"), []byte("v")), ErrBucketNotFound)
+    tx.Abort()
+    names, err = db.ListBuckets()
+    assert.Nil(t, err)
+    assert.Equal(t, names, [][]byte{[]byte("tmp")})
+}
+
+func TestBucketWAL(t *testing.T) {
+    path := filepath.Join(t.TempDir(), "db")
+    db := newTestWAL(t, path, 0)
+    assert.Nil(t, db.CreateBucket([]byte("a")))
+    assert.Nil(t, db.CreateBucket([]byte("b")))
+    assert.Nil(t, db.Checkpoint())
+
+    // updates of several keyspaces interleaved in one record
+    tx := db.Begin()
+    a, err := tx.Bucket([]byte("a"))
+    assert.Nil(t, err)
+    assert.Nil(t, tx.CreateBucket([]byte("c")))
+    c, err := tx.Bucket([]byte("c"))
+    assert.Nil(t, err)
+    for i := 0; i < 10; i++ {
+        key := []byte(fmt.Sprintf("k%d", i))
+        assert.Nil(t, a.Set(key, []byte("a")))
+        assert.Nil(t, tx.Set(key, []byte("default")))
+        assert.Nil(t, c.Set(key, []byte("c")))
+    }
+    _, err = a.Del([]byte("k0"))
+    assert.Nil(t, err)
+    assert.Nil(t, tx.Commit())
+    assert.Nil(t, db.DropBucket([]byte("b")))
+    crash(db)
+
+    db = newTestWAL(t, path, 0)
+    names, err := db.ListBuckets()
+    assert.Nil(t, err)
+    assert.Equal(t, names, [][]byte{[]byte("a"), []byte("c")})
+    for i := 0; i < 10; i++ {
+        key := []byte(fmt.Sprintf("k%d", i))
+        val, ok, err := db.BucketGet([]byte("a"), key)
+        assert.Nil(t, err)
+        assert.Equal(t, ok, i != 0)
+        if i != 0 {
+            assert.Equal(t, val, []byte("a"))
+        }
+        val, _, err = db.Get(key)
+        assert.Nil(t, err)
+        assert.Equal(t, val, []byte("default"))
+        val, _, err = db.BucketGet([]byte("c"), key)
+        assert.Nil(t, err)
+        assert.Equal(t, val, []byte("c"))
+    }
+    db.Close()
+    verifyOK(t, path)
+}
+
+// a crash after the checkpoint wrote the master page, before the log is
+// truncated, replays the log on top of its own updates
+func TestBucketWALReplayAgain(t *testing.T) {
+    path := filepath.Join(t.TempDir(), "db")
+    db := newTestWAL(t, path, 0)
+    assert.Nil(t, db.CreateBucket([]byte("old")))
+    assert.Nil(t, db.Checkpoint())
+
+    assert.Nil(t, db.BucketSet([]byte("old"), []byte("k"), []byte("v")))
+    assert.Nil(t, db.DropBucket([]byte("old")))
+    assert.Nil(t, db.CreateBucket([]byte("new")))
+    assert.Nil(t, db.BucketSet([]byte("new"), []byte("k"), []byte("v")))
+    assert.Nil(t, db.SetWithTTL([]byte("ttl"), []byte("v"), time.Hour))
+    log, err := os.ReadFile(path + "-wal")
+    assert.Nil(t, err)
+    assert.Nil(t, db.Checkpoint())
+    crash(db)
+    assert.Nil(t, os.WriteFile(path + "-wal", log, 0644))
+
+    for i := 0; i < 2; i++ {
+        db = newTestWAL(t, path, 0)
+        names, err := db.ListBuckets()
+        assert.Nil(t, err)
+        assert.Equal(t, names, [][]byte{[]byte("new")})
+        val, _, err := db.BucketGet([]byte("new"), []byte("k"))
+        assert.Nil(t, err)
+        assert.Equal(t, val, []byte("v"))
+        val, _, err = db.Get([]byte("ttl"))
+        assert.Nil(t, err)
+        assert.Equal(t, val, []byte("v"))
+        // the replay commit is also followed by a crash before the truncation
+        crash(db)
+        assert.Nil(t, os.WriteFile(path + "-wal", log, 0644))
+    }
+    verifyOK(t, path)
+}
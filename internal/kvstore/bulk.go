@@ -0,0 +1,62 @@
+package kvstore
+
+import (
+	"io"
+)
+
+// stage at most this many pages in memory during a bulk load
+const BULK_SPILL_PAGES = 1024
+
+// replace the whole content with the KV pairs from `next`, in one commit.
+// the tree is built bottom-up, see b_tree.BulkLoader, keys must be in
+// ascending order. `next` returns io.EOF at the end of the input, another
+// error aborts the load and is returned.
+func (db *KV) BulkLoad(next func() (key []byte, val []byte, err error)) error {
+    tx := db.Begin()
+    if err := tx.bulkLoad(next); err != nil {
+        tx.Abort()
+        return err
+    }
+    return tx.Commit()
+}
+
+func (tx *KVTX) bulkLoad(next func() ([]byte, []byte, error)) error {
+    if tx.err != nil {
+        return tx.err
+    }
+    db := tx.db
+    // too many updates to log, the commit is a checkpoint with the WAL
+    tx.unlogged = true
+    // the old pages can't be reused by this transaction, so the order
+    // doesn't matter
+    if err := tx.check(tx.tree.Drop()); err != nil {
+        return err
+    }
+    // the expiries of the old keys
+    if b, err := tx.ttlBucket(false); err != nil {
+        return err
+    } else if b != nil {
+        if err := tx.dropBucket([]byte(TTL_BUCKET)); err != nil {
+            return err
+        }
+    }
+    bl := tx.tree.NewBulkLoader()
+    for {
+        key, val, err := next()
+        if err == io.EOF {
+            break
+        }
+        if err != nil {
+            return err
+        }
+        if err := tx.check(bl.Add(key, val)); err != nil {
+            return err
+        }
+        if len(db.page.updates) >= BULK_SPILL_PAGES {
+            if err := spillPages(db); err != nil {
+                return err
+            }
+        }
+    }
+    return tx.check(bl.Finish())
+}
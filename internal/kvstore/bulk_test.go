@@ -0,0 +1,129 @@
+package kvstore
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"testing"
+
+	"github.com/connnorchen/MyDb/internal/b_tree"
+	"github.com/stretchr/testify/assert"
+)
+
+// the keys key00000 .. key<n-1>, with the key as the value
+func bulkSource(n int) func() ([]byte, []byte, error) {
+    i := 0
+    return func() ([]byte, []byte, error) {
+        if i == n {
+            return nil, nil, io.EOF
+        }
+        key := []byte(fmt.Sprintf("key%05d", i))
+        i++
+        return key, key, nil
+    }
+}
+
+func TestKVBulkLoad(t *testing.T) {
+    db := newTestKV(t)
+    for i := 0; i < 100; i++ {
+        assert.Nil(t, db.Set([]byte(fmt.Sprintf("old%d", i)), make([]byte, 100)))
+    }
+    const n = 50000
+    // more pages than BULK_SPILL_PAGES
+    assert.Nil(t, db.BulkLoad(bulkSource(n)))
+    verifyOK(t, db.Path)
+
+    db = reopen(t, db)
+    _, ok, err := db.Get([]byte("old0"))
+    assert.Nil(t, err)
+    assert.False(t, ok)
+    count := 0
+    sc := db.Scan([]byte{}, nil)
+    for ; sc.Valid(); sc.Next() {
+        assert.Equal(t, sc.Key(), []byte(fmt.Sprintf("key%05d", count)))
+        assert.Equal(t, sc.Key(), sc.Val())
+        count++
+    }
+    sc.Close()
+    assert.Equal(t, count, n)
+
+    // replacing the content reuses the pages
+    flushed := db.page.flushed
+    assert.Nil(t, db.BulkLoad(bulkSource(n)))
+    assert.Nil(t, db.BulkLoad(bulkSource(n)))
+    assert.Less(t, db.page.flushed, 3 * flushed)
+    verifyOK(t, db.Path)
+
+    // an empty input clears everything
+    assert.Nil(t, db.BulkLoad(bulkSource(0)))
+    assert.Equal(t, db.tree.Root, uint64(0))
+    verifyOK(t, db.Path)
+}
+
+func TestKVBulkLoadAbort(t *testing.T) {
+    db := newTestKV(t)
+    assert.Nil(t, db.BulkLoad(bulkSource(100)))
+
+    // unsorted input
+    src := bulkSource(5000)
+    keys := 0
+    err := db.BulkLoad(func() ([]byte, []byte, error) {
+        keys++
+        if keys == 3000 {
+            return []byte("key"), nil, nil
+        }
+        return src()
+    })
+    assert.True(t, errors.Is(err, b_tree.ErrKeyOrder))
+
+    // an error from the source
+    src = bulkSource(5000)
+    keys = 0
+    failed := errors.New("source failed")
+    err = db.BulkLoad(func() ([]byte, []byte, error) {
+        keys++
+        if keys == 3000 {
+            return nil, nil, failed
+        }
+        return src()
+    })
+    assert.Equal(t, err, failed)
+
+    // unchanged
+    count := 0
+    sc := db.Scan([]byte{}, nil)
+    for ; sc.Valid(); sc.Next() {
+        count++
+    }
+    sc.Close()
+    assert.Equal(t, count, 100)
+    assert.Nil(t, db.Set([]byte("new"), []byte("v")))
+    db = reopen(t, db)
+    verifyOK(t, db.Path)
+    _, ok, err := db.Get([]byte("key00099"))
+    assert.Nil(t, err)
+    assert.True(t, ok)
+}
+
+func TestKVBulkLoadWAL(t *testing.T) {
+    path := filepath.Join(t.TempDir(), "db")
+    db := newTestWAL(t, path, 0)
+    assert.Nil(t, db.Set([]byte("old"), []byte("v")))
+    assert.Nil(t, db.BulkLoad(bulkSource(10000)))
+    // checkpointed instead of logged
+    assert.Equal(t, walSize(t, db), int64(0))
+    assert.Nil(t, db.Set([]byte("new"), []byte("v")))
+    crash(db)
+
+    verifyOK(t, path)
+    db = newTestWAL(t, path, 0)
+    _, ok, err := db.Get([]byte("old"))
+    assert.Nil(t, err)
+    assert.False(t, ok)
+    for _, key := range []string{"key00000", "key09999", "new"} {
+        _, ok, err := db.Get([]byte(key))
+        assert.Nil(t, err)
+        assert.True(t, ok)
+    }
+}
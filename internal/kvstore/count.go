@@ -0,0 +1,88 @@
+package kvstore
+
+import (
+	"github.com/connnorchen/MyDb/internal/b_tree"
+)
+
+// the number of keys in [start, end) of the last commit, a nil `end` means
+// no upper bound. it takes O(log n) unless the database is created with
+// `NoCounts`, plus the lookups of the expired keys not yet swept.
+func (db *KV) CountRange(start []byte, end []byte) (int, error) {
+    reader := db.BeginRead()
+    defer reader.Close()
+    return reader.CountRange(start, end)
+}
+
+// scan from the k-th key (from 0) of the last commit up to `end`, see Scan.
+// for pagination, the offset within a range is relative to
+// CountRange(nil, start).
+func (db *KV) ScanNth(k int, end []byte) *Scanner {
+    reader := db.BeginRead()
+    sc := reader.ScanNth(k, end)
+    sc.reader = reader
+    return sc
+}
+
+func (reader *KVReader) CountRange(start []byte, end []byte) (int, error) {
+    b, err := reader.ttlBucket()
+    if err != nil {
+        return 0, err
+    }
+    return countLive(&reader.tree, b, start, end, reader.db.now())
+}
+
+func (reader *KVReader) ScanNth(k int, end []byte) *Scanner {
+    b, err := reader.ttlBucket()
+    return newScannerNth(&reader.tree, b, k, end, reader.db.now(), err)
+}
+
+func (tx *KVTX) CountRange(start []byte, end []byte) (int, error) {
+    if tx.err != nil {
+        return 0, tx.err
+    }
+    b, err := tx.ttlBucket(false)
+    if err != nil {
+        return 0, err
+    }
+    count, err := countLive(&tx.tree, b, start, end, tx.db.now())
+    return count, tx.check(err)
+}
+
+func (tx *KVTX) ScanNth(k int, end []byte) *Scanner {
+    b, err := tx.ttlBucket(false)
+    return newScannerNth(&tx.tree, b, k, end, tx.db.now(), err)
+}
+
+// the keys in [start, end) minus the expired ones, `b` is the TTL bucket
+func countLive(
+    tree *b_tree.BTree, b *Bucket, start []byte, end []byte, now int64,
+) (int, error) {
+    count, err := tree.CountRange(start, end)
+    if err != nil || b == nil {
+        return count, err
+    }
+    expired, err := expiredKeys(b, tree, start, end, now)
+    return count - len(expired), err
+}
+
+// the k-th key that is not expired, `err` is the error of getting the TTL
+// bucket `b`
+func newScannerNth(
+    tree *b_tree.BTree, b *Bucket, k int, end []byte, now int64, err error,
+) *Scanner {
+    if err == nil && b != nil {
+        // the position in the tree, each expired key before it moves it by 1
+        var expired [][]byte
+        expired, err = expiredKeys(b, tree, nil, nil, now)
+        for i := 0; err == nil && i < len(expired); i++ {
+            var rank int
+            rank, err = tree.CountRange(nil, expired[i])
+            if rank > k {
+                break
+            }
+            k++
+        }
+    }
+    sc := &Scanner{iter: tree.Nth(k), end: end}
+    return sc.skipExpired(b, now, err)
+}
@@ -0,0 +1,68 @@
+package kvstore
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testCounts(t *testing.T, db *KV) {
+    for i := 0; i < 2000; i += 2 {
+        key := []byte(fmt.Sprintf("key%04d", i))
+        assert.Nil(t, db.Set(key, key))
+    }
+    count, err := db.CountRange(nil, nil)
+    assert.Nil(t, err)
+    assert.Equal(t, count, 1000)
+    count, err = db.CountRange([]byte("key0100"), []byte("key0201"))
+    assert.Nil(t, err)
+    assert.Equal(t, count, 51)
+    count, err = db.CountRange([]byte("key1999"), nil)
+    assert.Nil(t, err)
+    assert.Equal(t, count, 0)
+
+    // the 3rd page of 10 keys from key0100
+    offset, err := db.CountRange(nil, []byte("key0100"))
+    assert.Nil(t, err)
+    keys := []string{}
+    sc := db.ScanNth(offset + 20, nil)
+    for ; sc.Valid() && len(keys) < 10; sc.Next() {
+        keys = append(keys, string(sc.Key()))
+    }
+    sc.Close()
+    assert.Equal(t, keys[0], "key0140")
+    assert.Equal(t, keys[9], "key0158")
+    assertEmpty(t, db.ScanNth(1000, nil))
+
+    // uncommitted updates
+    tx := db.Begin()
+    _, err = tx.DeleteRange([]byte("key0100"), []byte("key0200"))
+    assert.Nil(t, err)
+    count, err = tx.CountRange(nil, nil)
+    assert.Nil(t, err)
+    assert.Equal(t, count, 950)
+    sc = tx.ScanNth(50, []byte("key0300"))
+    assert.Equal(t, sc.Key(), []byte("key0200"))
+    tx.Abort()
+    verifyOK(t, db.Path)
+}
+
+func TestKVCountRange(t *testing.T) {
+    db := newTestKV(t)
+    testCounts(t, db)
+    db = reopen(t, db)
+    assert.True(t, db.tree.Counted)
+
+    // the format is kept
+    plain := &KV{Path: db.Path + "-plain", NoCounts: true}
+    assert.Nil(t, plain.Open())
+    t.Cleanup(plain.Close)
+    testCounts(t, plain)
+    plain = reopen(t, plain)
+    assert.False(t, plain.tree.Counted)
+    assert.True(t, plain.checksums)
+    count, err := plain.CountRange(nil, nil)
+    assert.Nil(t, err)
+    assert.Equal(t, count, 1000)
+}
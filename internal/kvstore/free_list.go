@@ -0,0 +1,132 @@
+package kvstore
+
+import (
+	"encoding/binary"
+
+	"github.com/connnorchen/MyDb/internal/b_tree"
+	"github.com/connnorchen/MyDb/internal/util"
+)
+
+// the free list is a FIFO queue of unused page numbers, stored as a linked
+// list of pages. items are pushed to the tail and popped from the head.
+// node format:
+// | next | pointers | unused |
+// |  8B  |  n * 8B  |  ...   |
+//
+// an item is addressed by a monotonic sequence number, the position inside
+// a node is `seq % FREE_LIST_CAP`. the master page keeps the head and tail
+// of the list, so the list of the last commit stays intact while the
+// current update appends to the tail node in place: those slots are past the
+// persisted `tailSeq` and invisible to the old version.
+const (
+    FREE_LIST_HEADER = 8
+    FREE_LIST_CAP = (b_tree.BTREE_NODE_SIZE - FREE_LIST_HEADER) / 8
+)
+
+type FreeList struct {
+    // callbacks for managing on-disk pages
+    get func(uint64) []byte // read a page
+    new func([]byte) uint64 // append a new page
+    set func(uint64) []byte // get a writable copy of an existing page
+
+    // persisted in the master page
+    headPage uint64 // pointer to the list head node
+    headSeq  uint64 // sequence number of the first item
+    tailPage uint64 // pointer to the list tail node
+    tailSeq  uint64 // sequence number past the last item
+
+    // in-memory states
+    maxSeq uint64 // items at or after this seq can't be consumed yet
+}
+
+func flnNext(node []byte) uint64 {
+    return binary.LittleEndian.Uint64(node[0:])
+}
+
+func flnSetNext(node []byte, next uint64) {
+    binary.LittleEndian.PutUint64(node[0:], next)
+}
+
+func flnPtr(node []byte, idx int) uint64 {
+    util.Assert(idx < FREE_LIST_CAP)
+    return binary.LittleEndian.Uint64(node[FREE_LIST_HEADER + idx * 8:])
+}
+
+func flnSetPtr(node []byte, idx int, ptr uint64) {
+    util.Assert(idx < FREE_LIST_CAP)
+    binary.LittleEndian.PutUint64(node[FREE_LIST_HEADER + idx * 8:], ptr)
+}
+
+func seq2idx(seq uint64) int {
+    return int(seq % FREE_LIST_CAP)
+}
+
+// number of items in the list
+func (fl *FreeList) Total() int {
+    return int(fl.tailSeq - fl.headSeq)
+}
+
+// make the items added so far available for consumption.
+// must only be called once the pages being released are no longer
+// referenced by the persisted tree, i.e. after the commit is durable.
+func (fl *FreeList) SetMaxSeq() {
+    fl.maxSeq = fl.tailSeq
+}
+
+// remove 1 item from the head node, the head node is removed if empty.
+// returns 0 if no item can be consumed.
+func flPop(fl *FreeList) (ptr uint64, head uint64) {
+    if fl.headSeq == fl.maxSeq {
+        return 0, 0 // nothing to consume
+    }
+    node := fl.get(fl.headPage)
+    ptr = flnPtr(node, seq2idx(fl.headSeq))
+    fl.headSeq++
+    // move to the next node if the head node is used up
+    if seq2idx(fl.headSeq) == 0 {
+        head, fl.headPage = fl.headPage, flnNext(node)
+        util.Assert(fl.headPage != 0)
+    }
+    return ptr, head
+}
+
+// get 1 item from the list head. return 0 if none is available.
+func (fl *FreeList) PopHead() uint64 {
+    ptr, head := flPop(fl)
+    if head != 0 {
+        // the empty head node is recycled, it's still referenced by the
+        // last commit, so it goes to the tail like other released pages.
+        fl.PushTail(head)
+    }
+    return ptr
+}
+
+// add 1 item to the tail
+func (fl *FreeList) PushTail(ptr uint64) {
+    if fl.tailPage == 0 {
+        // the list is created on the first release
+        fl.tailPage = fl.new(make([]byte, b_tree.BTREE_PAGE_SIZE))
+        fl.headPage = fl.tailPage
+    }
+    // add it to the tail node
+    flnSetPtr(fl.set(fl.tailPage), seq2idx(fl.tailSeq), ptr)
+    fl.tailSeq++
+    // add a new tail node if it's full, so the list is never left without
+    // room for the next item
+    if seq2idx(fl.tailSeq) == 0 {
+        // try to reuse a page from the list head
+        next, head := flPop(fl)
+        if next == 0 {
+            // or allocate a new node by appending
+            next = fl.new(make([]byte, b_tree.BTREE_PAGE_SIZE))
+        }
+        // link to the new tail node
+        flnSetNext(fl.set(fl.tailPage), next)
+        fl.tailPage = next
+        // the head node that got removed is also released
+        if head != 0 {
+            flnSetPtr(fl.set(fl.tailPage), 0, head)
+            fl.tailSeq++
+        }
+    }
+}
@@ -0,0 +1,111 @@
+package kvstore
+
+import (
+	"testing"
+
+	"github.com/connnorchen/MyDb/internal/b_tree"
+	"github.com/connnorchen/MyDb/internal/util"
+	"github.com/stretchr/testify/assert"
+)
+
+type L struct {
+    free  FreeList
+    pages map[uint64][]byte
+    next  uint64
+}
+
+func newL() *L {
+    l := &L{pages: map[uint64][]byte{}, next: 1}
+    l.free.get = func(ptr uint64) []byte {
+        page, ok := l.pages[ptr]
+        util.Assert(ok)
+        return page
+    }
+    l.free.new = func(page []byte) uint64 {
+        util.Assert(len(page) == b_tree.BTREE_PAGE_SIZE)
+        ptr := l.next
+        l.next++
+        l.pages[ptr] = page
+        return ptr
+    }
+    l.free.set = func(ptr uint64) []byte {
+        page, ok := l.pages[ptr]
+        util.Assert(ok)
+        return page
+    }
+    return l
+}
+
+func TestFreeListEmpty(t *testing.T) {
+    l := newL()
+    assert.Equal(t, l.free.Total(), 0)
+    assert.Equal(t, l.free.PopHead(), uint64(0))
+}
+
+func TestFreeListPushPop(t *testing.T) {
+    l := newL()
+    for i := uint64(0); i < 10; i++ {
+        l.free.PushTail(1000 + i)
+    }
+    assert.Equal(t, l.free.Total(), 10)
+    // released pages can't be consumed before the commit
+    assert.Equal(t, l.free.PopHead(), uint64(0))
+
+    l.free.SetMaxSeq()
+    for i := uint64(0); i < 10; i++ {
+        assert.Equal(t, l.free.PopHead(), 1000 + i)
+    }
+    assert.Equal(t, l.free.PopHead(), uint64(0))
+    assert.Equal(t, l.free.Total(), 0)
+}
+
+func TestFreeListMultipleNodes(t *testing.T) {
+    l := newL()
+    n := 3 * FREE_LIST_CAP + 7
+    for i := 0; i < n; i++ {
+        l.free.PushTail(uint64(100000 + i))
+    }
+    assert.Equal(t, l.free.Total(), n)
+    // 1 initial node + 3 new tail nodes
+    assert.Equal(t, len(l.pages), 4)
+
+    l.free.SetMaxSeq()
+    popped := map[uint64]bool{}
+    for {
+        ptr := l.free.PopHead()
+        if ptr == 0 {
+            break
+        }
+        popped[ptr] = true
+    }
+    assert.Equal(t, len(popped), n)
+    // the drained head nodes are recycled into the list
+    assert.Equal(t, l.free.Total(), 3)
+
+    l.free.SetMaxSeq()
+    for i := 0; i < 3; i++ {
+        ptr := l.free.PopHead()
+        _, ok := l.pages[ptr]
+        assert.True(t, ok)
+    }
+    assert.Equal(t, l.free.PopHead(), uint64(0))
+}
+
+func TestFreeListReuseHeadForTail(t *testing.T) {
+    l := newL()
+    for i := 0; i < FREE_LIST_CAP; i++ {
+        l.free.PushTail(uint64(100000 + i))
+    }
+    l.free.SetMaxSeq()
+    // the tail node is full and the new one is appended
+    assert.Equal(t, len(l.pages), 2)
+
+    // consume one, then fill another node, the new tail node
+    // reuses an item from the head rather than appending.
+    assert.Equal(t, l.free.PopHead(), uint64(100000))
+    for i := 0; i < FREE_LIST_CAP; i++ {
+        l.free.PushTail(uint64(200000 + i))
+    }
+    assert.Equal(t, len(l.pages), 2)
+    assert.Equal(t, l.free.tailPage, uint64(100001))
+}
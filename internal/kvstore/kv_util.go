@@ -1,8 +1,10 @@
 package kvstore
 
 import (
+	"encoding/binary"
 	"errors"
 	"fmt"
+	"hash/crc32"
 	"os"
 	"syscall"
 
@@ -41,22 +43,24 @@ func mmapInit(fp *os.File) (int, []byte, error) {
 
 // extend the mmap by adding new mappings
 func extendMmap(db *KV, npages int) error {
-    if db.mmap.total >= npages * b_tree.BTREE_PAGE_SIZE {
-        return nil
-    }
-
     // double the address space, the size of the new mapping increases
     // exponetially so that we don't have to call mmap frequently.
-    chunk, err := syscall.Mmap(
-        int(db.fp.Fd()), int64(db.mmap.total), db.mmap.total,
-        syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED,
-    )
-    if err != nil {
-        return fmt.Errorf("mmap: %w", err)
-    }
+    // a large commit may need several doublings.
+    for db.mmap.total < npages * b_tree.BTREE_PAGE_SIZE {
+        chunk, err := syscall.Mmap(
+            int(db.fp.Fd()), int64(db.mmap.total), db.mmap.total,
+            syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED,
+        )
+        if err != nil {
+            return fmt.Errorf("mmap: %w", err)
+        }
 
-    db.mmap.total += db.mmap.total
-    db.mmap.chunks = append(db.mmap.chunks, chunk)
+        // existing chunks are never unmapped, readers holding them stay valid
+        db.mu.Lock()
+        db.mmap.total += db.mmap.total
+        db.mmap.chunks = append(db.mmap.chunks, chunk)
+        db.mu.Unlock()
+    }
     return nil
 }
 
@@ -97,7 +101,7 @@ func flushPages(db *KV) error {
 
 func writePages(db *KV) error {
     // extend the file & mmap if needed
-    npages := int(db.page.flushed) + len(db.page.temp)
+    npages := int(db.page.flushed + db.page.nappend)
     // file extended at a rate of 1.125
     if err := extendFile(db, npages); err != nil {
         return err
@@ -108,20 +112,66 @@ func writePages(db *KV) error {
     }
 
     // copy data to the file
-    for i, page := range db.page.temp {
-        ptr := db.page.flushed + uint64(i)
-        copy(db.pageGet(ptr).Data, page)
+    for ptr, page := range db.page.updates {
+        dst := db.pageReadFile(ptr).Data
+        copy(dst, page)
+        if db.checksums {
+            pageSeal(dst)
+        }
     }
     return nil
 }
 
+// write the staged pages before the commit, so that a large transaction
+// doesn't keep all of them in memory. they are not durable until the commit,
+// and a rollback leaves them unreferenced.
+func spillPages(db *KV) error {
+    if err := writePages(db); err != nil {
+        return err
+    }
+    db.page.updates = map[uint64][]byte{}
+    return nil
+}
+
+// pages except the master page end with a checksum, so that bit rot and
+// torn writes are detected when reading them back.
+// | data | crc32c |
+// | ...  |   4B   |
+// files of the version before it have no checksums, their nodes may use
+// the whole page.
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+func pageChecksum(page []byte) uint32 {
+    return crc32.Checksum(page[:b_tree.BTREE_NODE_SIZE], crcTable)
+}
+
+// set the checksum of a page about to be written
+func pageSeal(page []byte) {
+    binary.LittleEndian.PutUint32(page[b_tree.BTREE_NODE_SIZE:], pageChecksum(page))
+}
+
+func pageCheck(page []byte) bool {
+    return binary.LittleEndian.Uint32(page[b_tree.BTREE_NODE_SIZE:]) == pageChecksum(page)
+}
+
+// read a page from the mmap and verify it if the file has checksums,
+// a mismatch fails the BTree operation with ErrCorruptPage.
+func pageVerified(chunks [][]byte, ptr uint64, checksums bool) b_tree.BNode {
+    node := mmapPage(chunks, ptr)
+    if checksums && node.Data != nil && !pageCheck(node.Data) {
+        b_tree.CorruptPage(ptr, "checksum mismatch")
+    }
+    return node
+}
+
 func syncPages(db *KV) error {
     // flush data to the disk, must be done before updating the master page.
     if err := db.fp.Sync(); err != nil {
         return fmt.Errorf("fsync: %w", err)
     }
-    db.page.flushed += uint64(len(db.page.temp))
-    db.page.temp = db.page.temp[:0]
+    db.page.flushed += db.page.nappend
+    db.page.nappend = 0
+    db.page.updates = map[uint64][]byte{}
 
     // update & flush the master page
     if err := masterStore(db); err != nil {
@@ -3,32 +3,88 @@ package kvstore
 import (
 	"fmt"
 	"os"
+	"sync"
 	"syscall"
+	"time"
 
 	"github.com/connnorchen/MyDb/internal/b_tree"
 	"github.com/connnorchen/MyDb/internal/util"
 )
 
+// a single writer and multiple readers can use the KV concurrently.
+// transactions are serialized by the writer lock, readers work on a snapshot
+// of the last commit without blocking the writer.
 type KV struct {
     Path string
+    // optional write-ahead log, see wal.go
+    WAL bool
+    WALCheckpointSize int64 // log size that triggers a checkpoint
+    // create the database without key counts in internal nodes, which makes
+    // CountRange and ScanNth scan the keys. existing files keep their format.
+    NoCounts bool
+    // the background deletion of expired keys, see ttl.go
+    TTLSweepInterval time.Duration // 0 for TTL_SWEEP_INTERVAL, negative to disable
+    TTLSweepBatch int // keys deleted per commit, 0 for TTL_SWEEP_BATCH
     // internal
     fp *os.File
-    tree b_tree.BTree
+    tree b_tree.BTree // the last commit, owned by the writer
+    catalog b_tree.BTree // the bucket catalog of the last commit, see bucket.go
     mmap struct {
         file   int      // file size, can be larger than the database size
         total  int      // mmap size, can be larger than the file size
         chunks [][]byte // multiple mmaps, can be non-continuous
     }
     page struct {
-        flushed uint64   // database size in number of pages
-        temp    [][]byte // newly allocated pages
+        flushed uint64 // database size in number of pages
+        nappend uint64 // number of pages to be appended
+        // newly allocated or modified pages keyed by the pointer
+        updates map[uint64][]byte
     }
+    free FreeList
+    masterSeq uint64 // sequence number of the last master page
+    durableSeq uint64 // free list tail of the last master page
+    checksums bool // pages carry a checksum, by the format version
+    wal *wal // nil if the WAL is not used
+    clock func() time.Time // time.Now if nil, for tests
+    sweeper struct {
+        stop chan struct{} // nil if not running
+        done chan struct{}
+    }
+    // concurrency control
+    writer sync.Mutex // serializes transactions
+    mu     sync.Mutex // protects the states below and `mmap.chunks`
+    // the last commit as seen by new readers
+    root    uint64
+    catalogRoot uint64
+    npages  uint64 // database size in number of pages
+    tailSeq uint64 // free list tail of the last commit
+    // number of active readers keyed by the free list tail of their snapshot
+    readers map[uint64]int
 }
 
 // callback function for BTree, dereference a ptr
+// an invalid ptr yields an empty node, which the BTree reports as corrupt.
 func (db *KV) pageGet(ptr uint64) b_tree.BNode {
+    if page, ok := db.page.updates[ptr]; ok {
+        return b_tree.BNode{Data: page} // pending update
+    }
+    if ptr >= db.page.flushed + db.page.nappend {
+        return b_tree.BNode{} // past the end of the database
+    }
+    return pageVerified(db.mmap.chunks, ptr, db.checksums)
+}
+
+// read a page from the mmap, the ptr must be within the file
+func (db *KV) pageReadFile(ptr uint64) b_tree.BNode {
+    node := mmapPage(db.mmap.chunks, ptr)
+    util.Assert(node.Data != nil)
+    return node
+}
+
+// locate a page in the mmap chunks, returns an empty node if out of range
+func mmapPage(chunks [][]byte, ptr uint64) b_tree.BNode {
     start := uint64(0)
-    for _, chunk := range db.mmap.chunks {
+    for _, chunk := range chunks {
         end := start + uint64(len(chunk)) / b_tree.BTREE_PAGE_SIZE
         if ptr < end {
             offset := b_tree.BTREE_PAGE_SIZE * (ptr - start)
@@ -36,22 +92,50 @@ func (db *KV) pageGet(ptr uint64) b_tree.BNode {
         }
         start = end
     }
-    panic("bad ptr")
+    return b_tree.BNode{}
 }
 
 // callback for BTree, allocate a new page
 func (db *KV) pageNew(node b_tree.BNode) uint64 {
-    // TODO: reuse deallocated pages
-    fmt.Println(len(node.Data))
     util.Assert(len(node.Data) <= b_tree.BTREE_PAGE_SIZE)
-    ptr := db.page.flushed + uint64(len(db.page.temp))
-    db.page.temp = append(db.page.temp, node.Data)
+    // reuse a deallocated page if possible
+    ptr := db.free.PopHead()
+    if ptr == 0 {
+        return db.pageAppend(node.Data)
+    }
+    db.page.updates[ptr] = node.Data
     return ptr
 }
 
-// callback for BTree, deallocate a page
+// callback for BTree, deallocate a page.
+// the page is not reused until the current update is committed.
 func (db *KV) pageDel(ptr uint64) {
-    // TODO
+    db.free.PushTail(ptr)
+}
+
+// callback for FreeList, read a page
+func (db *KV) pageRead(ptr uint64) []byte {
+    return db.pageGet(ptr).Data
+}
+
+// callback for FreeList, allocate a new page by appending
+func (db *KV) pageAppend(page []byte) uint64 {
+    util.Assert(len(page) <= b_tree.BTREE_PAGE_SIZE)
+    ptr := db.page.flushed + db.page.nappend
+    db.page.nappend++
+    db.page.updates[ptr] = page
+    return ptr
+}
+
+// callback for FreeList, get a writable copy of an existing page
+func (db *KV) pageWrite(ptr uint64) []byte {
+    if page, ok := db.page.updates[ptr]; ok {
+        return page // pending update
+    }
+    page := make([]byte, b_tree.BTREE_PAGE_SIZE)
+    copy(page, pageVerified(db.mmap.chunks, ptr, db.checksums).Data)
+    db.page.updates[ptr] = page
+    return page
 }
 
 func (db *KV) Open() error {
@@ -75,12 +159,27 @@ func (db *KV) Open() error {
     db.tree.Get = db.pageGet
     db.tree.New = db.pageNew
     db.tree.Del = db.pageDel
+    // free list callbacks
+    db.free.get = db.pageRead
+    db.free.new = db.pageAppend
+    db.free.set = db.pageWrite
+    db.page.updates = map[uint64][]byte{}
 
     // read the master page
     err = masterLoad(db)
     if err != nil {
         goto fail
     }
+    db.readers = map[uint64]int{}
+    publish(db)
+
+    if db.WAL {
+        err = walOpen(db)
+        if err != nil {
+            goto fail
+        }
+    }
+    db.startSweeper()
 
     // done 
     return nil
@@ -90,26 +189,114 @@ fail:
     return fmt.Errorf("KV.Open: %w", err)
 }
 
-func (db *KV) Get(key []byte) ([]byte, bool) {
-    return db.tree.GetKey(key)
+// what readers need from a commit
+type commitState struct {
+    root    uint64
+    catalog uint64
+    npages  uint64
+    tailSeq uint64
+}
+
+// the state of the last commit, owned by the writer
+func currentState(db *KV) commitState {
+    return commitState{
+        root: db.tree.Root, catalog: db.catalog.Root,
+        npages: db.page.flushed, tailSeq: db.free.tailSeq,
+    }
+}
+
+// make the last commit visible to new readers
+func publish(db *KV) {
+    publishState(db, currentState(db))
+}
+
+func publishState(db *KV, state commitState) {
+    db.mu.Lock()
+    defer db.mu.Unlock()
+    db.root = state.root
+    db.catalogRoot = state.catalog
+    db.npages = state.npages
+    db.tailSeq = state.tailSeq
+}
+
+// read a key from the last commit, the value is copied
+func (db *KV) Get(key []byte) ([]byte, bool, error) {
+    reader := db.BeginRead()
+    defer reader.Close()
+    val, ok, err := reader.Get(key)
+    return append([]byte(nil), val...), ok, err
+}
+
+// scan keys in [start, end) of the last commit, a nil `end` means no
+// upper bound. the scanner holds a snapshot until Close.
+func (db *KV) Scan(start []byte, end []byte) *Scanner {
+    reader := db.BeginRead()
+    sc := reader.Scan(start, end)
+    sc.reader = reader
+    return sc
+}
+
+// like Scan, but from the last key in [start, end) to the first
+func (db *KV) ScanDesc(start []byte, end []byte) *Scanner {
+    reader := db.BeginRead()
+    sc := reader.ScanDesc(start, end)
+    sc.reader = reader
+    return sc
 }
 
 func (db *KV) Set(key []byte, val []byte) error {
-    db.tree.Insert(key, val)
-    return flushPages(db)
+    tx := db.Begin()
+    if err := tx.Set(key, val); err != nil {
+        tx.Abort()
+        return err
+    }
+    return tx.Commit()
+}
+
+// insert or update depending on the mode in a single commit,
+// see BTree.InsertEx
+func (db *KV) SetEx(key []byte, val []byte, mode int) (b_tree.InsertResult, error) {
+    tx := db.Begin()
+    res, err := tx.SetEx(key, val, mode)
+    if err != nil {
+        tx.Abort()
+        return res, err
+    }
+    return res, tx.Commit()
 }
 
 func (db *KV) Del(key []byte) (bool, error) {
-    deleted := db.tree.DeleteKey(key)
-    return deleted, flushPages(db)
+    tx := db.Begin()
+    deleted, err := tx.Del(key)
+    if err != nil {
+        tx.Abort()
+        return false, err
+    }
+    return deleted, tx.Commit()
 }
 
-// cleanups
+// delete the keys in [start, end) in a single commit, see KVTX.DeleteRange
+func (db *KV) DeleteRange(start []byte, end []byte) (int, error) {
+    tx := db.Begin()
+    count, err := tx.DeleteRange(start, end)
+    if err != nil {
+        tx.Abort()
+        return 0, err
+    }
+    return count, tx.Commit()
+}
+
+// cleanups, all readers and transactions must have ended
 func (db *KV) Close() {
+    db.stopSweeper()
+    if db.wal != nil {
+        walClose(db)
+    }
     for _, chunk := range db.mmap.chunks {
         err := syscall.Munmap(chunk)
         util.Assert(err == nil)
     }
+    db.mmap.chunks = nil
     _ = db.fp.Close()
 }
 
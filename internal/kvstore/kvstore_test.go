@@ -0,0 +1,329 @@
+package kvstore
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/connnorchen/MyDb/internal/b_tree"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestKV(t *testing.T) *KV {
+    db := &KV{Path: filepath.Join(t.TempDir(), "db")}
+    assert.Nil(t, db.Open())
+    t.Cleanup(db.Close)
+    return db
+}
+
+func reopen(t *testing.T, db *KV) *KV {
+    db.Close()
+    db2 := &KV{Path: db.Path}
+    assert.Nil(t, db2.Open())
+    t.Cleanup(db2.Close)
+    return db2
+}
+
+// the scanner starts past its range, it's closed after
+func assertEmpty(t *testing.T, sc *Scanner) {
+    assert.False(t, sc.Valid())
+    sc.Close()
+}
+
+func TestKVSetGetDel(t *testing.T) {
+    db := newTestKV(t)
+    assert.Nil(t, db.Set([]byte("k1"), []byte("v1")))
+    assert.Nil(t, db.Set([]byte("k2"), []byte("v2")))
+
+    val, ok, err := db.Get([]byte("k1"))
+    assert.Nil(t, err)
+    assert.True(t, ok)
+    assert.Equal(t, val, []byte("v1"))
+
+    deleted, err := db.Del([]byte("k1"))
+    assert.Nil(t, err)
+    assert.True(t, deleted)
+    _, ok, err = db.Get([]byte("k1"))
+    assert.Nil(t, err)
+    assert.False(t, ok)
+
+    db = reopen(t, db)
+    _, ok, err = db.Get([]byte("k1"))
+    assert.Nil(t, err)
+    assert.False(t, ok)
+    val, ok, err = db.Get([]byte("k2"))
+    assert.Nil(t, err)
+    assert.True(t, ok)
+    assert.Equal(t, val, []byte("v2"))
+}
+
+func TestKVPageReuse(t *testing.T) {
+    db := newTestKV(t)
+    for i := 0; i < 200; i++ {
+        key := []byte(fmt.Sprintf("key%d", i))
+        assert.Nil(t, db.Set(key, make([]byte, 500)))
+    }
+    used := db.page.flushed
+
+    // overwriting and deleting keys must not grow the file
+    for round := 0; round < 20; round++ {
+        for i := 0; i < 200; i++ {
+            key := []byte(fmt.Sprintf("key%d", i))
+            if round % 2 == 0 {
+                _, err := db.Del(key)
+                assert.Nil(t, err)
+            } else {
+                assert.Nil(t, db.Set(key, make([]byte, 500)))
+            }
+        }
+    }
+    assert.LessOrEqual(t, db.page.flushed, used + used / 2)
+
+    // the free list survives a reopen
+    total := db.free.Total()
+    db = reopen(t, db)
+    assert.Equal(t, db.free.Total(), total)
+    for i := 0; i < 200; i++ {
+        key := []byte(fmt.Sprintf("key%d", i))
+        val, ok, err := db.Get(key)
+        assert.Nil(t, err)
+        assert.True(t, ok)
+        assert.Equal(t, val, make([]byte, 500))
+    }
+}
+
+func TestKVLargeValue(t *testing.T) {
+    db := newTestKV(t)
+    vals := map[string][]byte{}
+    for i := 0; i < 10; i++ {
+        key := fmt.Sprintf("key%d", i)
+        val := make([]byte, 10000 * (i + 1))
+        for j := range val {
+            val[j] = byte(i + j)
+        }
+        vals[key] = val
+        assert.Nil(t, db.Set([]byte(key), val))
+    }
+
+    db = reopen(t, db)
+    for key, want := range vals {
+        val, ok, err := db.Get([]byte(key))
+        assert.Nil(t, err)
+        assert.True(t, ok)
+        assert.Equal(t, val, want)
+    }
+
+    // overflow pages are freed and reused
+    used := db.page.flushed
+    for round := 0; round < 10; round++ {
+        for key, val := range vals {
+            _, err := db.Del([]byte(key))
+            assert.Nil(t, err)
+            assert.Nil(t, db.Set([]byte(key), val))
+        }
+    }
+    assert.LessOrEqual(t, db.page.flushed, used + used / 2)
+    for key, want := range vals {
+        val, _, err := db.Get([]byte(key))
+        assert.Nil(t, err)
+        assert.Equal(t, val, want)
+    }
+}
+
+func TestKVLargeCommit(t *testing.T) {
+    // a commit growing the file more than twice the initial mmap
+    db := newTestKV(t)
+    tx := db.Begin()
+    for i := 0; i < 3; i++ {
+        val := make([]byte, 48 << 20)
+        val[i] = 1
+        assert.Nil(t, tx.Set([]byte{byte(i)}, val))
+    }
+    assert.Nil(t, tx.Commit())
+    db = reopen(t, db)
+    for i := 0; i < 3; i++ {
+        val, ok, err := db.Get([]byte{byte(i)})
+        assert.Nil(t, err)
+        assert.True(t, ok)
+        assert.Equal(t, len(val), 48 << 20)
+        assert.Equal(t, val[i], byte(1))
+    }
+}
+
+func TestKVScan(t *testing.T) {
+    db := newTestKV(t)
+    for i := 0; i < 100; i += 2 {
+        key := []byte(fmt.Sprintf("key%03d", i))
+        assert.Nil(t, db.Set(key, key))
+    }
+
+    keys := [][]byte{}
+    sc := db.Scan([]byte("key011"), []byte("key020"))
+    for ; sc.Valid(); sc.Next() {
+        assert.Equal(t, sc.Key(), sc.Val())
+        keys = append(keys, sc.Key())
+    }
+    sc.Close()
+    assert.Equal(t, keys, [][]byte{
+        []byte("key012"), []byte("key014"), []byte("key016"), []byte("key018"),
+    })
+
+    // unbounded
+    count := 0
+    sc = db.Scan([]byte("a"), nil)
+    for ; sc.Valid(); sc.Next() {
+        count++
+    }
+    sc.Close()
+    assert.Equal(t, count, 50)
+
+    // empty range
+    assertEmpty(t, db.Scan([]byte("key050"), []byte("key050")))
+}
+
+func TestKVScanDesc(t *testing.T) {
+    db := newTestKV(t)
+    assertEmpty(t, db.ScanDesc([]byte{}, nil))
+    for i := 0; i < 100; i += 2 {
+        key := []byte(fmt.Sprintf("key%03d", i))
+        assert.Nil(t, db.Set(key, key))
+    }
+
+    keys := [][]byte{}
+    sc := db.ScanDesc([]byte("key011"), []byte("key020"))
+    for ; sc.Valid(); sc.Next() {
+        assert.Equal(t, sc.Key(), sc.Val())
+        keys = append(keys, sc.Key())
+    }
+    sc.Close()
+    assert.Equal(t, keys, [][]byte{
+        []byte("key018"), []byte("key016"), []byte("key014"), []byte("key012"),
+    })
+
+    // the latest N
+    keys = [][]byte{}
+    sc = db.ScanDesc([]byte{}, nil)
+    for ; sc.Valid() && len(keys) < 3; sc.Next() {
+        keys = append(keys, sc.Key())
+    }
+    sc.Close()
+    assert.Equal(t, keys, [][]byte{
+        []byte("key098"), []byte("key096"), []byte("key094"),
+    })
+
+    // unbounded
+    count := 0
+    sc = db.ScanDesc([]byte{}, []byte("zzz"))
+    for ; sc.Valid(); sc.Next() {
+        count++
+    }
+    sc.Close()
+    assert.Equal(t, count, 50)
+
+    // empty ranges
+    assertEmpty(t, db.ScanDesc([]byte("key050"), []byte("key050")))
+    assertEmpty(t, db.ScanDesc([]byte{}, []byte("key000")))
+}
+
+func TestKVBadInput(t *testing.T) {
+    db := newTestKV(t)
+    assert.ErrorIs(t, db.Set(make([]byte, 1001), nil), b_tree.ErrKeyTooLarge)
+    assert.ErrorIs(t, db.Set([]byte("k"), make([]byte, b_tree.BTREE_MAX_LARGE_VALUE_SIZE + 1)), b_tree.ErrValueTooLarge)
+    assert.ErrorIs(t, db.Set(nil, nil), b_tree.ErrEmptyKey)
+    _, _, err := db.Get(nil)
+    assert.ErrorIs(t, err, b_tree.ErrEmptyKey)
+    _, err = db.Del(make([]byte, 1001))
+    assert.ErrorIs(t, err, b_tree.ErrKeyTooLarge)
+
+    // the database is still usable
+    assert.Nil(t, db.Set([]byte("k"), []byte("v")))
+    val, ok, err := db.Get([]byte("k"))
+    assert.Nil(t, err)
+    assert.True(t, ok)
+    assert.Equal(t, val, []byte("v"))
+}
+
+func TestKVCorruptPage(t *testing.T) {
+    db := newTestKV(t)
+    assert.Nil(t, db.Set([]byte("k"), []byte("v")))
+    root := db.tree.Root
+    path := db.Path
+    db.Close()
+
+    // an unknown node type on the root page
+    damage(t, path, int64(root) * b_tree.BTREE_PAGE_SIZE, []byte{0xff, 0xff})
+    db = reopen(t, db)
+    _, _, err := db.Get([]byte("k"))
+    assert.ErrorIs(t, err, b_tree.ErrCorruptPage)
+    assert.ErrorIs(t, db.Set([]byte("k2"), []byte("v")), b_tree.ErrCorruptPage)
+    sc := db.Scan([]byte("a"), nil)
+    assert.False(t, sc.Valid())
+    assert.ErrorIs(t, sc.Err(), b_tree.ErrCorruptPage)
+    sc.Close()
+
+    // the failed update is rolled back
+    assert.Equal(t, db.tree.Root, root)
+    assert.Empty(t, db.page.updates)
+}
+
+func TestKVChecksum(t *testing.T) {
+    db := newTestKV(t)
+    for i := 0; i < 100; i++ {
+        key := []byte(fmt.Sprintf("key%03d", i))
+        assert.Nil(t, db.Set(key, []byte(fmt.Sprintf("val%03d", i))))
+    }
+    reader := db.BeginRead()
+    node := reader.tree.Get(db.tree.Root)
+    assert.True(t, pageCheck(node.Data))
+    reader.Close()
+    root := db.tree.Root
+    path := db.Path
+    db.Close()
+
+    // a flipped bit in the unused space of the root is only caught by the
+    // checksum
+    damage(t, path, int64(root + 1) * b_tree.BTREE_PAGE_SIZE - 200, []byte{0x01})
+    db = reopen(t, db)
+    _, _, err := db.Get([]byte("key050"))
+    assert.ErrorIs(t, err, b_tree.ErrCorruptPage)
+    assert.ErrorContains(t, err, fmt.Sprintf("page %d: checksum mismatch", root))
+
+    // so is a page torn in the middle
+    db.Close()
+    damage(t, path, int64(root) * b_tree.BTREE_PAGE_SIZE + 2048, make([]byte, 2048))
+    problems, err := Verify(path)
+    assert.Nil(t, err)
+    assert.ErrorContains(t, problems[0], fmt.Sprintf("page %d: checksum mismatch", root))
+}
+
+func TestKVSetEx(t *testing.T) {
+    db := newTestKV(t)
+    res, err := db.SetEx([]byte("k"), []byte("v1"), b_tree.MODE_INSERT_ONLY)
+    assert.Nil(t, err)
+    assert.True(t, res.Added)
+    res, err = db.SetEx([]byte("k"), []byte("v2"), b_tree.MODE_INSERT_ONLY)
+    assert.ErrorIs(t, err, b_tree.ErrKeyExists)
+    assert.Equal(t, res.Old, []byte("v1"))
+    res, err = db.SetEx([]byte("k"), []byte("v2"), b_tree.MODE_UPDATE_ONLY)
+    assert.Nil(t, err)
+    assert.True(t, res.Updated)
+    assert.Equal(t, res.Old, []byte("v1"))
+    _, err = db.SetEx([]byte("missing"), []byte("v"), b_tree.MODE_UPDATE_ONLY)
+    assert.ErrorIs(t, err, b_tree.ErrKeyNotFound)
+
+    // a failed mode leaves the transaction usable
+    tx := db.Begin()
+    _, err = tx.SetEx([]byte("k"), []byte("v3"), b_tree.MODE_INSERT_ONLY)
+    assert.ErrorIs(t, err, b_tree.ErrKeyExists)
+    assert.Nil(t, tx.Set([]byte("k2"), []byte("v")))
+    assert.Nil(t, tx.Commit())
+
+    db = reopen(t, db)
+    val, ok, err := db.Get([]byte("k"))
+    assert.Nil(t, err)
+    assert.True(t, ok)
+    assert.Equal(t, val, []byte("v2"))
+    _, ok, err = db.Get([]byte("k2"))
+    assert.Nil(t, err)
+    assert.True(t, ok)
+}
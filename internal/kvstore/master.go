@@ -5,54 +5,271 @@ import (
 	"encoding/binary"
 	"errors"
 	"fmt"
+	"hash/crc32"
 
 	"github.com/connnorchen/MyDb/internal/b_tree"
 )
 
-const DB_SIG = "BuildYourOwnDB05"
+const DB_SIG = "BuildYourOwnDB06"
+// the single master page of older files, see masterDecodeLegacy
+const DB_SIG_LEGACY = "BuildYourOwnDB05"
+
+// the format version, later changes to the layout are told apart by it
+// instead of the signature. slots of older versions are read, the latest
+// one is always written. a file keeps its features so that pages written
+// later match the existing ones.
+const (
+    MASTER_VERSION_PLAIN = 1
+    MASTER_VERSION_CHECKSUMS = 2 // pages end with a checksum, see pageSeal
+    MASTER_VERSION_COUNTED = 3   // internal nodes store key counts as well
+    MASTER_VERSION_FLAGS = 4     // features as flags, the bucket catalog
+    MASTER_VERSION_LATEST = MASTER_VERSION_FLAGS
+)
+
+// feature flags of version 4
+const (
+    MASTER_FLAG_CHECKSUMS = 1 << 0
+    MASTER_FLAG_COUNTED = 1 << 1
+)
 
 // the master page format
 // it contains the pointer to the root and other important bits.
-// | sig | btree_root | page_used |
-// | 16B |     8B     |     8B    |
+// the page holds 2 slots, commits write to them alternately so that a torn
+// write only damages the slot being written, the other one still holds the
+// previous commit.
+// | slot 0 | ... | slot 1 | ... |
+// 0             2048
+//
+// slot format:
+// | sig | seq | btree_root | page_used | free_list | version | ...
+// | 16B | 8B  |     8B     |     8B    |    32B    |   4B    |
+//
+// the rest depends on the version:
+// 1~3: | crc32 |
+//      |  4B   |
+// 4:   | flags | catalog_root | crc32 |
+//      |  4B   |      8B      |  4B   |
+//
+// free_list: | head_page | head_seq | tail_page | tail_seq |
+//            |    8B     |    8B    |    8B     |    8B    |
+//
+// seq is incremented on each commit and selects the slot (seq % 2),
+// the crc32 covers everything before it.
+const (
+    MASTER_SLOT_SIZE = 92 // the latest version
+    MASTER_SLOT_DISTANCE = b_tree.BTREE_PAGE_SIZE / 2
+)
+
+// the content of a master slot
+type masterSlot struct {
+    seq  uint64
+    root uint64
+    used uint64
+    // free list
+    headPage uint64
+    headSeq  uint64
+    tailPage uint64
+    tailSeq  uint64
+    version  uint32
+    // features
+    checksums bool
+    counted   bool
+    catalog   uint64 // root of the bucket catalog, see bucket.go
+}
+
+// the slot size of a version
+func masterSlotSize(version uint32) int {
+    if version < MASTER_VERSION_FLAGS {
+        return 80
+    }
+    return MASTER_SLOT_SIZE
+}
+
+func masterEncode(slot masterSlot) []byte {
+    data := make([]byte, MASTER_SLOT_SIZE)
+    copy(data[:16], []byte(DB_SIG))
+    binary.LittleEndian.PutUint64(data[16:], slot.seq)
+    binary.LittleEndian.PutUint64(data[24:], slot.root)
+    binary.LittleEndian.PutUint64(data[32:], slot.used)
+    binary.LittleEndian.PutUint64(data[40:], slot.headPage)
+    binary.LittleEndian.PutUint64(data[48:], slot.headSeq)
+    binary.LittleEndian.PutUint64(data[56:], slot.tailPage)
+    binary.LittleEndian.PutUint64(data[64:], slot.tailSeq)
+    binary.LittleEndian.PutUint32(data[72:], MASTER_VERSION_LATEST)
+    flags := uint32(0)
+    if slot.checksums {
+        flags |= MASTER_FLAG_CHECKSUMS
+    }
+    if slot.counted {
+        flags |= MASTER_FLAG_COUNTED
+    }
+    binary.LittleEndian.PutUint32(data[76:], flags)
+    binary.LittleEndian.PutUint64(data[80:], slot.catalog)
+    crc := crc32.ChecksumIEEE(data[:88])
+    binary.LittleEndian.PutUint32(data[88:], crc)
+    return data
+}
+
+// decode and verify a slot against the number of pages in the file
+func masterDecode(data []byte, npages uint64) (masterSlot, error) {
+    slot := masterSlot{}
+    if bytes.Equal([]byte(DB_SIG_LEGACY), data[:16]) {
+        slot = masterDecodeLegacy(data)
+    } else if !bytes.Equal([]byte(DB_SIG), data[:16]) {
+        return slot, errors.New("Bad signature")
+    } else {
+        // the version is checked again after the checksum
+        size := masterSlotSize(binary.LittleEndian.Uint32(data[72:]))
+        crc := binary.LittleEndian.Uint32(data[size - 4:])
+        if crc != crc32.ChecksumIEEE(data[:size - 4]) {
+            return slot, errors.New("Bad checksum")
+        }
+        slot.seq = binary.LittleEndian.Uint64(data[16:])
+        slot.root = binary.LittleEndian.Uint64(data[24:])
+        slot.used = binary.LittleEndian.Uint64(data[32:])
+        slot.headPage = binary.LittleEndian.Uint64(data[40:])
+        slot.headSeq = binary.LittleEndian.Uint64(data[48:])
+        slot.tailPage = binary.LittleEndian.Uint64(data[56:])
+        slot.tailSeq = binary.LittleEndian.Uint64(data[64:])
+        slot.version = binary.LittleEndian.Uint32(data[72:])
+    }
+    switch {
+    case slot.version == 0 || slot.version > MASTER_VERSION_LATEST:
+        return slot, fmt.Errorf("unsupported format version %d", slot.version)
+    case slot.version < MASTER_VERSION_FLAGS:
+        slot.checksums = slot.version >= MASTER_VERSION_CHECKSUMS
+        slot.counted = slot.version >= MASTER_VERSION_COUNTED
+    default:
+        flags := binary.LittleEndian.Uint32(data[76:])
+        if flags & ^uint32(MASTER_FLAG_CHECKSUMS | MASTER_FLAG_COUNTED) != 0 {
+            return slot, fmt.Errorf("unsupported format flags %#x", flags)
+        }
+        slot.checksums = flags & MASTER_FLAG_CHECKSUMS != 0
+        slot.counted = flags & MASTER_FLAG_COUNTED != 0
+        slot.catalog = binary.LittleEndian.Uint64(data[80:])
+    }
+
+    bad := !(1 <= slot.used && slot.used <= npages)
+    bad = bad || !(0 <= slot.root && slot.root < slot.used)
+    bad = bad || !(slot.catalog < slot.used)
+    bad = bad || !(slot.headPage < slot.used && slot.tailPage < slot.used)
+    bad = bad || !(slot.headSeq <= slot.tailSeq)
+    if bad {
+        return slot, errors.New("Bad master page")
+    }
+    return slot, nil
+}
+
+// the master page before the slots, it's only ever found in slot 0:
+// | sig | btree_root | page_used | free_list |
+// | 16B |     8B     |     8B    |    32B    |
+// the free list was added without changing the signature, it's zeros in the
+// files from before it, i.e. an empty list. there's no checksum.
+// it's read as seq 0 of version 1, i.e. pages without checksums. the first
+// commit writes slot 1 and the second one replaces it.
+func masterDecodeLegacy(data []byte) masterSlot {
+    return masterSlot{
+        seq: 0,
+        root: binary.LittleEndian.Uint64(data[16:]),
+        used: binary.LittleEndian.Uint64(data[24:]),
+        headPage: binary.LittleEndian.Uint64(data[32:]),
+        headSeq: binary.LittleEndian.Uint64(data[40:]),
+        tailPage: binary.LittleEndian.Uint64(data[48:]),
+        tailSeq: binary.LittleEndian.Uint64(data[56:]),
+        version: MASTER_VERSION_PLAIN,
+    }
+}
 
 func masterLoad(db *KV) error {
     if db.mmap.file == 0 {
         // empty file, the master page will be created on the first write.
-        db.page.flushed = 1 // reserved for the master page
+        masterInit(db)
         return nil
     }
-    
+
     data := db.mmap.chunks[0]
-    root := binary.LittleEndian.Uint64(data[16:])
-    used := binary.LittleEndian.Uint64(data[24:])
-    // verified the page
-    if !bytes.Equal([]byte(DB_SIG), data[:16]) {
-        return errors.New("Bad signature")
-    }
-    bad := !(1 <= used && used <= uint64(db.mmap.file / b_tree.BTREE_PAGE_SIZE))
-    bad = bad || !(0 <= root && root < used)
-    if bad {
-        return errors.New("Bad master page")
+    if isZero(data[:MASTER_SLOT_DISTANCE + MASTER_SLOT_SIZE]) {
+        // the file was extended but the first master page was never written,
+        // e.g. a crash before the first commit or checkpoint completed.
+        masterInit(db)
+        return nil
     }
 
-    db.tree.Root = root
-    db.page.flushed = used
+    // use the newest valid slot
+    npages := uint64(db.mmap.file / b_tree.BTREE_PAGE_SIZE)
+    var slot masterSlot
+    var err error
+    found := false
+    for i := 0; i < 2; i++ {
+        offset := i * MASTER_SLOT_DISTANCE
+        cur, curErr := masterDecode(data[offset:offset + MASTER_SLOT_SIZE], npages)
+        if curErr != nil {
+            err = fmt.Errorf("master slot %d: %w", i, curErr)
+            continue
+        }
+        if !found || cur.seq > slot.seq {
+            slot, found = cur, true
+        }
+    }
+    if !found {
+        return err
+    }
+
+    db.masterSeq = slot.seq
+    db.durableSeq = slot.tailSeq
+    db.checksums = slot.checksums
+    db.tree.Counted = slot.counted
+    db.catalog.Root = slot.catalog
+    db.tree.Root = slot.root
+    db.page.flushed = slot.used
+    db.free.headPage = slot.headPage
+    db.free.headSeq = slot.headSeq
+    db.free.tailPage = slot.tailPage
+    db.free.tailSeq = slot.tailSeq
     return nil
 }
 
+// a new database
+func masterInit(db *KV) {
+    db.page.flushed = 1 // reserved for the master page
+    db.checksums = true
+    db.tree.Counted = !db.NoCounts
+}
+
+func isZero(data []byte) bool {
+    for _, b := range data {
+        if b != 0 {
+            return false
+        }
+    }
+    return true
+}
+
 // update the master page. it must be atomic
 func masterStore(db *KV) error {
-    var data [32]byte
-    copy(data[:16], []byte(DB_SIG))
-    binary.LittleEndian.PutUint64(data[16:], db.tree.Root)
-    binary.LittleEndian.PutUint64(data[24:], db.page.flushed)
+    slot := masterSlot{
+        seq: db.masterSeq + 1,
+        root: db.tree.Root,
+        used: db.page.flushed,
+        headPage: db.free.headPage,
+        headSeq: db.free.headSeq,
+        tailPage: db.free.tailPage,
+        tailSeq: db.free.tailSeq,
+        version: MASTER_VERSION_LATEST,
+        checksums: db.checksums,
+        counted: db.tree.Counted,
+        catalog: db.catalog.Root,
+    }
+    data := masterEncode(slot)
 
     // NOTE: Updating the page via mmap is not atomic.
-    // Use the `pwrite()` syscall instead
-    _, err := db.fp.WriteAt(data[:], 0)
+    // Use the `pwrite()` syscall instead, and overwrite the older slot only.
+    offset := int64(slot.seq % 2) * MASTER_SLOT_DISTANCE
+    _, err := db.fp.WriteAt(data, offset)
     if err != nil {
         return fmt.Errorf("write master page: %w", err)
     }
+    db.masterSeq = slot.seq
+    db.durableSeq = slot.tailSeq
     return nil
 }
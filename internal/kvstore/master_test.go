@@ -0,0 +1,168 @@
+package kvstore
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"testing"
+
+	"github.com/connnorchen/MyDb/internal/b_tree"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMasterEncodeDecode(t *testing.T) {
+    slot := masterSlot{
+        seq: 7, root: 3, used: 10,
+        headPage: 4, headSeq: 1, tailPage: 5, tailSeq: 9,
+        version: MASTER_VERSION_LATEST, checksums: true, counted: true, catalog: 6,
+    }
+    data := masterEncode(slot)
+    decoded, err := masterDecode(data, 10)
+    assert.Nil(t, err)
+    assert.Equal(t, decoded, slot)
+
+    // out of the file
+    _, err = masterDecode(data, 9)
+    assert.NotNil(t, err)
+
+    // bit flip
+    data[30] ^= 1
+    _, err = masterDecode(data, 10)
+    assert.NotNil(t, err)
+}
+
+func TestMasterDecodeOld(t *testing.T) {
+    // a version 3 slot has no flags or catalog
+    data := masterEncode(masterSlot{seq: 7, root: 3, used: 10, catalog: 6})
+    binary.LittleEndian.PutUint32(data[72:], MASTER_VERSION_COUNTED)
+    binary.LittleEndian.PutUint32(data[76:], crc32.ChecksumIEEE(data[:76]))
+    decoded, err := masterDecode(data, 10)
+    assert.Nil(t, err)
+    assert.Equal(t, decoded, masterSlot{
+        seq: 7, root: 3, used: 10, version: MASTER_VERSION_COUNTED,
+        checksums: true, counted: true,
+    })
+
+    // version 1 has neither
+    binary.LittleEndian.PutUint32(data[72:], MASTER_VERSION_PLAIN)
+    binary.LittleEndian.PutUint32(data[76:], crc32.ChecksumIEEE(data[:76]))
+    decoded, err = masterDecode(data, 10)
+    assert.Nil(t, err)
+    assert.False(t, decoded.checksums)
+    assert.False(t, decoded.counted)
+
+    // unknown flags
+    data = masterEncode(masterSlot{seq: 7, root: 3, used: 10})
+    binary.LittleEndian.PutUint32(data[76:], 1 << 5)
+    binary.LittleEndian.PutUint32(data[88:], crc32.ChecksumIEEE(data[:88]))
+    _, err = masterDecode(data, 10)
+    assert.ErrorContains(t, err, "unsupported format flags")
+}
+
+// overwrite part of the file behind the KV
+func damage(t *testing.T, path string, offset int64, data []byte) {
+    fp, err := os.OpenFile(path, os.O_RDWR, 0644)
+    assert.Nil(t, err)
+    _, err = fp.WriteAt(data, offset)
+    assert.Nil(t, err)
+    assert.Nil(t, fp.Close())
+}
+
+func TestMasterRecovery(t *testing.T) {
+    db := newTestKV(t)
+    assert.Nil(t, db.Set([]byte("k"), []byte("v1")))
+    assert.Nil(t, db.Set([]byte("k"), []byte("v2")))
+    newest := int64(db.masterSeq % 2) * MASTER_SLOT_DISTANCE
+    path := db.Path
+    db.Close()
+
+    // a corrupted newest slot falls back to the previous commit
+    damage(t, path, newest + 30, []byte{0xff})
+    db = reopen(t, db)
+    val, ok, err := db.Get([]byte("k"))
+    assert.Nil(t, err)
+    assert.True(t, ok)
+    assert.Equal(t, val, []byte("v1"))
+
+    // the next commit overwrites the damaged slot
+    assert.Nil(t, db.Set([]byte("k"), []byte("v3")))
+    assert.Equal(t, int64(db.masterSeq % 2) * MASTER_SLOT_DISTANCE, newest)
+    db = reopen(t, db)
+    val, ok, err = db.Get([]byte("k"))
+    assert.Nil(t, err)
+    assert.True(t, ok)
+    assert.Equal(t, val, []byte("v3"))
+
+    // a torn write of the newest slot
+    db.Close()
+    damage(t, path, newest + MASTER_SLOT_SIZE / 2, make([]byte, MASTER_SLOT_SIZE / 2))
+    db = reopen(t, db)
+    val, ok, err = db.Get([]byte("k"))
+    assert.Nil(t, err)
+    assert.True(t, ok)
+    assert.Equal(t, val, []byte("v1"))
+}
+
+func TestMasterBothSlotsBad(t *testing.T) {
+    db := newTestKV(t)
+    assert.Nil(t, db.Set([]byte("k"), []byte("v1")))
+    assert.Nil(t, db.Set([]byte("k"), []byte("v2")))
+    path := db.Path
+    db.Close()
+
+    damage(t, path, 30, []byte{0xff})
+    damage(t, path, MASTER_SLOT_DISTANCE + 30, []byte{0xff})
+    db = &KV{Path: path}
+    assert.NotNil(t, db.Open())
+}
+
+// a file from before the slots, the master page is only at offset 0
+func TestMasterOpenLegacy(t *testing.T) {
+    db := newTestKV(t)
+    tx := db.Begin()
+    for i := 0; i < 100; i++ {
+        assert.Nil(t, tx.Set([]byte(fmt.Sprintf("key%03d", i)), []byte(fmt.Sprintf("val%03d", i))))
+    }
+    assert.Nil(t, tx.Commit())
+    root, used := db.tree.Root, db.page.flushed
+    path := db.Path
+    db.Close()
+
+    page := make([]byte, b_tree.BTREE_PAGE_SIZE)
+    copy(page, []byte(DB_SIG_LEGACY))
+    binary.LittleEndian.PutUint64(page[16:], root)
+    binary.LittleEndian.PutUint64(page[24:], used)
+    damage(t, path, 0, page)
+    // the pages have no checksums either
+    damage(t, path, int64(root + 1) * b_tree.BTREE_PAGE_SIZE - 4, []byte{1, 2, 3, 4})
+
+    db = reopen(t, db)
+    assert.Equal(t, db.masterSeq, uint64(0))
+    assert.False(t, db.checksums)
+    assert.Equal(t, db.free.Total(), 0)
+    for i := 0; i < 100; i++ {
+        val, ok, err := db.Get([]byte(fmt.Sprintf("key%03d", i)))
+        assert.Nil(t, err)
+        assert.True(t, ok)
+        assert.Equal(t, val, []byte(fmt.Sprintf("val%03d", i)))
+    }
+
+    // the first commit writes slot 1, the old page is replaced by the second
+    assert.Nil(t, db.Set([]byte("key100"), []byte("val100")))
+    db = reopen(t, db)
+    assert.Equal(t, db.masterSeq, uint64(1))
+    assert.Nil(t, db.Set([]byte("key101"), []byte("val101")))
+    db = reopen(t, db)
+    assert.Equal(t, db.masterSeq, uint64(2))
+    assert.Equal(t, db.mmap.chunks[0][:16], []byte(DB_SIG))
+    // the file keeps its features
+    assert.False(t, db.checksums)
+    assert.False(t, db.tree.Counted)
+    for i := 0; i < 102; i++ {
+        val, ok, err := db.Get([]byte(fmt.Sprintf("key%03d", i)))
+        assert.Nil(t, err)
+        assert.True(t, ok)
+        assert.Equal(t, val, []byte(fmt.Sprintf("val%03d", i)))
+    }
+}
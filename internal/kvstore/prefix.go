@@ -0,0 +1,38 @@
+package kvstore
+
+// the smallest key greater than all keys with the prefix, nil if there is
+// none (the prefix is empty or all 0xff)
+func prefixEnd(prefix []byte) []byte {
+    end := append([]byte(nil), prefix...)
+    for len(end) > 0 && end[len(end) - 1] == 0xff {
+        end = end[:len(end) - 1]
+    }
+    if len(end) == 0 {
+        return nil
+    }
+    end[len(end) - 1]++
+    return end
+}
+
+// scan the keys starting with `prefix` of the last commit, see Scan
+func (db *KV) ScanPrefix(prefix []byte) *Scanner {
+    return db.Scan(prefix, prefixEnd(prefix))
+}
+
+func (reader *KVReader) ScanPrefix(prefix []byte) *Scanner {
+    return reader.Scan(prefix, prefixEnd(prefix))
+}
+
+func (tx *KVTX) ScanPrefix(prefix []byte) *Scanner {
+    return tx.Scan(prefix, prefixEnd(prefix))
+}
+
+// delete every key starting with `prefix` in a single commit,
+// returns the number of deleted keys
+func (db *KV) DeletePrefix(prefix []byte) (int, error) {
+    return db.DeleteRange(prefix, prefixEnd(prefix))
+}
+
+func (tx *KVTX) DeletePrefix(prefix []byte) (int, error) {
+    return tx.DeleteRange(prefix, prefixEnd(prefix))
+}
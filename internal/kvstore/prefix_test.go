@@ -0,0 +1,61 @@
+package kvstore
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPrefixEnd(t *testing.T) {
+    assert.Equal(t, prefixEnd([]byte("ab")), []byte("ac"))
+    assert.Equal(t, prefixEnd([]byte{'a', 0xff, 0xff}), []byte("b"))
+    assert.Nil(t, prefixEnd([]byte{0xff}))
+    assert.Nil(t, prefixEnd([]byte{}))
+}
+
+func TestKVPrefix(t *testing.T) {
+    db := newTestKV(t)
+    for _, tenant := range []string{"a", "b", "b0", "c"} {
+        for i := 0; i < 300; i++ {
+            key := []byte(fmt.Sprintf("%s/%03d", tenant, i))
+            assert.Nil(t, db.Set(key, make([]byte, 50)))
+        }
+    }
+    assert.Nil(t, db.Set([]byte("b"), []byte("not in b/")))
+
+    count := 0
+    sc := db.ScanPrefix([]byte("b/"))
+    for ; sc.Valid(); sc.Next() {
+        assert.Equal(t, sc.Key(), []byte(fmt.Sprintf("b/%03d", count)))
+        count++
+    }
+    sc.Close()
+    assert.Equal(t, count, 300)
+
+    // a single commit
+    masterSeq := db.masterSeq
+    deleted, err := db.DeletePrefix([]byte("b/"))
+    assert.Nil(t, err)
+    assert.Equal(t, deleted, 300)
+    assert.Equal(t, db.masterSeq, masterSeq + 1)
+    assertEmpty(t, db.ScanPrefix([]byte("b/")))
+
+    deleted, err = db.DeletePrefix([]byte("b/"))
+    assert.Nil(t, err)
+    assert.Equal(t, deleted, 0)
+
+    // other keys are left
+    db = reopen(t, db)
+    count = 0
+    sc = db.ScanPrefix([]byte{})
+    for ; sc.Valid(); sc.Next() {
+        count++
+    }
+    sc.Close()
+    assert.Equal(t, count, 901)
+    _, ok, err := db.Get([]byte("b0/299"))
+    assert.Nil(t, err)
+    assert.True(t, ok)
+    verifyOK(t, db.Path)
+}
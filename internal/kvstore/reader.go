@@ -0,0 +1,88 @@
+package kvstore
+
+import (
+	"github.com/connnorchen/MyDb/internal/b_tree"
+)
+
+// read-only snapshot of the last commit. readers don't block each other nor
+// the writer, pages reachable from the snapshot are not reused until Close.
+type KVReader struct {
+    db     *KV
+    tree   b_tree.BTree
+    catalog b_tree.BTree // see bucket.go
+    mmap   [][]byte // the mmap chunks at the time of the snapshot
+    npages uint64   // database size of the snapshot
+    seq    uint64   // free list tail of the snapshot
+    ttl    *Bucket  // the TTL bucket, see ttlBucket
+    ttlLoaded bool
+}
+
+// take a snapshot of the last commit, the caller holds `mu`
+func snapshot(db *KV) *KVReader {
+    reader := &KVReader{
+        db: db, mmap: db.mmap.chunks, npages: db.npages, seq: db.tailSeq,
+    }
+    reader.tree.Root = db.root
+    reader.tree.Get = reader.pageGet
+    reader.tree.Counted = db.tree.Counted
+    reader.catalog = reader.tree
+    reader.catalog.Root = db.catalogRoot
+    return reader
+}
+
+// begin a read-only snapshot, must be ended with Close
+func (db *KV) BeginRead() *KVReader {
+    db.mu.Lock()
+    defer db.mu.Unlock()
+    reader := snapshot(db)
+    db.readers[reader.seq]++
+    return reader
+}
+
+// end the snapshot, its pages can be reused by later transactions
+func (reader *KVReader) Close() {
+    db := reader.db
+    db.mu.Lock()
+    defer db.mu.Unlock()
+    db.readers[reader.seq]--
+    if db.readers[reader.seq] == 0 {
+        delete(db.readers, reader.seq)
+    }
+}
+
+// callback for BTree, only committed pages are visible to readers
+func (reader *KVReader) pageGet(ptr uint64) b_tree.BNode {
+    if ptr >= reader.npages {
+        return b_tree.BNode{} // reported as corrupt by the BTree
+    }
+    return pageVerified(reader.mmap, ptr, reader.db.checksums)
+}
+
+// the value is valid until Close. an expired key is absent, see ttl.go
+func (reader *KVReader) Get(key []byte) ([]byte, bool, error) {
+    val, found, err := reader.tree.GetKey(key)
+    if err != nil || !found {
+        return nil, false, err
+    }
+    b, err := reader.ttlBucket()
+    if err != nil {
+        return nil, false, err
+    }
+    expired, err := keyExpired(b, key, reader.db.now())
+    if err != nil || expired {
+        return nil, false, err
+    }
+    return val, true, nil
+}
+
+// scan keys in [start, end) of the snapshot
+func (reader *KVReader) Scan(start []byte, end []byte) *Scanner {
+    b, err := reader.ttlBucket()
+    return newScanner(&reader.tree, start, end).skipExpired(b, reader.db.now(), err)
+}
+
+// scan keys in [start, end) of the snapshot in descending order
+func (reader *KVReader) ScanDesc(start []byte, end []byte) *Scanner {
+    b, err := reader.ttlBucket()
+    return newScannerDesc(&reader.tree, start, end).skipExpired(b, reader.db.now(), err)
+}
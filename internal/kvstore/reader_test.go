@@ -0,0 +1,145 @@
+package kvstore
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReaderSnapshot(t *testing.T) {
+    db := newTestKV(t)
+    assert.Nil(t, db.Set([]byte("k"), []byte("v0")))
+
+    reader := db.BeginRead()
+    // later commits are invisible to the reader, and the pages it reads
+    // are not reused while it's active.
+    for i := 1; i < 50; i++ {
+        assert.Nil(t, db.Set([]byte("k"), []byte(fmt.Sprintf("v%d", i))))
+        assert.Nil(t, db.Set([]byte(fmt.Sprintf("x%d", i)), make([]byte, 1000)))
+    }
+    val, ok, err := reader.Get([]byte("k"))
+    assert.Nil(t, err)
+    assert.True(t, ok)
+    assert.Equal(t, val, []byte("v0"))
+    sc := reader.Scan([]byte("x"), nil)
+    assert.False(t, sc.Valid())
+    reader.Close()
+    assert.Empty(t, db.readers)
+
+    val, ok, err = db.Get([]byte("k"))
+    assert.Nil(t, err)
+    assert.True(t, ok)
+    assert.Equal(t, val, []byte("v49"))
+}
+
+func TestReaderPinsFreePages(t *testing.T) {
+    db := newTestKV(t)
+    assert.Nil(t, db.Set([]byte("k"), []byte("v")))
+    reader := db.BeginRead()
+    seq := reader.seq
+
+    assert.Nil(t, db.Set([]byte("k"), []byte("v1")))
+    tx := db.Begin()
+    assert.Equal(t, db.free.maxSeq, seq)
+    tx.Abort()
+
+    reader.Close()
+    tx = db.Begin()
+    assert.Equal(t, db.free.maxSeq, db.free.tailSeq)
+    tx.Abort()
+}
+
+func TestScannerPinsFreePages(t *testing.T) {
+    db := newTestKV(t)
+    for i := 0; i < 100; i++ {
+        assert.Nil(t, db.Set([]byte(fmt.Sprintf("key%03d", i)), []byte(fmt.Sprintf("v0-%03d", i))))
+    }
+
+    // the pages walked by an open scanner are not reused by the writer
+    sc := db.Scan([]byte("key"), nil)
+    assert.Len(t, db.readers, 1)
+    count := 0
+    for ; sc.Valid(); sc.Next() {
+        for round := 1; round < 5; round++ {
+            tx := db.Begin()
+            for i := 0; i < 100; i++ {
+                key := []byte(fmt.Sprintf("key%03d", i))
+                if round % 2 == 0 {
+                    _, err := tx.Del(key)
+                    assert.Nil(t, err)
+                } else {
+                    assert.Nil(t, tx.Set(key, []byte(fmt.Sprintf("v%d-%03d", round, i))))
+                }
+            }
+            assert.Nil(t, tx.Commit())
+        }
+        assert.Equal(t, sc.Val(), []byte(fmt.Sprintf("v0-%03d", count)))
+        count++
+    }
+    assert.Nil(t, sc.Err())
+    assert.Equal(t, count, 100)
+    sc.Close()
+    sc.Close()
+    assert.Empty(t, db.readers)
+}
+
+// run with -race
+func TestConcurrentReadersAndWriter(t *testing.T) {
+    db := newTestKV(t)
+    nkeys := 50
+    // all keys carry the same round number in each commit
+    write := func(round int) {
+        tx := db.Begin()
+        for i := 0; i < nkeys; i++ {
+            val := []byte(fmt.Sprintf("%08d", round))
+            assert.Nil(t, tx.Set([]byte(fmt.Sprintf("key%03d", i)), append(val, make([]byte, 300)...)))
+        }
+        assert.Nil(t, tx.Commit())
+    }
+    write(0)
+
+    var wg sync.WaitGroup
+    stop := make(chan struct{})
+    for r := 0; r < 4; r++ {
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            for {
+                select {
+                case <-stop:
+                    return
+                default:
+                }
+                reader := db.BeginRead()
+                count := 0
+                var round []byte
+                for sc := reader.Scan([]byte("key"), nil); sc.Valid(); sc.Next() {
+                    if round == nil {
+                        round = append(round, sc.Val()[:8]...)
+                    }
+                    assert.Equal(t, sc.Val()[:8], round)
+                    count++
+                }
+                assert.Equal(t, count, nkeys)
+                reader.Close()
+
+                _, ok, err := db.Get([]byte("key000"))
+                assert.Nil(t, err)
+                assert.True(t, ok)
+            }
+        }()
+    }
+
+    for round := 1; round < 100; round++ {
+        write(round)
+    }
+    close(stop)
+    wg.Wait()
+
+    val, ok, err := db.Get([]byte("key000"))
+    assert.Nil(t, err)
+    assert.True(t, ok)
+    assert.Equal(t, val[:8], []byte("00000099"))
+}
@@ -0,0 +1,116 @@
+package kvstore
+
+import (
+	"bytes"
+
+	"github.com/connnorchen/MyDb/internal/b_tree"
+)
+
+// range scan over keys in [start, end) in ascending or descending order.
+// a scanner of a transaction is invalidated by its updates, a scanner of a
+// snapshot is not. the scanners returned by KV hold their own snapshot and
+// must be closed.
+// the scanners of the default keyspace skip the expired keys, see ttl.go
+type Scanner struct {
+    iter  *b_tree.BIter
+    start []byte
+    end   []byte // nil for no upper bound
+    desc  bool
+    reader *KVReader // the snapshot owned by the scanner, nil if none
+    ttl   *Bucket // the TTL bucket, nil if none or for buckets
+    now   int64
+    err   error // of the TTL lookups
+}
+
+func newScanner(tree *b_tree.BTree, start []byte, end []byte) *Scanner {
+    return &Scanner{iter: tree.SeekGE(start), start: start, end: end}
+}
+
+// from the last key in the range to the first
+func newScannerDesc(tree *b_tree.BTree, start []byte, end []byte) *Scanner {
+    var iter *b_tree.BIter
+    if end == nil {
+        iter = tree.Last()
+    } else {
+        iter = tree.SeekLE(end)
+        if iter.Valid() && bytes.Equal(iter.Key(), end) {
+            iter.Prev() // the last key < end
+        }
+    }
+    return &Scanner{iter: iter, start: start, end: end, desc: true}
+}
+
+// skip the keys expired at `now` according to the TTL bucket `b`.
+// `err` is the error of getting the bucket, it's reported by Err.
+func (sc *Scanner) skipExpired(b *Bucket, now int64, err error) *Scanner {
+    sc.ttl, sc.now, sc.err = b, now, err
+    sc.skip()
+    return sc
+}
+
+// move past the expired keys
+func (sc *Scanner) skip() {
+    for sc.ttl != nil && sc.Valid() {
+        expired, err := keyExpired(sc.ttl, sc.iter.Key(), sc.now)
+        if err != nil {
+            sc.err = err
+            return
+        }
+        if !expired {
+            return
+        }
+        sc.move()
+    }
+}
+
+// within the range or not
+func (sc *Scanner) Valid() bool {
+    if sc.err != nil || !sc.iter.Valid() {
+        return false
+    }
+    if sc.desc {
+        return bytes.Compare(sc.iter.Key(), sc.start) >= 0
+    }
+    return sc.end == nil || bytes.Compare(sc.iter.Key(), sc.end) < 0
+}
+
+// move to the next key in the scan order
+func (sc *Scanner) Next() {
+    sc.move()
+    sc.skip()
+}
+
+// move the underlying B-tree iterator in the scan order
+func (sc *Scanner) move() {
+    if sc.desc {
+        sc.iter.Prev()
+    } else {
+        sc.iter.Next()
+    }
+}
+
+// the error that stopped the scan, e.g. a corrupt page
+func (sc *Scanner) Err() error {
+    if sc.err != nil {
+        return sc.err
+    }
+    return sc.iter.Err()
+}
+
+// fetch the current KV pair
+func (sc *Scanner) Key() []byte {
+    return sc.iter.Key()
+}
+
+func (sc *Scanner) Val() []byte {
+    return sc.iter.Val()
+}
+
+// release the snapshot owned by the scanner, a no-op for the scanners of a
+// transaction or a reader
+func (sc *Scanner) Close() {
+    if sc.reader != nil {
+        sc.reader.Close()
+        sc.reader = nil
+    }
+}
@@ -0,0 +1,376 @@
+package kvstore
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/connnorchen/MyDb/internal/b_tree"
+)
+
+// keys of the default keyspace can expire. the value stays in the main
+// tree, the expiry time is kept next to it in the reserved bucket
+// TTL_BUCKET, which holds 2 kinds of keys:
+// | 0x00 | key |            -> | expiry 8B |  the expiry of a key
+// | 0x01 | expiry 8B | key | -> empty        the keys ordered by expiry
+// the expiry is in unix nanoseconds, big-endian so that the second kind is
+// ordered by time. both are updated with the key in the same transaction,
+// any Set or Del of the key removes its expiry.
+//
+// an expired key is absent for Get, scans and counts. it's deleted later by
+// the sweeper, which reads the keys ordered by expiry instead of scanning
+// the tree.
+
+const TTL_BUCKET = "\x00ttl"
+
+const (
+    TTL_PREFIX_KEY = 0
+    TTL_PREFIX_EXPIRY = 1
+)
+
+const (
+    TTL_SWEEP_INTERVAL = time.Second
+    TTL_SWEEP_BATCH = 1000 // expiries removed per commit
+)
+
+var ErrInvalidTTL = errors.New("ttl must be positive")
+
+func ttlKey(key []byte) []byte {
+    return append([]byte{TTL_PREFIX_KEY}, key...)
+}
+
+func ttlExpiryKey(expiry int64, key []byte) []byte {
+    out := make([]byte, 9, 9 + len(key))
+    out[0] = TTL_PREFIX_EXPIRY
+    binary.BigEndian.PutUint64(out[1:], uint64(expiry))
+    return append(out, key...)
+}
+
+func encodeExpiry(expiry int64) []byte {
+    val := make([]byte, 8)
+    binary.BigEndian.PutUint64(val, uint64(expiry))
+    return val
+}
+
+func decodeExpiry(val []byte) (int64, error) {
+    if len(val) != 8 {
+        return 0, fmt.Errorf("%w: bad expiry", b_tree.ErrCorruptPage)
+    }
+    return int64(binary.BigEndian.Uint64(val)), nil
+}
+
+// the current time in unix nanoseconds
+func (db *KV) now() int64 {
+    if db.clock != nil {
+        return db.clock().UnixNano()
+    }
+    return time.Now().UnixNano()
+}
+
+// whether the key has expired, `b` is the TTL bucket or nil
+func keyExpired(b *Bucket, key []byte, now int64) (bool, error) {
+    if b == nil {
+        return false, nil
+    }
+    val, found, err := b.Get(ttlKey(key))
+    if err != nil || !found {
+        return false, err
+    }
+    expiry, err := decodeExpiry(val)
+    return expiry <= now, err
+}
+
+// the expired keys in [start, end) of the tree in ascending order, `b` is
+// the TTL bucket. they are found by expiry, so this only reads the ones the
+// sweeper hasn't deleted yet.
+func expiredKeys(
+    b *Bucket, tree *b_tree.BTree, start []byte, end []byte, now int64,
+) ([][]byte, error) {
+    keys := [][]byte{}
+    sc := b.Scan([]byte{TTL_PREFIX_EXPIRY}, ttlExpiryKey(now + 1, nil))
+    for ; sc.Valid(); sc.Next() {
+        key := sc.Key()[9:]
+        if bytes.Compare(key, start) < 0 || (end != nil && bytes.Compare(key, end) >= 0) {
+            continue
+        }
+        // a stale entry, see sweepExpired
+        val, found, err := b.Get(ttlKey(key))
+        if err != nil {
+            return nil, err
+        }
+        if !found || string(val) != string(sc.Key()[1:9]) {
+            continue
+        }
+        _, found, err = tree.GetKey(key)
+        if err != nil {
+            return nil, err
+        }
+        if !found {
+            continue
+        }
+        keys = append(keys, append([]byte(nil), key...))
+    }
+    if err := sc.Err(); err != nil {
+        return nil, err
+    }
+    sort.Slice(keys, func(i, j int) bool {
+        return bytes.Compare(keys[i], keys[j]) < 0
+    })
+    return keys, nil
+}
+
+// the TTL bucket of the transaction, nil if it doesn't exist and `create`
+// is false. an open bucket is kept in `tx.buckets`, a missing one is
+// remembered until a bucket is created, so most Gets don't touch the catalog.
+func (tx *KVTX) ttlBucket(create bool) (*Bucket, error) {
+    if tx.noTTL && !create {
+        return nil, nil
+    }
+    b, err := tx.Bucket([]byte(TTL_BUCKET))
+    if errors.Is(err, ErrBucketNotFound) && create {
+        if err = tx.createBucket([]byte(TTL_BUCKET)); err == nil {
+            b, err = tx.Bucket([]byte(TTL_BUCKET))
+        }
+    }
+    if errors.Is(err, ErrBucketNotFound) {
+        tx.noTTL = true
+        return nil, nil
+    }
+    return b, err
+}
+
+// the TTL bucket of the snapshot, nil if it doesn't exist.
+// the result is kept for the life of the reader.
+func (reader *KVReader) ttlBucket() (*Bucket, error) {
+    if reader.ttlLoaded {
+        return reader.ttl, nil
+    }
+    b, err := reader.Bucket([]byte(TTL_BUCKET))
+    if errors.Is(err, ErrBucketNotFound) {
+        b, err = nil, nil
+    }
+    if err != nil {
+        return nil, err
+    }
+    reader.ttl, reader.ttlLoaded = b, true
+    return b, nil
+}
+
+// remove the expiry of a key, if any
+func (tx *KVTX) clearTTL(key []byte) error {
+    b, err := tx.ttlBucket(false)
+    if b == nil {
+        return err
+    }
+    val, found, err := b.Get(ttlKey(key))
+    if err != nil || !found {
+        return err
+    }
+    expiry, err := decodeExpiry(val)
+    if err != nil {
+        return tx.check(err)
+    }
+    if _, err := b.Del(ttlKey(key)); err != nil {
+        return err
+    }
+    _, err = b.Del(ttlExpiryKey(expiry, key))
+    return err
+}
+
+// remove the expiries of the keys in [start, end)
+func (tx *KVTX) clearTTLRange(start []byte, end []byte) error {
+    b, err := tx.ttlBucket(false)
+    if b == nil {
+        return err
+    }
+    hi := []byte{TTL_PREFIX_KEY + 1}
+    if end != nil {
+        hi = ttlKey(end)
+    }
+    // collected first, the scanner is invalidated by the updates
+    keys := [][]byte{}
+    sc := b.Scan(ttlKey(start), hi)
+    for ; sc.Valid(); sc.Next() {
+        keys = append(keys, append([]byte(nil), sc.Key()[1:]...))
+    }
+    if err := tx.check(sc.Err()); err != nil {
+        return err
+    }
+    for _, key := range keys {
+        if err := tx.clearTTL(key); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// delete the key if it has expired, so that it's absent for the update
+func (tx *KVTX) dropExpired(key []byte) error {
+    b, err := tx.ttlBucket(false)
+    if err != nil {
+        return err
+    }
+    expired, err := keyExpired(b, key, tx.db.now())
+    if err != nil || !expired {
+        return err
+    }
+    _, err = tx.Del(key)
+    return err
+}
+
+// set a key that expires after `ttl`, see KVTX.SetWithTTL
+func (db *KV) SetWithTTL(key []byte, val []byte, ttl time.Duration) error {
+    tx := db.Begin()
+    if err := tx.SetWithTTL(key, val, ttl); err != nil {
+        tx.Abort()
+        return err
+    }
+    return tx.Commit()
+}
+
+// set a key that is absent for Get after `ttl`, and then deleted by the
+// sweeper. a later Set of the key makes it permanent.
+func (tx *KVTX) SetWithTTL(key []byte, val []byte, ttl time.Duration) error {
+    if ttl <= 0 {
+        return ErrInvalidTTL
+    }
+    if err := tx.Set(key, val); err != nil {
+        return err
+    }
+    b, err := tx.ttlBucket(true)
+    if err != nil {
+        return err
+    }
+    expiry := tx.db.now() + int64(ttl)
+    if err := b.Set(ttlKey(key), encodeExpiry(expiry)); err != nil {
+        return err
+    }
+    return b.Set(ttlExpiryKey(expiry, key), nil)
+}
+
+// remove up to `limit` past expiries and delete their keys. returns the
+// number of removed expiries and of deleted keys.
+// the TTL entries are removed here rather than by Del, so that an entry
+// left without its key doesn't stay forever. a key is only deleted if its
+// own expiry matches the entry.
+func (tx *KVTX) sweepExpired(limit int) (int, int, error) {
+    b, err := tx.ttlBucket(false)
+    if b == nil {
+        return 0, 0, err
+    }
+    // collected first, the scanner is invalidated by the updates
+    entries := [][]byte{}
+    sc := b.Scan([]byte{TTL_PREFIX_EXPIRY}, ttlExpiryKey(tx.db.now() + 1, nil))
+    for ; sc.Valid() && len(entries) < limit; sc.Next() {
+        entries = append(entries, append([]byte(nil), sc.Key()...))
+    }
+    if err := tx.check(sc.Err()); err != nil {
+        return 0, 0, err
+    }
+    deleted := 0
+    for _, entry := range entries {
+        key := entry[9:]
+        if _, err := b.Del(entry); err != nil {
+            return 0, 0, err
+        }
+        val, found, err := b.Get(ttlKey(key))
+        if err != nil {
+            return 0, 0, err
+        }
+        if !found || string(val) != string(entry[1:9]) {
+            continue // a stale entry
+        }
+        if _, err := b.Del(ttlKey(key)); err != nil {
+            return 0, 0, err
+        }
+        ok, err := tx.tree.DeleteKey(key)
+        if err != nil {
+            return 0, 0, tx.check(err)
+        }
+        if ok {
+            tx.logOp(WAL_OP_DEL, key, nil)
+            deleted++
+        }
+    }
+    return len(entries), deleted, nil
+}
+
+// whether the last commit has an expired key
+func (db *KV) hasExpired() (bool, error) {
+    reader := db.BeginRead()
+    defer reader.Close()
+    b, err := reader.ttlBucket()
+    if b == nil {
+        return false, err
+    }
+    sc := b.Scan([]byte{TTL_PREFIX_EXPIRY}, ttlExpiryKey(db.now() + 1, nil))
+    return sc.Valid(), sc.Err()
+}
+
+// delete the expired keys in commits of TTLSweepBatch keys, returns the
+// number of deleted keys. the writer lock is released between the commits.
+func (db *KV) SweepExpired() (int, error) {
+    batch := db.TTLSweepBatch
+    if batch <= 0 {
+        batch = TTL_SWEEP_BATCH
+    }
+    total := 0
+    for {
+        // don't take the writer lock for nothing
+        if found, err := db.hasExpired(); err != nil || !found {
+            return total, err
+        }
+        tx := db.Begin()
+        count, deleted, err := tx.sweepExpired(batch)
+        if err != nil {
+            tx.Abort()
+            return total, err
+        }
+        if err := tx.Commit(); err != nil {
+            return total, err
+        }
+        total += deleted
+        if count < batch {
+            return total, nil
+        }
+    }
+}
+
+// run SweepExpired periodically until Close
+func (db *KV) startSweeper() {
+    interval := db.TTLSweepInterval
+    if interval < 0 {
+        return
+    }
+    if interval == 0 {
+        interval = TTL_SWEEP_INTERVAL
+    }
+    db.sweeper.stop = make(chan struct{})
+    db.sweeper.done = make(chan struct{})
+    go func() {
+        defer close(db.sweeper.done)
+        ticker := time.NewTicker(interval)
+        defer ticker.Stop()
+        for {
+            select {
+            case <-db.sweeper.stop:
+                return
+            case <-ticker.C:
+                // a failure is retried on the next tick
+                _, _ = db.SweepExpired()
+            }
+        }
+    }()
+}
+
+func (db *KV) stopSweeper() {
+    if db.sweeper.stop == nil {
+        return
+    }
+    close(db.sweeper.stop)
+    <-db.sweeper.done
+    db.sweeper.stop = nil
+}
@@ -0,0 +1,324 @@
+package kvstore
+
+import (
+	"encoding/binary"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/connnorchen/MyDb/internal/b_tree"
+	"github.com/stretchr/testify/assert"
+)
+
+// a KV on a fake clock without the background sweeper
+func newTTLKV(t *testing.T, path string, now *time.Time) *KV {
+    db := &KV{Path: path, TTLSweepInterval: -1, TTLSweepBatch: 100}
+    db.clock = func() time.Time { return *now }
+    assert.Nil(t, db.Open())
+    t.Cleanup(db.Close)
+    return db
+}
+
+// the number of entries of each kind in the TTL bucket
+func ttlEntries(t *testing.T, db *KV) (int, int) {
+    reader := db.BeginRead()
+    defer reader.Close()
+    b, err := reader.ttlBucket()
+    assert.Nil(t, err)
+    if b == nil {
+        return 0, 0
+    }
+    counts := [2]int{}
+    sc := b.Scan(nil, nil)
+    for ; sc.Valid(); sc.Next() {
+        counts[sc.Key()[0]]++
+    }
+    assert.Nil(t, sc.Err())
+    return counts[TTL_PREFIX_KEY], counts[TTL_PREFIX_EXPIRY]
+}
+
+func TestTTLGet(t *testing.T) {
+    now := time.Unix(1000, 0)
+    db := newTTLKV(t, filepath.Join(t.TempDir(), "db"), &now)
+    assert.Nil(t, db.SetWithTTL([]byte("a"), []byte("1"), 10 * time.Second))
+    assert.Nil(t, db.SetWithTTL([]byte("b"), []byte("2"), 20 * time.Second))
+    assert.Nil(t, db.SetWithTTL([]byte("c"), []byte("3"), 10 * time.Second))
+    assert.Nil(t, db.Set([]byte("c"), []byte("permanent")))
+    assert.ErrorIs(t, db.SetWithTTL([]byte("d"), nil, 0), ErrInvalidTTL)
+    keys, expiries := ttlEntries(t, db)
+    assert.Equal(t, []int{keys, expiries}, []int{2, 2})
+
+    get := func(key string) ([]byte, bool) {
+        val, ok, err := db.Get([]byte(key))
+        assert.Nil(t, err)
+        return val, ok
+    }
+    val, ok := get("a")
+    assert.True(t, ok)
+    assert.Equal(t, val, []byte("1"))
+
+    now = now.Add(10 * time.Second)
+    _, ok = get("a")
+    assert.False(t, ok)
+    _, ok = get("b")
+    assert.True(t, ok)
+    val, ok = get("c")
+    assert.True(t, ok)
+    assert.Equal(t, val, []byte("permanent"))
+    // still stored until it's swept, but not counted
+    count, err := db.CountRange(nil, nil)
+    assert.Nil(t, err)
+    assert.Equal(t, count, 2)
+
+    // an expired key is absent for the updates too
+    tx := db.Begin()
+    _, ok, err = tx.Get([]byte("a"))
+    assert.Nil(t, err)
+    assert.False(t, ok)
+    res, err := tx.SetEx([]byte("a"), []byte("new"), b_tree.MODE_INSERT_ONLY)
+    assert.Nil(t, err)
+    assert.True(t, res.Added)
+    assert.Nil(t, tx.Commit())
+    val, ok = get("a")
+    assert.True(t, ok)
+    assert.Equal(t, val, []byte("new"))
+    assert.Nil(t, db.SetWithTTL([]byte("n"), make([]byte, 8), time.Second))
+    now = now.Add(time.Second)
+    n, err := db.Increment([]byte("n"), 5)
+    assert.Nil(t, err)
+    assert.Equal(t, n, int64(5))
+
+    // deleting the keys removes their expiries
+    _, err = db.Del([]byte("b"))
+    assert.Nil(t, err)
+    keys, expiries = ttlEntries(t, db)
+    assert.Equal(t, []int{keys, expiries}, []int{0, 0})
+    assert.Nil(t, db.SetWithTTL([]byte("x1"), nil, time.Second))
+    assert.Nil(t, db.SetWithTTL([]byte("x2"), nil, time.Second))
+    assert.Nil(t, db.SetWithTTL([]byte("y"), nil, time.Second))
+    _, err = db.DeletePrefix([]byte("x"))
+    assert.Nil(t, err)
+    keys, expiries = ttlEntries(t, db)
+    assert.Equal(t, []int{keys, expiries}, []int{1, 1})
+
+    // the TTL bucket is internal
+    names, err := db.ListBuckets()
+    assert.Nil(t, err)
+    assert.Equal(t, names, [][]byte{})
+    assert.ErrorIs(t, db.CreateBucket([]byte(TTL_BUCKET)), ErrReservedBucket)
+    assert.ErrorIs(t, db.DropBucket([]byte(TTL_BUCKET)), ErrReservedBucket)
+}
+
+func TestTTLScan(t *testing.T) {
+    now := time.Unix(1000, 0)
+    db := newTTLKV(t, filepath.Join(t.TempDir(), "db"), &now)
+    // the odd keys expire
+    for i := 0; i < 10; i++ {
+        key := []byte(fmt.Sprintf("k%d", i))
+        if i % 2 == 1 {
+            assert.Nil(t, db.SetWithTTL(key, []byte("v"), 10 * time.Second))
+        } else {
+            assert.Nil(t, db.Set(key, []byte("v")))
+        }
+    }
+    now = now.Add(10 * time.Second)
+
+    keys := func(sc *Scanner) []string {
+        defer sc.Close()
+        out := []string{}
+        for ; sc.Valid(); sc.Next() {
+            out = append(out, string(sc.Key()))
+        }
+        assert.Nil(t, sc.Err())
+        return out
+    }
+    live := []string{"k0", "k2", "k4", "k6", "k8"}
+    assert.Equal(t, keys(db.Scan(nil, nil)), live)
+    assert.Equal(t, keys(db.Scan([]byte("k1"), []byte("k5"))), []string{"k2", "k4"})
+    assert.Equal(t, keys(db.ScanDesc([]byte("k1"), []byte("k9"))), []string{"k8", "k6", "k4", "k2"})
+    assert.Equal(t, keys(db.ScanPrefix([]byte("k"))), live)
+    for k := 0; k < len(live); k++ {
+        assert.Equal(t, keys(db.ScanNth(k, nil)), live[k:])
+    }
+    assert.Equal(t, keys(db.ScanNth(len(live), nil)), []string{})
+
+    count, err := db.CountRange(nil, nil)
+    assert.Nil(t, err)
+    assert.Equal(t, count, 5)
+    count, err = db.CountRange([]byte("k1"), []byte("k5"))
+    assert.Nil(t, err)
+    assert.Equal(t, count, 2)
+
+    // the same within a transaction
+    tx := db.Begin()
+    assert.Equal(t, keys(tx.Scan(nil, nil)), live)
+    assert.Equal(t, keys(tx.ScanNth(1, nil)), live[1:])
+    count, err = tx.CountRange(nil, nil)
+    assert.Nil(t, err)
+    assert.Equal(t, count, 5)
+    tx.Abort()
+
+    // and after the sweeper
+    swept, err := db.SweepExpired()
+    assert.Nil(t, err)
+    assert.Equal(t, swept, 5)
+    assert.Equal(t, keys(db.Scan(nil, nil)), live)
+    count, err = db.CountRange(nil, nil)
+    assert.Nil(t, err)
+    assert.Equal(t, count, 5)
+}
+
+func TestTTLSweep(t *testing.T) {
+    now := time.Unix(1000, 0)
+    path := filepath.Join(t.TempDir(), "db")
+    db := newTTLKV(t, path, &now)
+    const n = 250 // more than a batch
+    for i := 0; i < n; i++ {
+        key := []byte(fmt.Sprintf("k%03d", i))
+        // the expiries are not in the key order
+        ttl := time.Duration(1 + (i * 7) % n) * time.Second
+        assert.Nil(t, db.SetWithTTL(key, []byte("v"), ttl))
+    }
+    assert.Nil(t, db.Set([]byte("forever"), []byte("v")))
+
+    swept, err := db.SweepExpired()
+    assert.Nil(t, err)
+    assert.Equal(t, swept, 0)
+
+    // the expiries persist
+    db.Close()
+    db = newTTLKV(t, path, &now)
+    now = now.Add(200 * time.Second)
+    swept, err = db.SweepExpired()
+    assert.Nil(t, err)
+    assert.Equal(t, swept, 200)
+    count, err := db.CountRange(nil, nil)
+    assert.Nil(t, err)
+    assert.Equal(t, count, n - 200 + 1)
+    keys, expiries := ttlEntries(t, db)
+    assert.Equal(t, []int{keys, expiries}, []int{n - 200, n - 200})
+
+    // the rest are the ones with a later expiry
+    reader := db.BeginRead()
+    b, err := reader.ttlBucket()
+    assert.Nil(t, err)
+    sc := b.Scan([]byte{TTL_PREFIX_EXPIRY}, nil)
+    for ; sc.Valid(); sc.Next() {
+        expiry := int64(binary.BigEndian.Uint64(sc.Key()[1:9]))
+        assert.Greater(t, expiry, now.UnixNano())
+    }
+    reader.Close()
+
+    now = now.Add(time.Hour)
+    swept, err = db.SweepExpired()
+    assert.Nil(t, err)
+    assert.Equal(t, swept, n - 200)
+    count, err = db.CountRange(nil, nil)
+    assert.Nil(t, err)
+    assert.Equal(t, count, 1)
+    keys, expiries = ttlEntries(t, db)
+    assert.Equal(t, []int{keys, expiries}, []int{0, 0})
+    errs, err := Verify(path)
+    assert.Nil(t, err)
+    assert.Empty(t, errs)
+}
+
+func TestTTLSweepStale(t *testing.T) {
+    now := time.Unix(1000, 0)
+    db := newTTLKV(t, filepath.Join(t.TempDir(), "db"), &now)
+    assert.Nil(t, db.SetWithTTL([]byte("gone"), []byte("v"), time.Second))
+    assert.Nil(t, db.SetWithTTL([]byte("later"), []byte("v"), time.Hour))
+    assert.Nil(t, db.Set([]byte("forever"), []byte("v")))
+    // entries without their key or with an older expiry than the key's
+    tx := db.Begin()
+    deleted, err := tx.tree.DeleteKey([]byte("gone"))
+    assert.Nil(t, err)
+    assert.True(t, deleted)
+    b, err := tx.ttlBucket(false)
+    assert.Nil(t, err)
+    assert.Nil(t, b.Set(ttlExpiryKey(now.UnixNano(), []byte("later")), nil))
+    assert.Nil(t, b.Set(ttlExpiryKey(now.UnixNano(), []byte("forever")), nil))
+    assert.Nil(t, tx.Commit())
+
+    now = now.Add(time.Minute)
+    swept, err := db.SweepExpired()
+    assert.Nil(t, err)
+    assert.Equal(t, swept, 0)
+    keys, expiries := ttlEntries(t, db)
+    assert.Equal(t, []int{keys, expiries}, []int{1, 1})
+    count, err := db.CountRange(nil, nil)
+    assert.Nil(t, err)
+    assert.Equal(t, count, 2)
+    _, ok, err := db.Get([]byte("later"))
+    assert.Nil(t, err)
+    assert.True(t, ok)
+}
+
+func TestTTLBucketCached(t *testing.T) {
+    now := time.Unix(1000, 0)
+    db := newTTLKV(t, filepath.Join(t.TempDir(), "db"), &now)
+    assert.Nil(t, db.Set([]byte("k"), []byte("v")))
+
+    // the missing bucket is remembered until it's created
+    tx := db.Begin()
+    b, err := tx.ttlBucket(false)
+    assert.Nil(t, err)
+    assert.Nil(t, b)
+    assert.True(t, tx.noTTL)
+    assert.Nil(t, tx.SetWithTTL([]byte("t"), []byte("v"), time.Second))
+    assert.False(t, tx.noTTL)
+    now = now.Add(time.Minute)
+    _, ok, err := tx.Get([]byte("t"))
+    assert.Nil(t, err)
+    assert.False(t, ok)
+    tx.Abort()
+
+    reader := db.BeginRead()
+    _, ok, err = reader.Get([]byte("k"))
+    assert.Nil(t, err)
+    assert.True(t, ok)
+    assert.True(t, reader.ttlLoaded)
+    assert.Nil(t, reader.ttl)
+    reader.Close()
+}
+
+func TestTTLWAL(t *testing.T) {
+    path := filepath.Join(t.TempDir(), "db")
+    db := newTestWAL(t, path, 0)
+    assert.Nil(t, db.SetWithTTL([]byte("a"), []byte("1"), time.Hour))
+    assert.Nil(t, db.SetWithTTL([]byte("b"), []byte("2"), time.Millisecond))
+    assert.Nil(t, db.SetWithTTL([]byte("c"), []byte("3"), time.Hour))
+    assert.Nil(t, db.Set([]byte("c"), []byte("3")))
+    crash(db)
+
+    // the expired key is left to the test
+    db = &KV{Path: path, WAL: true, TTLSweepInterval: -1}
+    assert.Nil(t, db.Open())
+    t.Cleanup(db.Close)
+    time.Sleep(2 * time.Millisecond)
+    _, ok, err := db.Get([]byte("b"))
+    assert.Nil(t, err)
+    assert.False(t, ok)
+    swept, err := db.SweepExpired()
+    assert.Nil(t, err)
+    assert.Equal(t, swept, 1)
+    keys, expiries := ttlEntries(t, db)
+    assert.Equal(t, []int{keys, expiries}, []int{1, 1})
+    count, err := db.CountRange(nil, nil)
+    assert.Nil(t, err)
+    assert.Equal(t, count, 2)
+}
+
+func TestTTLSweeper(t *testing.T) {
+    db := &KV{Path: filepath.Join(t.TempDir(), "db"), TTLSweepInterval: 5 * time.Millisecond}
+    assert.Nil(t, db.Open())
+    t.Cleanup(db.Close)
+    for i := 0; i < 10; i++ {
+        assert.Nil(t, db.SetWithTTL([]byte{byte(i)}, nil, time.Millisecond))
+    }
+    assert.Eventually(t, func() bool {
+        count, err := db.CountRange(nil, nil)
+        return err == nil && count == 0
+    }, 5 * time.Second, 5 * time.Millisecond)
+}
@@ -0,0 +1,230 @@
+package kvstore
+
+import (
+	"errors"
+
+	"github.com/connnorchen/MyDb/internal/b_tree"
+)
+
+// KV transaction, updates are applied to a private copy-on-write root and
+// become visible in one master page update on commit.
+// transactions hold the writer lock until they end.
+type KVTX struct {
+    db   *KV
+    tree b_tree.BTree // the private root
+    catalog b_tree.BTree // the private bucket catalog, see bucket.go
+    buckets map[string]*Bucket // buckets used by this transaction
+    noTTL bool // the TTL bucket doesn't exist, see ttlBucket
+    // for the rollback
+    root      uint64
+    catalogRoot uint64
+    flushed   uint64
+    free      FreeList
+    masterSeq  uint64
+    durableSeq uint64
+    // updates to be written to the WAL
+    ops []walOp
+    logBucket []byte // the bucket of the following ops, nil for the default
+    // some updates are not in `ops`, the commit needs a WAL checkpoint
+    unlogged bool
+    // a corrupt page leaves the private tree half updated,
+    // the transaction can only be aborted after that.
+    err error
+}
+
+// begin a transaction
+func (db *KV) Begin() *KVTX {
+    db.writer.Lock()
+    // pages released after the snapshot of the oldest reader may still be
+    // read by it, they can't be reused yet.
+    db.free.SetMaxSeq()
+    db.mu.Lock()
+    for seq := range db.readers {
+        if seq < db.free.maxSeq {
+            db.free.maxSeq = seq
+        }
+    }
+    db.mu.Unlock()
+    // with the WAL, the tree of the last master page must stay intact until
+    // the next checkpoint.
+    if db.durableSeq < db.free.maxSeq {
+        db.free.maxSeq = db.durableSeq
+    }
+
+    tx := &KVTX{db: db}
+    tx.root = db.tree.Root
+    tx.catalogRoot = db.catalog.Root
+    tx.flushed = db.page.flushed
+    tx.free = db.free
+    tx.masterSeq = db.masterSeq
+    tx.durableSeq = db.durableSeq
+    // the private tree can read pages staged by this transaction
+    tx.tree.Root = db.tree.Root
+    tx.tree.Get = db.pageGet
+    tx.tree.New = db.pageNew
+    tx.tree.Del = db.pageDel
+    tx.tree.Counted = db.tree.Counted
+    tx.catalog = tx.tree
+    tx.catalog.Root = db.catalog.Root
+    tx.buckets = map[string]*Bucket{}
+    return tx
+}
+
+// whether the transaction has anything to commit
+func (tx *KVTX) changed() bool {
+    db := tx.db
+    return tx.tree.Root != tx.root || tx.catalog.Root != tx.catalogRoot ||
+        len(db.page.updates) > 0 || db.page.nappend > 0
+}
+
+// the transaction becomes the last commit
+func (tx *KVTX) apply() {
+    tx.db.tree.Root = tx.tree.Root
+    tx.db.catalog.Root = tx.catalog.Root
+}
+
+// end a transaction: commit updates
+func (tx *KVTX) Commit() error {
+    db := tx.db
+    tx.flushBuckets()
+    if db.wal != nil {
+        return walCommit(tx)
+    }
+    defer db.writer.Unlock()
+    if tx.err != nil {
+        tx.rollback()
+        return tx.err
+    }
+    if !tx.changed() {
+        return nil // nothing to commit
+    }
+    tx.apply()
+    if err := flushPages(db); err != nil {
+        // the master page is not updated, revert to the last commit
+        tx.rollback()
+        return err
+    }
+    publish(db)
+    return nil
+}
+
+// end a transaction: discard updates
+func (tx *KVTX) Abort() {
+    defer tx.db.writer.Unlock()
+    tx.rollback()
+}
+
+// restore the in-memory states to the last commit and drop staged pages.
+// pages written to the file by a failed commit are unreferenced and harmless.
+func (tx *KVTX) rollback() {
+    db := tx.db
+    db.tree.Root = tx.root
+    db.catalog.Root = tx.catalogRoot
+    db.page.flushed = tx.flushed
+    db.page.nappend = 0
+    db.page.updates = map[uint64][]byte{}
+    db.free = tx.free
+    db.masterSeq = tx.masterSeq
+    db.durableSeq = tx.durableSeq
+}
+
+// remember a corrupt page error
+func (tx *KVTX) check(err error) error {
+    if errors.Is(err, b_tree.ErrCorruptPage) {
+        tx.err = err
+    }
+    return err
+}
+
+// read a key, including the uncommitted updates of this transaction.
+// an expired key is absent, see ttl.go
+func (tx *KVTX) Get(key []byte) ([]byte, bool, error) {
+    if tx.err != nil {
+        return nil, false, tx.err
+    }
+    val, found, err := tx.tree.GetKey(key)
+    if err != nil || !found {
+        return nil, false, tx.check(err)
+    }
+    b, err := tx.ttlBucket(false)
+    if err != nil {
+        return nil, false, err
+    }
+    expired, err := keyExpired(b, key, tx.db.now())
+    if err != nil || expired {
+        return nil, false, tx.check(err)
+    }
+    return val, true, nil
+}
+
+// scan keys in [start, end) as seen by this transaction
+func (tx *KVTX) Scan(start []byte, end []byte) *Scanner {
+    b, err := tx.ttlBucket(false)
+    return newScanner(&tx.tree, start, end).skipExpired(b, tx.db.now(), err)
+}
+
+// scan keys in [start, end) in descending order, as seen by this transaction
+func (tx *KVTX) ScanDesc(start []byte, end []byte) *Scanner {
+    b, err := tx.ttlBucket(false)
+    return newScannerDesc(&tx.tree, start, end).skipExpired(b, tx.db.now(), err)
+}
+
+func (tx *KVTX) Set(key []byte, val []byte) error {
+    if tx.err != nil {
+        return tx.err
+    }
+    if err := tx.check(tx.tree.Insert(key, val)); err != nil {
+        return err
+    }
+    tx.logOp(WAL_OP_SET, key, val)
+    return tx.clearTTL(key)
+}
+
+// insert or update depending on the mode, see BTree.InsertEx.
+// a failed mode doesn't abort the transaction. an expired key is absent.
+func (tx *KVTX) SetEx(key []byte, val []byte, mode int) (b_tree.InsertResult, error) {
+    if tx.err != nil {
+        return b_tree.InsertResult{}, tx.err
+    }
+    if err := tx.dropExpired(key); err != nil {
+        return b_tree.InsertResult{}, err
+    }
+    res, err := tx.tree.InsertEx(key, val, mode)
+    if err != nil {
+        return res, tx.check(err)
+    }
+    tx.logOp(WAL_OP_SET, key, val)
+    return res, tx.clearTTL(key)
+}
+
+func (tx *KVTX) Del(key []byte) (bool, error) {
+    if tx.err != nil {
+        return false, tx.err
+    }
+    deleted, err := tx.tree.DeleteKey(key)
+    if err != nil {
+        return false, tx.check(err)
+    }
+    if !deleted {
+        return false, nil
+    }
+    tx.logOp(WAL_OP_DEL, key, nil)
+    return true, tx.clearTTL(key)
+}
+
+// delete the keys in [start, end), a nil `end` means no upper bound.
+// returns the number of deleted keys.
+func (tx *KVTX) DeleteRange(start []byte, end []byte) (int, error) {
+    if tx.err != nil {
+        return 0, tx.err
+    }
+    count, err := tx.tree.DeleteRange(start, end)
+    if err != nil {
+        return 0, tx.check(err)
+    }
+    if count == 0 {
+        return 0, nil
+    }
+    tx.logOp(WAL_OP_DEL_RANGE, start, end)
+    return count, tx.clearTTLRange(start, end)
+}
@@ -0,0 +1,91 @@
+package kvstore
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTxCommit(t *testing.T) {
+    db := newTestKV(t)
+    assert.Nil(t, db.Set([]byte("k0"), []byte("v0")))
+
+    tx := db.Begin()
+    for i := 1; i < 100; i++ {
+        key := []byte(fmt.Sprintf("k%d", i))
+        assert.Nil(t, tx.Set(key, []byte(fmt.Sprintf("v%d", i))))
+    }
+    deleted, err := tx.Del([]byte("k0"))
+    assert.Nil(t, err)
+    assert.True(t, deleted)
+
+    // the updates are only visible to the transaction
+    _, ok, err := db.Get([]byte("k1"))
+    assert.Nil(t, err)
+    assert.False(t, ok)
+    val, ok, err := tx.Get([]byte("k1"))
+    assert.Nil(t, err)
+    assert.True(t, ok)
+    assert.Equal(t, val, []byte("v1"))
+    _, ok, err = tx.Get([]byte("k0"))
+    assert.Nil(t, err)
+    assert.False(t, ok)
+
+    count := 0
+    for sc := tx.Scan([]byte("k"), nil); sc.Valid(); sc.Next() {
+        count++
+    }
+    assert.Equal(t, count, 99)
+
+    assert.Nil(t, tx.Commit())
+    db = reopen(t, db)
+    _, ok, err = db.Get([]byte("k0"))
+    assert.Nil(t, err)
+    assert.False(t, ok)
+    for i := 1; i < 100; i++ {
+        val, ok, err := db.Get([]byte(fmt.Sprintf("k%d", i)))
+        assert.Nil(t, err)
+        assert.True(t, ok)
+        assert.Equal(t, val, []byte(fmt.Sprintf("v%d", i)))
+    }
+}
+
+func TestTxAbort(t *testing.T) {
+    db := newTestKV(t)
+    assert.Nil(t, db.Set([]byte("k0"), []byte("v0")))
+    used := db.page.flushed
+    free := db.free
+
+    tx := db.Begin()
+    for i := 1; i < 100; i++ {
+        assert.Nil(t, tx.Set([]byte(fmt.Sprintf("k%d", i)), make([]byte, 100)))
+    }
+    _, err := tx.Del([]byte("k0"))
+    assert.Nil(t, err)
+    tx.Abort()
+
+    assert.Equal(t, db.page.flushed, used)
+    assert.Equal(t, db.free.headSeq, free.headSeq)
+    assert.Equal(t, db.free.tailSeq, free.tailSeq)
+    assert.Empty(t, db.page.updates)
+    val, ok, err := db.Get([]byte("k0"))
+    assert.Nil(t, err)
+    assert.True(t, ok)
+    assert.Equal(t, val, []byte("v0"))
+    _, ok, err = db.Get([]byte("k1"))
+    assert.Nil(t, err)
+    assert.False(t, ok)
+
+    // the database is still usable
+    assert.Nil(t, db.Set([]byte("k1"), []byte("v1")))
+    db = reopen(t, db)
+    val, ok, err = db.Get([]byte("k0"))
+    assert.Nil(t, err)
+    assert.True(t, ok)
+    assert.Equal(t, val, []byte("v0"))
+    val, ok, err = db.Get([]byte("k1"))
+    assert.Nil(t, err)
+    assert.True(t, ok)
+    assert.Equal(t, val, []byte("v1"))
+}
@@ -0,0 +1,194 @@
+package kvstore
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"syscall"
+
+	"github.com/connnorchen/MyDb/internal/b_tree"
+)
+
+// Verify checks a database file for structural damage without modifying it
+// and returns every problem found. the returned error is for failing to
+// read the file. the file must not be in use.
+// besides the B-tree checks (see BTree.Verify), the free list must be
+// well formed, no page can be referenced twice by the trees (including the
+// bucket catalog and the buckets) and the free list, and every page must be
+// either in use or free.
+// the WAL is not replayed, the last checkpoint is checked.
+func Verify(path string) ([]error, error) {
+    fp, err := os.Open(path)
+    if err != nil {
+        return nil, fmt.Errorf("open: %w", err)
+    }
+    defer fp.Close()
+    fi, err := fp.Stat()
+    if err != nil {
+        return nil, fmt.Errorf("stat: %w", err)
+    }
+    size := int(fi.Size())
+    if size == 0 {
+        return nil, nil // empty database
+    }
+    if size % b_tree.BTREE_PAGE_SIZE != 0 {
+        return []error{errors.New("File size is not a multiple of page size")}, nil
+    }
+    chunk, err := syscall.Mmap(
+        int(fp.Fd()), 0, size, syscall.PROT_READ, syscall.MAP_SHARED,
+    )
+    if err != nil {
+        return nil, fmt.Errorf("mmap: %w", err)
+    }
+    defer syscall.Munmap(chunk)
+
+    // only the parts of the KV needed for reading
+    db := &KV{Path: path}
+    db.mmap.file = size
+    db.mmap.total = size
+    db.mmap.chunks = [][]byte{chunk}
+    if err := masterLoad(db); err != nil {
+        return []error{fmt.Errorf("master page: %w", err)}, nil
+    }
+    npages := db.page.flushed
+    db.tree.Get = func(ptr uint64) b_tree.BNode {
+        if ptr >= npages {
+            return b_tree.BNode{}
+        }
+        return pageVerified(db.mmap.chunks, ptr, db.checksums)
+    }
+
+    seen := map[uint64]bool{}
+    mark := func(ptr uint64) bool {
+        if seen[ptr] {
+            return false
+        }
+        seen[ptr] = true
+        return true
+    }
+    problems := db.tree.Verify(npages, mark)
+    problems = append(problems, verifyBuckets(db, npages, mark)...)
+    problems = append(problems, verifyFreeList(db, npages, mark)...)
+
+    // pages neither in use nor free are leaked, they are reported together
+    leaked := []string{}
+    for ptr := uint64(1); ptr < npages; ptr++ {
+        if seen[ptr] {
+            continue
+        }
+        end := ptr
+        for end + 1 < npages && !seen[end + 1] {
+            end++
+        }
+        if end == ptr {
+            leaked = append(leaked, fmt.Sprintf("%d", ptr))
+        } else {
+            leaked = append(leaked, fmt.Sprintf("%d-%d", ptr, end))
+        }
+        ptr = end
+    }
+    if len(leaked) > 0 {
+        problems = append(problems, fmt.Errorf(
+            "pages not referenced: %s", strings.Join(leaked, ", "),
+        ))
+    }
+    return problems, nil
+}
+
+// check the catalog and the tree of each bucket
+func verifyBuckets(db *KV, npages uint64, mark func(uint64) bool) []error {
+    catalog := db.tree
+    catalog.Root = db.catalog.Root
+    problems := catalog.Verify(npages, mark)
+    if len(problems) > 0 {
+        return problems // the buckets can't be trusted
+    }
+    iter := catalog.First()
+    for ; iter.Valid(); iter.Next() {
+        root, err := decodeBucketRoot(iter.Val())
+        if err != nil {
+            problems = append(problems, fmt.Errorf("bucket %q: %w", iter.Key(), err))
+            continue
+        }
+        tree := db.tree
+        tree.Root = root
+        for _, err := range tree.Verify(npages, mark) {
+            problems = append(problems, fmt.Errorf("bucket %q: %w", iter.Key(), err))
+        }
+    }
+    if err := iter.Err(); err != nil {
+        problems = append(problems, err)
+    }
+    return problems
+}
+
+// walk the free list from the head to the tail, marking its nodes and items
+func verifyFreeList(db *KV, npages uint64, mark func(uint64) bool) []error {
+    fl := &db.free
+    problems := []error{}
+    report := func(ptr uint64, format string, args ...interface{}) {
+        problems = append(problems, fmt.Errorf(
+            "%w: page %d: %s", b_tree.ErrCorruptPage, ptr, fmt.Sprintf(format, args...),
+        ))
+    }
+    // check a pointer stored in page `from`
+    follow := func(from uint64, ptr uint64, what string) bool {
+        if ptr == 0 || ptr >= npages {
+            report(from, "free list %s %d out of range", what, ptr)
+            return false
+        }
+        if !mark(ptr) {
+            report(ptr, "referenced more than once, again by the free list page %d", from)
+            return false
+        }
+        return true
+    }
+
+    if fl.tailPage == 0 {
+        if fl.headPage != 0 || fl.headSeq != fl.tailSeq {
+            report(0, "free list without a tail node")
+        }
+        return problems
+    }
+    // follow a link to a node and read it
+    var node []byte
+    next := func(from uint64, ptr uint64) bool {
+        if !follow(from, ptr, "node") {
+            return false
+        }
+        node = mmapPage(db.mmap.chunks, ptr).Data
+        if db.checksums && !pageCheck(node) {
+            report(ptr, "checksum mismatch")
+            return false
+        }
+        return true
+    }
+
+    ptr := fl.headPage
+    if !next(0, ptr) {
+        return problems
+    }
+    for seq := fl.headSeq; seq < fl.tailSeq; seq++ {
+        if seq != fl.headSeq && seq2idx(seq) == 0 {
+            link := flnNext(node)
+            if !next(ptr, link) {
+                return problems
+            }
+            ptr = link
+        }
+        follow(ptr, flnPtr(node, seq2idx(seq)), "item")
+    }
+    // a full tail node is followed by an empty one
+    if fl.tailSeq != fl.headSeq && seq2idx(fl.tailSeq) == 0 {
+        link := flnNext(node)
+        if !next(ptr, link) {
+            return problems
+        }
+        ptr = link
+    }
+    if ptr != fl.tailPage {
+        report(ptr, "the free list ends here instead of the tail page %d", fl.tailPage)
+    }
+    return problems
+}
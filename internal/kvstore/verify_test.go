@@ -0,0 +1,96 @@
+package kvstore
+
+import (
+	"encoding/binary"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/connnorchen/MyDb/internal/b_tree"
+	"github.com/stretchr/testify/assert"
+)
+
+func verifyOK(t *testing.T, path string) {
+    problems, err := Verify(path)
+    assert.Nil(t, err)
+    assert.Empty(t, problems)
+}
+
+func TestVerifyChurn(t *testing.T) {
+    db := newTestKV(t)
+    verifyOK(t, db.Path)
+    for round := 0; round < 10; round++ {
+        for i := 0; i < 200; i++ {
+            key := []byte(fmt.Sprintf("key%d", i))
+            if (i + round) % 3 == 0 {
+                _, err := db.Del(key)
+                assert.Nil(t, err)
+            } else {
+                assert.Nil(t, db.Set(key, make([]byte, 100 * (i % 50))))
+            }
+        }
+    }
+    assert.Greater(t, db.free.Total(), 0)
+    verifyOK(t, db.Path)
+
+    // the WAL keeps the last checkpoint intact between checkpoints
+    path := filepath.Join(t.TempDir(), "wal")
+    db = newTestWAL(t, path, 32 << 10)
+    for i := 0; i < 500; i++ {
+        assert.Nil(t, db.Set([]byte(fmt.Sprintf("key%d", i % 50)), make([]byte, 200)))
+        if i % 100 == 0 {
+            verifyOK(t, path)
+        }
+    }
+}
+
+func TestVerifyProblems(t *testing.T) {
+    db := newTestKV(t)
+    for i := 0; i < 200; i++ {
+        assert.Nil(t, db.Set([]byte(fmt.Sprintf("key%03d", i)), make([]byte, 100)))
+    }
+    for i := 0; i < 100; i++ {
+        _, err := db.Del([]byte(fmt.Sprintf("key%03d", i)))
+        assert.Nil(t, err)
+    }
+    rootPtr := db.tree.Root
+    rootData := append([]byte{}, db.tree.Get(rootPtr).Data...)
+    assert.Greater(t, db.free.Total(), 0)
+    // the first item of the free list, seen as a page in use by the tree
+    free := flnPtr(db.pageRead(db.free.headPage), seq2idx(db.free.headSeq))
+    path := db.Path
+    db.Close()
+
+    // the first kid of the root points to a free page
+    kid := binary.LittleEndian.Uint64(rootData[4:])
+    binary.LittleEndian.PutUint64(rootData[4:], free)
+    pageSeal(rootData) // a consistent page with a bad pointer
+    damage(t, path, int64(rootPtr) * b_tree.BTREE_PAGE_SIZE, rootData)
+
+    problems, err := Verify(path)
+    assert.Nil(t, err)
+    msgs := ""
+    for _, p := range problems {
+        msgs += p.Error() + "\n"
+    }
+    // the free page is not a node
+    assert.Contains(t, msgs, fmt.Sprintf("page %d: ", free))
+    // it's also in the free list
+    assert.Contains(t, msgs, fmt.Sprintf("page %d: referenced more than once", free))
+    // the old kid is lost
+    assert.Regexp(t, fmt.Sprintf(`pages not referenced: (.*, )?%d\b`, kid), msgs)
+
+    // bit rot in the root
+    damage(t, path, int64(rootPtr) * b_tree.BTREE_PAGE_SIZE + 100, []byte{0xff})
+    problems, err = Verify(path)
+    assert.Nil(t, err)
+    assert.ErrorContains(t, problems[0], fmt.Sprintf("page %d: checksum mismatch", rootPtr))
+
+    // a bad master page
+    damage(t, path, 30, []byte{0xff})
+    damage(t, path, MASTER_SLOT_DISTANCE + 30, []byte{0xff})
+    problems, err = Verify(path)
+    assert.Nil(t, err)
+    assert.Len(t, problems, 1)
+    assert.ErrorContains(t, problems[0], "master page")
+}
@@ -0,0 +1,421 @@
+package kvstore
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"sync"
+)
+
+// the optional write-ahead log (WAL).
+// without it, each commit writes its pages and the master page with 2 fsyncs.
+// with it, a commit writes its pages to the mmap without syncing and appends
+// its updates to the log. concurrent commits share a single fsync of the log
+// (group commit), a commit becomes visible to readers once it's durable.
+//
+// the pages are persisted by a checkpoint: fsync the file, update the master
+// page, then truncate the log. it happens when the log grows past
+// `WALCheckpointSize` and on Close. pages released after the last checkpoint
+// are not reused until the next one, so a crash always finds the tree of
+// the last master page intact, and the log is replayed on top of it on Open.
+//
+// the log is a sequence of records, one per commit:
+// | crc32 | size | ops  |
+// |  4B   |  8B  | size |
+// op format:
+// | type | klen | vlen | key | val |
+// |  1B  |  4B  |  4B  | ... | ... |
+// a range deletion stores the start as the key and the end as the value,
+// an empty end means no upper bound.
+// updates apply to the default keyspace unless a bucket op selects a bucket
+// by its name (the key) for the following ops of the record, an empty name
+// selects the default keyspace again.
+//
+// the crc32 covers everything after it, a torn record at the end of the log
+// is a commit that never completed and is ignored.
+// a crash between the master page update and the truncation replays the
+// updates again on top of their own result, so the replay is idempotent:
+// Set and Del are, creating an existing bucket or dropping a missing one is
+// skipped, and so are the updates of a missing bucket, which is dropped
+// later in the log.
+const (
+    WAL_HEADER = 12
+    WAL_OP_HEADER = 9
+    WAL_DEFAULT_CHECKPOINT_SIZE = 16 << 20
+)
+
+const (
+    WAL_OP_SET = 1
+    WAL_OP_DEL = 2
+    WAL_OP_DEL_RANGE = 3
+    WAL_OP_BUCKET = 4
+    WAL_OP_CREATE_BUCKET = 5
+    WAL_OP_DROP_BUCKET = 6
+)
+
+type walOp struct {
+    op  byte
+    key []byte
+    val []byte
+}
+
+type wal struct {
+    fp *os.File
+    mu sync.Mutex // protects the states below
+    cond *sync.Cond
+    buf  []byte // records not written yet
+    size int64  // the log size including `buf`
+    // commits are numbered in the log order
+    lsn     uint64      // the last appended commit
+    state   commitState // the state after `lsn`
+    synced  uint64      // the last durable commit
+    syncing bool        // a committer is writing the log
+    // an I/O error is not recovered from, all later commits fail
+    err error
+    nsync int // number of fsyncs of the log
+}
+
+func walEncode(ops []walOp) []byte {
+    size := 0
+    for _, op := range ops {
+        size += WAL_OP_HEADER + len(op.key) + len(op.val)
+    }
+    rec := make([]byte, WAL_HEADER + size)
+    binary.LittleEndian.PutUint64(rec[4:], uint64(size))
+    pos := WAL_HEADER
+    for _, op := range ops {
+        rec[pos] = op.op
+        binary.LittleEndian.PutUint32(rec[pos + 1:], uint32(len(op.key)))
+        binary.LittleEndian.PutUint32(rec[pos + 5:], uint32(len(op.val)))
+        pos += WAL_OP_HEADER
+        pos += copy(rec[pos:], op.key)
+        pos += copy(rec[pos:], op.val)
+    }
+    binary.LittleEndian.PutUint32(rec[0:], crc32.ChecksumIEEE(rec[4:]))
+    return rec
+}
+
+// decode the record at the start of `data`, returns its size.
+// an incomplete or damaged record yields an error.
+func walDecode(data []byte) ([]walOp, int, error) {
+    if len(data) < WAL_HEADER {
+        return nil, 0, errors.New("incomplete record")
+    }
+    size := binary.LittleEndian.Uint64(data[4:])
+    if size > uint64(len(data) - WAL_HEADER) {
+        return nil, 0, errors.New("incomplete record")
+    }
+    rec := data[:WAL_HEADER + int(size)]
+    if binary.LittleEndian.Uint32(rec[0:]) != crc32.ChecksumIEEE(rec[4:]) {
+        return nil, 0, errors.New("bad checksum")
+    }
+
+    ops := []walOp{}
+    for pos := WAL_HEADER; pos < len(rec); {
+        if pos + WAL_OP_HEADER > len(rec) {
+            return nil, 0, errors.New("bad op")
+        }
+        op := walOp{op: rec[pos]}
+        klen := int(binary.LittleEndian.Uint32(rec[pos + 1:]))
+        vlen := int(binary.LittleEndian.Uint32(rec[pos + 5:]))
+        pos += WAL_OP_HEADER
+        if klen > len(rec) - pos || vlen > len(rec) - pos - klen {
+            return nil, 0, errors.New("bad op")
+        }
+        op.key = rec[pos:pos + klen]
+        op.val = rec[pos + klen:pos + klen + vlen]
+        pos += klen + vlen
+        if op.op < WAL_OP_SET || op.op > WAL_OP_DROP_BUCKET {
+            return nil, 0, fmt.Errorf("bad op type %d", op.op)
+        }
+        ops = append(ops, op)
+    }
+    return ops, len(rec), nil
+}
+
+// remember an update of the default keyspace for the log
+func (tx *KVTX) logOp(op byte, key []byte, val []byte) {
+    tx.logBucketOp(nil, op, key, val)
+}
+
+// remember an update of a bucket, nil for the default keyspace
+func (tx *KVTX) logBucketOp(bucket []byte, op byte, key []byte, val []byte) {
+    if tx.db.wal == nil {
+        return
+    }
+    if !bytes.Equal(bucket, tx.logBucket) {
+        tx.ops = append(tx.ops, walOp{
+            op: WAL_OP_BUCKET, key: append([]byte(nil), bucket...), val: []byte{},
+        })
+        tx.logBucket = append([]byte(nil), bucket...)
+    }
+    // the caller may reuse the buffers before the commit
+    tx.ops = append(tx.ops, walOp{
+        op: op, key: append([]byte(nil), key...), val: append([]byte(nil), val...),
+    })
+}
+
+// open the log and replay it on top of the last master page
+func walOpen(db *KV) error {
+    fp, err := os.OpenFile(db.Path + "-wal", os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
+    if err != nil {
+        return fmt.Errorf("open WAL: %w", err)
+    }
+    data, err := io.ReadAll(fp)
+    if err != nil {
+        fp.Close()
+        return fmt.Errorf("read WAL: %w", err)
+    }
+
+    // all the complete records are applied in one commit
+    tx := db.Begin()
+    for len(data) > 0 {
+        ops, size, err := walDecode(data)
+        if err != nil {
+            break // the end of the log
+        }
+        data = data[size:]
+        var bucket *Bucket // nil for the default keyspace
+        for _, op := range ops {
+            err = walReplay(tx, &bucket, op)
+            if err != nil {
+                tx.Abort()
+                fp.Close()
+                return fmt.Errorf("replay WAL: %w", err)
+            }
+        }
+    }
+    if err := tx.Commit(); err != nil {
+        fp.Close()
+        return fmt.Errorf("replay WAL: %w", err)
+    }
+    if err := fp.Truncate(0); err != nil {
+        fp.Close()
+        return fmt.Errorf("truncate WAL: %w", err)
+    }
+
+    if db.WALCheckpointSize <= 0 {
+        db.WALCheckpointSize = WAL_DEFAULT_CHECKPOINT_SIZE
+    }
+    w := &wal{fp: fp, state: currentState(db)}
+    w.cond = sync.NewCond(&w.mu)
+    db.wal = w
+    return nil
+}
+
+// apply a logged update, `bucket` is the current bucket of the record
+func walReplay(tx *KVTX, bucket **Bucket, op walOp) (err error) {
+    switch {
+    case op.op == WAL_OP_BUCKET && len(op.key) == 0:
+        *bucket = nil
+    case op.op == WAL_OP_BUCKET:
+        *bucket, err = tx.Bucket(op.key)
+        if errors.Is(err, ErrBucketNotFound) {
+            // already dropped, its updates are skipped
+            *bucket, err = &Bucket{name: op.key, dropped: true}, nil
+        }
+    case op.op == WAL_OP_CREATE_BUCKET:
+        if err = tx.createBucket(op.key); errors.Is(err, ErrBucketExists) {
+            err = nil
+        }
+    case op.op == WAL_OP_DROP_BUCKET:
+        if err = tx.dropBucket(op.key); errors.Is(err, ErrBucketNotFound) {
+            err = nil
+        }
+    case *bucket != nil && (*bucket).dropped && (op.op == WAL_OP_SET || op.op == WAL_OP_DEL):
+        // updates of a dropped bucket
+    case op.op == WAL_OP_SET && *bucket != nil:
+        err = (*bucket).Set(op.key, op.val)
+    case op.op == WAL_OP_SET:
+        err = tx.Set(op.key, op.val)
+    case op.op == WAL_OP_DEL && *bucket != nil:
+        _, err = (*bucket).Del(op.key)
+    case op.op == WAL_OP_DEL:
+        _, err = tx.Del(op.key)
+    case op.op == WAL_OP_DEL_RANGE:
+        end := op.val
+        if len(end) == 0 {
+            end = nil
+        }
+        _, err = tx.DeleteRange(op.key, end)
+    }
+    return err
+}
+
+// checkpoint and close the log
+func walClose(db *KV) {
+    db.writer.Lock()
+    // a failed checkpoint leaves the log to be replayed
+    _ = walCheckpoint(db)
+    db.writer.Unlock()
+    _ = db.wal.fp.Close()
+    db.wal = nil
+}
+
+// commit a transaction with the WAL, it returns once the log is durable
+func walCommit(tx *KVTX) error {
+    db := tx.db
+    w := db.wal
+    lsn, err := func() (uint64, error) {
+        defer db.writer.Unlock()
+        if tx.err != nil {
+            tx.rollback()
+            return 0, tx.err
+        }
+        if err := w.failed(); err != nil {
+            tx.rollback()
+            return 0, err
+        }
+        if !tx.changed() {
+            return 0, nil // nothing to commit
+        }
+        // write the pages without syncing, the master page is not updated
+        tx.apply()
+        if err := writePages(db); err != nil {
+            tx.rollback()
+            return 0, err
+        }
+        db.page.flushed += db.page.nappend
+        db.page.nappend = 0
+        db.page.updates = map[uint64][]byte{}
+
+        if tx.unlogged {
+            // persisted by a checkpoint instead of the log
+            lsn := w.append(nil, currentState(db))
+            return lsn, walCheckpoint(db)
+        }
+        lsn := w.append(walEncode(tx.ops), currentState(db))
+        if w.checkpointSize() >= db.WALCheckpointSize {
+            return lsn, walCheckpoint(db)
+        }
+        return lsn, nil
+    }()
+    if err != nil || lsn == 0 {
+        return err
+    }
+    return w.wait(db, lsn)
+}
+
+func (w *wal) failed() error {
+    w.mu.Lock()
+    defer w.mu.Unlock()
+    return w.err
+}
+
+func (w *wal) checkpointSize() int64 {
+    w.mu.Lock()
+    defer w.mu.Unlock()
+    return w.size
+}
+
+// add a record to the buffer, returns its commit number.
+// a nil record is a commit persisted by a checkpoint.
+func (w *wal) append(rec []byte, state commitState) uint64 {
+    w.mu.Lock()
+    defer w.mu.Unlock()
+    w.buf = append(w.buf, rec...)
+    w.size += int64(len(rec))
+    w.lsn++
+    w.state = state
+    return w.lsn
+}
+
+// wait until the commit `lsn` is durable.
+// the first waiter writes and syncs everything buffered so far on behalf
+// of the others, commits appended meanwhile are synced by the next one.
+func (w *wal) wait(db *KV, lsn uint64) error {
+    w.mu.Lock()
+    defer w.mu.Unlock()
+    for w.synced < lsn && w.err == nil {
+        if w.syncing {
+            w.cond.Wait()
+            continue
+        }
+        w.syncing = true
+        buf, upto, state := w.buf, w.lsn, w.state
+        w.buf = nil
+        w.mu.Unlock()
+        err := w.write(buf)
+        w.mu.Lock()
+        w.syncing = false
+        if err != nil {
+            w.err = err
+        } else {
+            w.synced = upto
+            publishState(db, state)
+        }
+        w.cond.Broadcast()
+    }
+    if w.synced >= lsn {
+        return nil
+    }
+    return w.err
+}
+
+func (w *wal) write(buf []byte) error {
+    if _, err := w.fp.Write(buf); err != nil {
+        return fmt.Errorf("write WAL: %w", err)
+    }
+    if err := w.fp.Sync(); err != nil {
+        return fmt.Errorf("fsync WAL: %w", err)
+    }
+    w.nsync++
+    return nil
+}
+
+// persist the pages and empty the log, the caller holds the writer lock.
+// all commits so far become durable and visible.
+func walCheckpoint(db *KV) error {
+    w := db.wal
+    w.mu.Lock()
+    defer w.mu.Unlock()
+    for w.syncing {
+        w.cond.Wait()
+    }
+    if w.err != nil {
+        return w.err
+    }
+    if w.size == 0 && w.synced == w.lsn {
+        return nil // nothing since the last checkpoint
+    }
+
+    err := func() error {
+        if err := db.fp.Sync(); err != nil {
+            return fmt.Errorf("fsync: %w", err)
+        }
+        if err := masterStore(db); err != nil {
+            return err
+        }
+        if err := db.fp.Sync(); err != nil {
+            return fmt.Errorf("fsync: %w", err)
+        }
+        if err := w.fp.Truncate(0); err != nil {
+            return fmt.Errorf("truncate WAL: %w", err)
+        }
+        return nil
+    }()
+    if err != nil {
+        w.err = err
+    } else {
+        w.buf = nil
+        w.size = 0
+        w.synced = w.lsn
+        publishState(db, w.state)
+    }
+    w.cond.Broadcast()
+    return err
+}
+
+// force a checkpoint, a no-op without the WAL
+func (db *KV) Checkpoint() error {
+    if db.wal == nil {
+        return nil
+    }
+    db.writer.Lock()
+    defer db.writer.Unlock()
+    return walCheckpoint(db)
+}
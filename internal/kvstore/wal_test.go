@@ -0,0 +1,207 @@
+package kvstore
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestWAL(t *testing.T, path string, checkpoint int64) *KV {
+    db := &KV{Path: path, WAL: true, WALCheckpointSize: checkpoint}
+    assert.Nil(t, db.Open())
+    t.Cleanup(db.Close)
+    return db
+}
+
+// stop using the files without a checkpoint, as if the process died
+func crash(db *KV) {
+    db.stopSweeper()
+    for _, chunk := range db.mmap.chunks {
+        syscall.Munmap(chunk)
+    }
+    db.mmap.chunks = nil
+    db.fp.Close()
+    db.wal.fp.Close()
+    db.wal = nil
+}
+
+func walSize(t *testing.T, db *KV) int64 {
+    fi, err := os.Stat(db.Path + "-wal")
+    assert.Nil(t, err)
+    return fi.Size()
+}
+
+func TestWALEncodeDecode(t *testing.T) {
+    ops := []walOp{
+        {op: WAL_OP_SET, key: []byte("k1"), val: []byte("v1")},
+        {op: WAL_OP_DEL, key: []byte("k2"), val: []byte{}},
+    }
+    rec := walEncode(ops)
+    decoded, size, err := walDecode(append(rec, 1, 2, 3))
+    assert.Nil(t, err)
+    assert.Equal(t, size, len(rec))
+    assert.Equal(t, decoded, ops)
+
+    _, _, err = walDecode(rec[:len(rec) - 1])
+    assert.NotNil(t, err)
+    rec[WAL_HEADER + 2] ^= 1
+    _, _, err = walDecode(rec)
+    assert.NotNil(t, err)
+}
+
+func TestWALReplay(t *testing.T) {
+    path := filepath.Join(t.TempDir(), "db")
+    db := newTestWAL(t, path, 0)
+    assert.Nil(t, db.Set([]byte("k0"), []byte("old")))
+    assert.Nil(t, db.Checkpoint())
+    masterSeq := db.masterSeq
+
+    for i := 0; i < 100; i++ {
+        assert.Nil(t, db.Set([]byte(fmt.Sprintf("k%d", i)), []byte(fmt.Sprintf("v%d", i))))
+    }
+    _, err := db.Del([]byte("k1"))
+    assert.Nil(t, err)
+    // the master page is not updated by commits
+    assert.Equal(t, db.masterSeq, masterSeq)
+    assert.Greater(t, walSize(t, db), int64(0))
+    crash(db)
+
+    // without the log, the last checkpoint is intact
+    plain := &KV{Path: path}
+    assert.Nil(t, plain.Open())
+    val, ok, err := plain.Get([]byte("k0"))
+    assert.Nil(t, err)
+    assert.True(t, ok)
+    assert.Equal(t, val, []byte("old"))
+    plain.Close()
+
+    db = newTestWAL(t, path, 0)
+    assert.Equal(t, walSize(t, db), int64(0))
+    for i := 0; i < 100; i++ {
+        val, ok, err := db.Get([]byte(fmt.Sprintf("k%d", i)))
+        assert.Nil(t, err)
+        if i == 1 {
+            assert.False(t, ok)
+        } else {
+            assert.True(t, ok)
+            assert.Equal(t, val, []byte(fmt.Sprintf("v%d", i)))
+        }
+    }
+}
+
+func TestWALTornRecord(t *testing.T) {
+    path := filepath.Join(t.TempDir(), "db")
+    db := newTestWAL(t, path, 0)
+    assert.Nil(t, db.Set([]byte("k1"), []byte("v1")))
+    assert.Nil(t, db.Set([]byte("k2"), []byte("v2")))
+    size := walSize(t, db)
+    crash(db)
+
+    // the last record is partially written
+    assert.Nil(t, os.Truncate(path + "-wal", size - 1))
+    db = newTestWAL(t, path, 0)
+    _, ok, err := db.Get([]byte("k1"))
+    assert.Nil(t, err)
+    assert.True(t, ok)
+    _, ok, err = db.Get([]byte("k2"))
+    assert.Nil(t, err)
+    assert.False(t, ok)
+}
+
+func TestWALCheckpoint(t *testing.T) {
+    path := filepath.Join(t.TempDir(), "db")
+    db := newTestWAL(t, path, 64 << 10)
+    for i := 0; i < 1000; i++ {
+        key := []byte(fmt.Sprintf("key%d", i % 100))
+        assert.Nil(t, db.Set(key, make([]byte, 500)))
+        assert.LessOrEqual(t, walSize(t, db), int64(64 << 10))
+    }
+    assert.Greater(t, db.masterSeq, uint64(1))
+    // pages are reused across checkpoints
+    assert.Less(t, db.page.flushed, uint64(400))
+
+    // Close checkpoints the rest
+    db.Close()
+    db = &KV{Path: path}
+    assert.Nil(t, db.Open())
+    t.Cleanup(db.Close)
+    for i := 0; i < 100; i++ {
+        _, ok, err := db.Get([]byte(fmt.Sprintf("key%d", i)))
+        assert.Nil(t, err)
+        assert.True(t, ok)
+    }
+}
+
+func TestWALGroupCommit(t *testing.T) {
+    path := filepath.Join(t.TempDir(), "db")
+    db := newTestWAL(t, path, 0)
+    const nwriters = 16
+    const nkeys = 50
+    var wg sync.WaitGroup
+    for i := 0; i < nwriters; i++ {
+        wg.Add(1)
+        go func(i int) {
+            defer wg.Done()
+            for j := 0; j < nkeys; j++ {
+                key := []byte(fmt.Sprintf("w%d-%d", i, j))
+                assert.Nil(t, db.Set(key, key))
+                // visible once committed
+                val, ok, err := db.Get(key)
+                assert.Nil(t, err)
+                assert.True(t, ok)
+                assert.Equal(t, val, key)
+            }
+        }(i)
+    }
+    wg.Wait()
+    t.Logf("%d commits, %d fsyncs", nwriters * nkeys, db.wal.nsync)
+    assert.LessOrEqual(t, db.wal.nsync, nwriters * nkeys)
+
+    crash(db)
+    db = newTestWAL(t, path, 0)
+    for i := 0; i < nwriters; i++ {
+        for j := 0; j < nkeys; j++ {
+            key := []byte(fmt.Sprintf("w%d-%d", i, j))
+            val, ok, err := db.Get(key)
+            assert.Nil(t, err)
+            assert.True(t, ok)
+            assert.Equal(t, val, key)
+        }
+    }
+}
+
+func TestWALDeleteRange(t *testing.T) {
+    path := filepath.Join(t.TempDir(), "db")
+    db := newTestWAL(t, path, 0)
+    for i := 0; i < 1000; i++ {
+        key := []byte(fmt.Sprintf("key%03d", i))
+        assert.Nil(t, db.Set(key, key))
+    }
+    assert.Nil(t, db.Checkpoint())
+    count, err := db.DeleteRange([]byte("key100"), []byte("key900"))
+    assert.Nil(t, err)
+    assert.Equal(t, count, 800)
+    count, err = db.DeleteRange([]byte("key950"), nil)
+    assert.Nil(t, err)
+    assert.Equal(t, count, 50)
+    crash(db)
+
+    db = newTestWAL(t, path, 0)
+    count = 0
+    sc := db.Scan([]byte{}, nil)
+    for ; sc.Valid(); sc.Next() {
+        count++
+    }
+    sc.Close()
+    assert.Equal(t, count, 150)
+    _, ok, err := db.Get([]byte("key949"))
+    assert.Nil(t, err)
+    assert.True(t, ok)
+    db.Close()
+    verifyOK(t, path)
+}
@@ -0,0 +1,404 @@
+package query
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/connnorchen/MyDb/internal/table"
+)
+
+// the result of a statement
+type Result struct {
+    Cols     []string // nil if the statement returns no rows
+    Rows     [][]table.Value
+    Affected int    // rows inserted, updated or deleted
+    Plan     string // how the rows were found, see plan
+}
+
+// where the rows come from, a transaction or a snapshot
+type source interface {
+    TableDef(name string) (*table.TableDef, error)
+    ScanRange(name string, r table.Range) (*table.Scanner, error)
+}
+
+// parse and execute a statement
+func Run(db *table.DB, src string) (*Result, error) {
+    stmt, err := Parse(src)
+    if err != nil {
+        return nil, err
+    }
+    return Exec(db, stmt)
+}
+
+// execute a statement, a SELECT reads a snapshot, the others run in a
+// transaction which is committed if the statement succeeds
+func Exec(db *table.DB, stmt Stmt) (*Result, error) {
+    if stmt, ok := stmt.(*Select); ok {
+        reader := db.BeginRead()
+        defer reader.Close()
+        return execSelect(reader, stmt)
+    }
+    tx := db.Begin()
+    res, err := execWrite(tx, stmt)
+    if err != nil {
+        tx.Abort()
+        return nil, err
+    }
+    return res, tx.Commit()
+}
+
+func execWrite(tx *table.TX, stmt Stmt) (*Result, error) {
+    switch stmt := stmt.(type) {
+    case *CreateTable:
+        def := stmt.Def
+        return &Result{}, tx.CreateTable(&def)
+    case *CreateIndex:
+        return &Result{}, tx.CreateIndex(stmt.Table, stmt.Cols)
+    case *DropTable:
+        return &Result{}, tx.DropTable(stmt.Table)
+    case *Insert:
+        return execInsert(tx, stmt)
+    case *Select:
+        return execSelect(tx, stmt)
+    case *Update:
+        return execUpdate(tx, stmt)
+    case *Delete:
+        return execDelete(tx, stmt)
+    }
+    panic("unreachable")
+}
+
+func execInsert(tx *table.TX, stmt *Insert) (*Result, error) {
+    def, err := tx.TableDef(stmt.Table)
+    if err != nil {
+        return nil, err
+    }
+    cols := stmt.Cols
+    if cols == nil {
+        cols = def.Cols
+    }
+    for _, row := range stmt.Rows {
+        if len(row) != len(cols) {
+            return nil, fmt.Errorf("%w: %d values for %d columns", ErrBadQuery, len(row), len(cols))
+        }
+        rec := table.Record{Cols: cols, Vals: row}
+        if err := tx.Insert(stmt.Table, rec); err != nil {
+            return nil, err
+        }
+    }
+    return &Result{Affected: len(stmt.Rows)}, nil
+}
+
+func execSelect(src source, stmt *Select) (*Result, error) {
+    def, err := src.TableDef(stmt.Table)
+    if err != nil {
+        return nil, err
+    }
+    cols := stmt.Cols
+    if cols == nil {
+        cols = def.Cols
+    }
+    pos := make([]int, len(cols))
+    for i, col := range cols {
+        if pos[i] = colIndex(def, col); pos[i] < 0 {
+            return nil, fmt.Errorf("%w: table %s has no column %q", ErrBadQuery, def.Name, col)
+        }
+    }
+    res := &Result{Cols: cols, Rows: [][]table.Value{}}
+    res.Plan, err = scanRows(src, def, stmt.Where, stmt.OrderBy, stmt.Desc, stmt.Limit,
+        func(row table.Record) {
+            vals := make([]table.Value, len(pos))
+            for i, p := range pos {
+                vals[i] = row.Vals[p]
+            }
+            res.Rows = append(res.Rows, vals)
+        },
+    )
+    if err != nil {
+        return nil, err
+    }
+    return res, nil
+}
+
+// the rows are collected before they are changed, the scanner is
+// invalidated by the updates
+func execUpdate(tx *table.TX, stmt *Update) (*Result, error) {
+    def, err := tx.TableDef(stmt.Table)
+    if err != nil {
+        return nil, err
+    }
+    pkChanged := false
+    for _, set := range stmt.Set {
+        i := colIndex(def, set.Col)
+        if i < 0 {
+            return nil, fmt.Errorf("%w: table %s has no column %q", ErrBadQuery, def.Name, set.Col)
+        }
+        if err := checkType(def, i, set.Val); err != nil {
+            return nil, err
+        }
+        pkChanged = pkChanged || i < def.PKeys
+    }
+    rows := []table.Record{}
+    plan, err := scanRows(tx, def, stmt.Where, nil, false, -1, func(row table.Record) {
+        rows = append(rows, row)
+    })
+    if err != nil {
+        return nil, err
+    }
+    for _, row := range rows {
+        updated := table.Record{Cols: row.Cols, Vals: append([]table.Value(nil), row.Vals...)}
+        for _, set := range stmt.Set {
+            updated.Vals[colIndex(def, set.Col)] = set.Val
+        }
+        if !pkChanged {
+            err = tx.Update(def.Name, updated)
+        } else if _, err = tx.Delete(def.Name, primaryKey(def, row)); err == nil {
+            err = tx.Insert(def.Name, updated)
+        }
+        if err != nil {
+            return nil, err
+        }
+    }
+    return &Result{Affected: len(rows), Plan: plan}, nil
+}
+
+func execDelete(tx *table.TX, stmt *Delete) (*Result, error) {
+    def, err := tx.TableDef(stmt.Table)
+    if err != nil {
+        return nil, err
+    }
+    keys := []table.Record{}
+    plan, err := scanRows(tx, def, stmt.Where, nil, false, -1, func(row table.Record) {
+        keys = append(keys, primaryKey(def, row))
+    })
+    if err != nil {
+        return nil, err
+    }
+    for _, key := range keys {
+        if _, err := tx.Delete(def.Name, key); err != nil {
+            return nil, err
+        }
+    }
+    return &Result{Affected: len(keys), Plan: plan}, nil
+}
+
+func primaryKey(def *table.TableDef, row table.Record) table.Record {
+    return table.Record{Cols: def.Cols[:def.PKeys], Vals: row.Vals[:def.PKeys]}
+}
+
+// call `fn` with the matching rows in the order, up to `limit` rows.
+// returns the plan.
+func scanRows(
+    src source, def *table.TableDef, where []Cond, orderBy []string, desc bool, limit int64,
+    fn func(row table.Record),
+) (string, error) {
+    for _, cond := range where {
+        i := colIndex(def, cond.Col)
+        if i < 0 {
+            return "", fmt.Errorf("%w: table %s has no column %q", ErrBadQuery, def.Name, cond.Col)
+        }
+        if err := checkType(def, i, cond.Val); err != nil {
+            return "", err
+        }
+    }
+    for _, col := range orderBy {
+        if colIndex(def, col) < 0 {
+            return "", fmt.Errorf("%w: table %s has no column %q", ErrBadQuery, def.Name, col)
+        }
+    }
+    p, err := plan(def, where, orderBy)
+    if err != nil {
+        return "", err
+    }
+    p.r.Desc = desc
+    sc, err := src.ScanRange(def.Name, p.r)
+    if err != nil {
+        return "", err
+    }
+    for n := int64(0); sc.Valid() && n != limit; sc.Next() {
+        row := table.Record{}
+        if err := sc.Deref(&row); err != nil {
+            return "", err
+        }
+        if matchAll(def, row, where) {
+            fn(row)
+            n++
+        }
+    }
+    return p.desc, sc.Err()
+}
+
+func colIndex(def *table.TableDef, col string) int {
+    for i, c := range def.Cols {
+        if c == col {
+            return i
+        }
+    }
+    return -1
+}
+
+// a literal must have the type of the column, bytes and strings mix
+func checkType(def *table.TableDef, col int, val table.Value) error {
+    if (val.Type == table.TYPE_INT64) != (def.Types[col] == table.TYPE_INT64) {
+        return fmt.Errorf("%w: wrong type for column %s", ErrBadQuery, def.Cols[col])
+    }
+    return nil
+}
+
+func compareValues(a table.Value, b table.Value) int {
+    if a.Type == table.TYPE_INT64 {
+        switch {
+        case a.I64 < b.I64:
+            return -1
+        case a.I64 > b.I64:
+            return 1
+        }
+        return 0
+    }
+    return bytes.Compare(a.Str, b.Str)
+}
+
+func matchAll(def *table.TableDef, row table.Record, where []Cond) bool {
+    for _, cond := range where {
+        cmp := compareValues(row.Vals[colIndex(def, cond.Col)], cond.Val)
+        ok := false
+        switch cond.Op {
+        case "=":
+            ok = cmp == 0
+        case "!=":
+            ok = cmp != 0
+        case "<":
+            ok = cmp < 0
+        case "<=":
+            ok = cmp <= 0
+        case ">":
+            ok = cmp > 0
+        case ">=":
+            ok = cmp >= 0
+        }
+        if !ok {
+            return false
+        }
+    }
+    return true
+}
+
+// how a query reads the rows
+type queryPlan struct {
+    r    table.Range
+    desc string // e.g. "index (city, age) range"
+}
+
+// pick the primary key or an index whose key columns start with the most
+// columns compared by `=`, then the one with bounds on the next column.
+// a range scan reads only the rows within the bounds, all the conditions
+// are still checked on each row. the order of the rows must follow the
+// key, after the columns compared by `=`.
+func plan(def *table.TableDef, where []Cond, orderBy []string) (queryPlan, error) {
+    // the key columns of the primary key, then of each index
+    paths := [][]string{def.Cols[:def.PKeys]}
+    for _, index := range def.Indexes {
+        key := append([]string(nil), index.Cols...)
+        for _, pk := range def.Cols[:def.PKeys] {
+            if !contains(key, pk) {
+                key = append(key, pk)
+            }
+        }
+        paths = append(paths, key)
+    }
+
+    best, bestScore := queryPlan{}, -1
+    for i, key := range paths {
+        r, eq, score := keyRange(key, where)
+        if !ordered(key, eq, orderBy) || score <= bestScore {
+            continue
+        }
+        name := "primary key"
+        if i > 0 {
+            r.Index = def.Indexes[i - 1].Cols
+            name = fmt.Sprintf("index (%s)", strings.Join(r.Index, ", "))
+        }
+        best, bestScore = queryPlan{r: r}, score
+        switch {
+        case r.Start != nil || r.End != nil:
+            best.desc = name + " range"
+        case i == 0:
+            best.desc = "full scan"
+        default:
+            best.desc = name + " scan"
+        }
+    }
+    if bestScore < 0 {
+        return queryPlan{}, fmt.Errorf(
+            "%w: ORDER BY must follow the primary key or an index", ErrBadQuery,
+        )
+    }
+    return best, nil
+}
+
+// the range of the key from the conditions, the number of leading key
+// columns compared by `=`, and a score for the plan
+func keyRange(key []string, where []Cond) (table.Range, int, int) {
+    find := func(col string, ops ...string) *Cond {
+        for i := range where {
+            if where[i].Col == col && contains(ops, where[i].Op) {
+                return &where[i]
+            }
+        }
+        return nil
+    }
+    prefix := table.Record{}
+    eq := 0
+    for ; eq < len(key); eq++ {
+        cond := find(key[eq], "=")
+        if cond == nil {
+            break
+        }
+        prefix.Cols = append(prefix.Cols, cond.Col)
+        prefix.Vals = append(prefix.Vals, cond.Val)
+    }
+    r := table.Range{}
+    score := 2 * eq
+    if eq > 0 {
+        start, end := prefix, prefix
+        r.Start, r.End, r.EndIncl = &start, &end, true
+    }
+    if eq == len(key) {
+        return r, eq, score
+    }
+    bound := func(cond *Cond) *table.Record {
+        rec := table.Record{
+            Cols: append(append([]string(nil), prefix.Cols...), cond.Col),
+            Vals: append(append([]table.Value(nil), prefix.Vals...), cond.Val),
+        }
+        return &rec
+    }
+    if cond := find(key[eq], ">", ">="); cond != nil {
+        r.Start, r.StartExcl = bound(cond), cond.Op == ">"
+        score++
+    }
+    if cond := find(key[eq], "<", "<="); cond != nil {
+        r.End, r.EndIncl = bound(cond), cond.Op == "<="
+        score++
+    }
+    return r, eq, score
+}
+
+// whether the scan of `key` gives the rows in the order of `orderBy`, the
+// first `eq` key columns are fixed
+func ordered(key []string, eq int, orderBy []string) bool {
+    if len(orderBy) == 0 {
+        return true
+    }
+    for start := 0; start <= eq && start + len(orderBy) <= len(key); start++ {
+        same := true
+        for i, col := range orderBy {
+            same = same && key[start + i] == col
+        }
+        if same {
+            return true
+        }
+    }
+    return false
+}
@@ -0,0 +1,72 @@
+package query
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/connnorchen/MyDb/internal/table"
+)
+
+// a value as a literal would be written, except for strings
+func formatValue(val table.Value) string {
+    switch val.Type {
+    case table.TYPE_INT64:
+        return strconv.FormatInt(val.I64, 10)
+    case table.TYPE_BYTES:
+        return "x'" + hex.EncodeToString(val.Str) + "'"
+    }
+    return string(val.Str)
+}
+
+// the rows as a table for printing, or the number of affected rows
+//
+// +----+------+
+// | id | name |
+// +----+------+
+// | 1  | bob  |
+// +----+------+
+// (1 rows)
+func (res *Result) String() string {
+    if res.Cols == nil {
+        return fmt.Sprintf("OK, %d rows affected", res.Affected)
+    }
+    cells := [][]string{res.Cols}
+    for _, row := range res.Rows {
+        line := make([]string, len(row))
+        for i, val := range row {
+            line[i] = formatValue(val)
+        }
+        cells = append(cells, line)
+    }
+    widths := make([]int, len(res.Cols))
+    for _, line := range cells {
+        for i, cell := range line {
+            if len(cell) > widths[i] {
+                widths[i] = len(cell)
+            }
+        }
+    }
+
+    out := strings.Builder{}
+    sep := func() {
+        for _, w := range widths {
+            out.WriteString("+" + strings.Repeat("-", w + 2))
+        }
+        out.WriteString("+\n")
+    }
+    sep()
+    for i, line := range cells {
+        for j, cell := range line {
+            out.WriteString("| " + cell + strings.Repeat(" ", widths[j] - len(cell) + 1))
+        }
+        out.WriteString("|\n")
+        if i == 0 {
+            sep()
+        }
+    }
+    sep()
+    fmt.Fprintf(&out, "(%d rows)", len(res.Rows))
+    return out.String()
+}
@@ -0,0 +1,112 @@
+package query
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// tokens of the query language
+const (
+    TOK_EOF = iota
+    TOK_IDENT  // names and keywords, keywords are case-insensitive
+    TOK_INT    // 123, -5
+    TOK_STRING // 'it''s'
+    TOK_BYTES  // x'00ff'
+    TOK_PUNCT  // ( ) , ; * = != < <= > >=
+)
+
+type token struct {
+    kind int
+    text string // the name or the punctuation, the content of a string
+    num  int64
+    pos  int    // byte offset in the input, for errors
+}
+
+func isIdentStart(ch byte) bool {
+    return ch == '_' || (ch >= 'a' && ch <= 'z') || (ch >= 'A' && ch <= 'Z')
+}
+
+func isDigit(ch byte) bool {
+    return ch >= '0' && ch <= '9'
+}
+
+// split the input into tokens, the last one is TOK_EOF
+func lex(src string) ([]token, error) {
+    tokens := []token{}
+    for i := 0; i < len(src); {
+        ch := src[i]
+        start := i
+        switch {
+        case ch == ' ' || ch == '\t' || ch == '\n' || ch == '\r':
+            i++
+            continue
+        case (ch == 'x' || ch == 'X') && i + 1 < len(src) && src[i + 1] == '\'':
+            str, end, err := lexString(src, i + 1)
+            if err != nil {
+                return nil, err
+            }
+            data, err := hex.DecodeString(str)
+            if err != nil {
+                return nil, fmt.Errorf("%w: bad hex string at %d", ErrSyntax, start)
+            }
+            tokens = append(tokens, token{kind: TOK_BYTES, text: string(data), pos: start})
+            i = end
+        case isIdentStart(ch):
+            for i < len(src) && (isIdentStart(src[i]) || isDigit(src[i])) {
+                i++
+            }
+            tokens = append(tokens, token{kind: TOK_IDENT, text: src[start:i], pos: start})
+        case isDigit(ch) || (ch == '-' && i + 1 < len(src) && isDigit(src[i + 1])):
+            i++
+            for i < len(src) && isDigit(src[i]) {
+                i++
+            }
+            num, err := strconv.ParseInt(src[start:i], 10, 64)
+            if err != nil {
+                return nil, fmt.Errorf("%w: bad number at %d", ErrSyntax, start)
+            }
+            tokens = append(tokens, token{kind: TOK_INT, num: num, text: src[start:i], pos: start})
+        case ch == '\'':
+            str, end, err := lexString(src, i)
+            if err != nil {
+                return nil, err
+            }
+            tokens = append(tokens, token{kind: TOK_STRING, text: str, pos: start})
+            i = end
+        default:
+            op := ""
+            for _, p := range []string{"!=", "<=", ">=", "(", ")", ",", ";", "*", "=", "<", ">"} {
+                if strings.HasPrefix(src[i:], p) {
+                    op = p
+                    break
+                }
+            }
+            if op == "" {
+                return nil, fmt.Errorf("%w: unexpected %q at %d", ErrSyntax, ch, start)
+            }
+            tokens = append(tokens, token{kind: TOK_PUNCT, text: op, pos: start})
+            i += len(op)
+        }
+    }
+    return append(tokens, token{kind: TOK_EOF, pos: len(src)}), nil
+}
+
+// a quoted string starting at `src[i]`, a quote is escaped by doubling it.
+// returns the content and the position after the closing quote.
+func lexString(src string, i int) (string, int, error) {
+    start := i
+    out := []byte{}
+    for i++; i < len(src); i++ {
+        if src[i] != '\'' {
+            out = append(out, src[i])
+        } else if i + 1 < len(src) && src[i + 1] == '\'' {
+            out = append(out, '\'')
+            i++
+        } else {
+            return string(out), i + 1, nil
+        }
+    }
+    return "", 0, fmt.Errorf("%w: unterminated string at %d", ErrSyntax, start)
+}
@@ -0,0 +1,513 @@
+package query
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/connnorchen/MyDb/internal/table"
+)
+
+// a small SQL-like language over the table layer:
+//
+// CREATE TABLE t (a INT, b STRING, c BYTES, PRIMARY KEY (a, b), INDEX (c))
+// CREATE INDEX ON t (c, a)
+// DROP TABLE t
+// INSERT INTO t [(a, b, c)] VALUES (1, 'x', x'00ff'), ...
+// SELECT * | a, b FROM t [WHERE cond AND ...] [ORDER BY a, b [ASC | DESC]] [LIMIT n]
+// UPDATE t SET a = 1, ... [WHERE cond AND ...]
+// DELETE FROM t [WHERE cond AND ...]
+//
+// a condition compares a column with a literal: = != < <= > >=.
+// without PRIMARY KEY the first column is the primary key.
+// keywords are case-insensitive, names are not.
+
+var (
+    ErrSyntax   = errors.New("syntax error")
+    ErrBadQuery = errors.New("bad query")
+)
+
+var keywords = map[string]bool{
+    "CREATE": true, "TABLE": true, "INDEX": true, "ON": true, "DROP": true,
+    "PRIMARY": true, "KEY": true, "INSERT": true, "INTO": true, "VALUES": true,
+    "SELECT": true, "FROM": true, "WHERE": true, "AND": true, "ORDER": true,
+    "BY": true, "ASC": true, "DESC": true, "LIMIT": true, "UPDATE": true,
+    "SET": true, "DELETE": true,
+}
+
+var typeNames = map[string]uint32{
+    "INT": table.TYPE_INT64, "INT64": table.TYPE_INT64, "INTEGER": table.TYPE_INT64,
+    "BYTES": table.TYPE_BYTES, "BLOB": table.TYPE_BYTES,
+    "STRING": table.TYPE_STRING, "TEXT": table.TYPE_STRING, "VARCHAR": table.TYPE_STRING,
+}
+
+type Stmt interface {
+    stmt()
+}
+
+type CreateTable struct {
+    Def table.TableDef
+}
+
+type CreateIndex struct {
+    Table string
+    Cols  []string
+}
+
+type DropTable struct {
+    Table string
+}
+
+type Insert struct {
+    Table string
+    Cols  []string // nil for all the columns in the table order
+    Rows  [][]table.Value
+}
+
+// `col op val`, the type of a literal is TYPE_INT64, TYPE_STRING or
+// TYPE_BYTES
+type Cond struct {
+    Col string
+    Op  string
+    Val table.Value
+}
+
+type Select struct {
+    Table   string
+    Cols    []string // nil for *
+    Where   []Cond
+    OrderBy []string
+    Desc    bool
+    Limit   int64 // -1 for no limit
+}
+
+type Assign struct {
+    Col string
+    Val table.Value
+}
+
+type Update struct {
+    Table string
+    Set   []Assign
+    Where []Cond
+}
+
+type Delete struct {
+    Table string
+    Where []Cond
+}
+
+func (*CreateTable) stmt() {}
+func (*CreateIndex) stmt() {}
+func (*DropTable) stmt() {}
+func (*Insert) stmt() {}
+func (*Select) stmt() {}
+func (*Update) stmt() {}
+func (*Delete) stmt() {}
+
+type parser struct {
+    tokens []token
+    pos    int
+}
+
+// parse a statement, the trailing `;` is optional
+func Parse(src string) (Stmt, error) {
+    tokens, err := lex(src)
+    if err != nil {
+        return nil, err
+    }
+    p := &parser{tokens: tokens}
+    stmt, err := p.parseStmt()
+    if err != nil {
+        return nil, err
+    }
+    p.punct(";")
+    if tok := p.peek(); tok.kind != TOK_EOF {
+        return nil, p.errorf("unexpected %q", tok.text)
+    }
+    return stmt, nil
+}
+
+func (p *parser) peek() token {
+    return p.tokens[p.pos]
+}
+
+func (p *parser) errorf(format string, args ...interface{}) error {
+    return fmt.Errorf("%w at %d: %s", ErrSyntax, p.peek().pos, fmt.Sprintf(format, args...))
+}
+
+// consume the keyword if it's next
+func (p *parser) keyword(kw string) bool {
+    tok := p.peek()
+    if tok.kind == TOK_IDENT && strings.ToUpper(tok.text) == kw {
+        p.pos++
+        return true
+    }
+    return false
+}
+
+func (p *parser) expectKeyword(kw string) error {
+    if !p.keyword(kw) {
+        return p.errorf("expected %s", kw)
+    }
+    return nil
+}
+
+// consume the punctuation if it's next
+func (p *parser) punct(op string) bool {
+    tok := p.peek()
+    if tok.kind == TOK_PUNCT && tok.text == op {
+        p.pos++
+        return true
+    }
+    return false
+}
+
+func (p *parser) expectPunct(op string) error {
+    if !p.punct(op) {
+        return p.errorf("expected %q", op)
+    }
+    return nil
+}
+
+func (p *parser) name() (string, error) {
+    tok := p.peek()
+    if tok.kind != TOK_IDENT || keywords[strings.ToUpper(tok.text)] {
+        return "", p.errorf("expected a name")
+    }
+    p.pos++
+    return tok.text, nil
+}
+
+// (a, b, ...)
+func (p *parser) nameList() ([]string, error) {
+    if err := p.expectPunct("("); err != nil {
+        return nil, err
+    }
+    names := []string{}
+    for {
+        name, err := p.name()
+        if err != nil {
+            return nil, err
+        }
+        names = append(names, name)
+        if !p.punct(",") {
+            break
+        }
+    }
+    return names, p.expectPunct(")")
+}
+
+func (p *parser) literal() (table.Value, error) {
+    tok := p.peek()
+    switch tok.kind {
+    case TOK_INT:
+        p.pos++
+        return table.Value{Type: table.TYPE_INT64, I64: tok.num}, nil
+    case TOK_STRING:
+        p.pos++
+        return table.Value{Type: table.TYPE_STRING, Str: []byte(tok.text)}, nil
+    case TOK_BYTES:
+        p.pos++
+        return table.Value{Type: table.TYPE_BYTES, Str: []byte(tok.text)}, nil
+    }
+    return table.Value{}, p.errorf("expected a literal")
+}
+
+func (p *parser) parseStmt() (Stmt, error) {
+    switch {
+    case p.keyword("CREATE"):
+        if p.keyword("INDEX") {
+            return p.parseCreateIndex()
+        }
+        if err := p.expectKeyword("TABLE"); err != nil {
+            return nil, err
+        }
+        return p.parseCreateTable()
+    case p.keyword("DROP"):
+        if err := p.expectKeyword("TABLE"); err != nil {
+            return nil, err
+        }
+        name, err := p.name()
+        return &DropTable{Table: name}, err
+    case p.keyword("INSERT"):
+        return p.parseInsert()
+    case p.keyword("SELECT"):
+        return p.parseSelect()
+    case p.keyword("UPDATE"):
+        return p.parseUpdate()
+    case p.keyword("DELETE"):
+        if err := p.expectKeyword("FROM"); err != nil {
+            return nil, err
+        }
+        stmt := &Delete{}
+        var err error
+        if stmt.Table, err = p.name(); err != nil {
+            return nil, err
+        }
+        stmt.Where, err = p.parseWhere()
+        return stmt, err
+    }
+    return nil, p.errorf("expected a statement")
+}
+
+func (p *parser) parseCreateTable() (Stmt, error) {
+    name, err := p.name()
+    if err != nil {
+        return nil, err
+    }
+    if err = p.expectPunct("("); err != nil {
+        return nil, err
+    }
+    cols, types := []string{}, []uint32{}
+    var pkeys []string
+    indexes := []table.IndexDef{}
+    for {
+        switch {
+        case p.keyword("PRIMARY"):
+            if err = p.expectKeyword("KEY"); err != nil {
+                return nil, err
+            }
+            if pkeys != nil {
+                return nil, p.errorf("more than one primary key")
+            }
+            if pkeys, err = p.nameList(); err != nil {
+                return nil, err
+            }
+        case p.keyword("INDEX"):
+            index, err := p.nameList()
+            if err != nil {
+                return nil, err
+            }
+            indexes = append(indexes, table.IndexDef{Cols: index})
+        default:
+            col, err := p.name()
+            if err != nil {
+                return nil, err
+            }
+            tok := p.peek()
+            typ, ok := typeNames[strings.ToUpper(tok.text)]
+            if tok.kind != TOK_IDENT || !ok {
+                return nil, p.errorf("expected a column type")
+            }
+            p.pos++
+            cols, types = append(cols, col), append(types, typ)
+        }
+        if !p.punct(",") {
+            break
+        }
+    }
+    if err = p.expectPunct(")"); err != nil {
+        return nil, err
+    }
+    if len(cols) == 0 {
+        return nil, p.errorf("table without columns")
+    }
+    if pkeys == nil {
+        pkeys = cols[:1]
+    }
+
+    // the primary key columns go first
+    def := table.TableDef{Name: name, PKeys: len(pkeys), Indexes: indexes}
+    for _, pk := range pkeys {
+        found := false
+        for i, col := range cols {
+            if col == pk {
+                def.Cols, def.Types = append(def.Cols, col), append(def.Types, types[i])
+                found = true
+            }
+        }
+        if !found {
+            return nil, fmt.Errorf("%w: unknown primary key column %q", ErrBadQuery, pk)
+        }
+    }
+    for i, col := range cols {
+        if !contains(pkeys, col) {
+            def.Cols, def.Types = append(def.Cols, col), append(def.Types, types[i])
+        }
+    }
+    return &CreateTable{Def: def}, nil
+}
+
+func (p *parser) parseCreateIndex() (Stmt, error) {
+    if err := p.expectKeyword("ON"); err != nil {
+        return nil, err
+    }
+    name, err := p.name()
+    if err != nil {
+        return nil, err
+    }
+    cols, err := p.nameList()
+    return &CreateIndex{Table: name, Cols: cols}, err
+}
+
+func (p *parser) parseInsert() (Stmt, error) {
+    if err := p.expectKeyword("INTO"); err != nil {
+        return nil, err
+    }
+    stmt := &Insert{}
+    var err error
+    if stmt.Table, err = p.name(); err != nil {
+        return nil, err
+    }
+    if tok := p.peek(); tok.kind == TOK_PUNCT && tok.text == "(" {
+        if stmt.Cols, err = p.nameList(); err != nil {
+            return nil, err
+        }
+    }
+    if err = p.expectKeyword("VALUES"); err != nil {
+        return nil, err
+    }
+    for {
+        if err = p.expectPunct("("); err != nil {
+            return nil, err
+        }
+        row := []table.Value{}
+        for {
+            val, err := p.literal()
+            if err != nil {
+                return nil, err
+            }
+            row = append(row, val)
+            if !p.punct(",") {
+                break
+            }
+        }
+        if err = p.expectPunct(")"); err != nil {
+            return nil, err
+        }
+        stmt.Rows = append(stmt.Rows, row)
+        if !p.punct(",") {
+            break
+        }
+    }
+    return stmt, nil
+}
+
+func (p *parser) parseSelect() (Stmt, error) {
+    stmt := &Select{Limit: -1}
+    if !p.punct("*") {
+        for {
+            col, err := p.name()
+            if err != nil {
+                return nil, err
+            }
+            stmt.Cols = append(stmt.Cols, col)
+            if !p.punct(",") {
+                break
+            }
+        }
+    }
+    if err := p.expectKeyword("FROM"); err != nil {
+        return nil, err
+    }
+    var err error
+    if stmt.Table, err = p.name(); err != nil {
+        return nil, err
+    }
+    if stmt.Where, err = p.parseWhere(); err != nil {
+        return nil, err
+    }
+    if p.keyword("ORDER") {
+        if err = p.expectKeyword("BY"); err != nil {
+            return nil, err
+        }
+        for {
+            col, err := p.name()
+            if err != nil {
+                return nil, err
+            }
+            stmt.OrderBy = append(stmt.OrderBy, col)
+            if !p.punct(",") {
+                break
+            }
+        }
+        if p.keyword("DESC") {
+            stmt.Desc = true
+        } else {
+            p.keyword("ASC")
+        }
+    }
+    if p.keyword("LIMIT") {
+        tok := p.peek()
+        if tok.kind != TOK_INT || tok.num < 0 {
+            return nil, p.errorf("expected a limit")
+        }
+        p.pos++
+        stmt.Limit = tok.num
+    }
+    return stmt, nil
+}
+
+func (p *parser) parseUpdate() (Stmt, error) {
+    stmt := &Update{}
+    var err error
+    if stmt.Table, err = p.name(); err != nil {
+        return nil, err
+    }
+    if err = p.expectKeyword("SET"); err != nil {
+        return nil, err
+    }
+    for {
+        col, err := p.name()
+        if err != nil {
+            return nil, err
+        }
+        if err = p.expectPunct("="); err != nil {
+            return nil, err
+        }
+        val, err := p.literal()
+        if err != nil {
+            return nil, err
+        }
+        stmt.Set = append(stmt.Set, Assign{Col: col, Val: val})
+        if !p.punct(",") {
+            break
+        }
+    }
+    stmt.Where, err = p.parseWhere()
+    return stmt, err
+}
+
+// nil without WHERE
+func (p *parser) parseWhere() ([]Cond, error) {
+    if !p.keyword("WHERE") {
+        return nil, nil
+    }
+    conds := []Cond{}
+    for {
+        col, err := p.name()
+        if err != nil {
+            return nil, err
+        }
+        tok := p.peek()
+        switch tok.text {
+        case "=", "!=", "<", "<=", ">", ">=":
+            if tok.kind == TOK_PUNCT {
+                break
+            }
+            fallthrough
+        default:
+            return nil, p.errorf("expected a comparison")
+        }
+        p.pos++
+        val, err := p.literal()
+        if err != nil {
+            return nil, err
+        }
+        conds = append(conds, Cond{Col: col, Op: tok.text, Val: val})
+        if !p.keyword("AND") {
+            break
+        }
+    }
+    return conds, nil
+}
+
+func contains(list []string, s string) bool {
+    for _, x := range list {
+        if x == s {
+            return true
+        }
+    }
+    return false
+}
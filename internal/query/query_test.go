@@ -0,0 +1,209 @@
+package query
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/connnorchen/MyDb/internal/kvstore"
+	"github.com/connnorchen/MyDb/internal/table"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestDB(t *testing.T) *table.DB {
+    kv := &kvstore.KV{Path: filepath.Join(t.TempDir(), "db")}
+    assert.Nil(t, kv.Open())
+    t.Cleanup(kv.Close)
+    return &table.DB{KV: kv}
+}
+
+func run(t *testing.T, db *table.DB, src string) *Result {
+    res, err := Run(db, src)
+    assert.Nil(t, err, src)
+    return res
+}
+
+// the rows of a SELECT as formatted values
+func rows(t *testing.T, db *table.DB, src string) [][]string {
+    out := [][]string{}
+    for _, row := range run(t, db, src).Rows {
+        line := []string{}
+        for _, val := range row {
+            line = append(line, formatValue(val))
+        }
+        out = append(out, line)
+    }
+    return out
+}
+
+func TestParse(t *testing.T) {
+    stmt, err := Parse(
+        "create table t (name STRING, id int, data bytes, primary key (id), index (name, id));",
+    )
+    assert.Nil(t, err)
+    assert.Equal(t, stmt, &CreateTable{Def: table.TableDef{
+        Name:    "t",
+        Cols:    []string{"id", "name", "data"},
+        Types:   []uint32{table.TYPE_INT64, table.TYPE_STRING, table.TYPE_BYTES},
+        PKeys:   1,
+        Indexes: []table.IndexDef{{Cols: []string{"name", "id"}}},
+    }})
+
+    stmt, err = Parse("SELECT a, b FROM t WHERE a >= -3 AND b = 'it''s' ORDER BY a DESC LIMIT 2")
+    assert.Nil(t, err)
+    assert.Equal(t, stmt, &Select{
+        Table: "t",
+        Cols:  []string{"a", "b"},
+        Where: []Cond{
+            {Col: "a", Op: ">=", Val: table.Value{Type: table.TYPE_INT64, I64: -3}},
+            {Col: "b", Op: "=", Val: table.Value{Type: table.TYPE_STRING, Str: []byte("it's")}},
+        },
+        OrderBy: []string{"a"},
+        Desc:    true,
+        Limit:   2,
+    })
+
+    stmt, err = Parse("insert into t values (1, x'00ff'), (2, '')")
+    assert.Nil(t, err)
+    assert.Equal(t, stmt, &Insert{Table: "t", Rows: [][]table.Value{
+        {{Type: table.TYPE_INT64, I64: 1}, {Type: table.TYPE_BYTES, Str: []byte{0, 0xff}}},
+        {{Type: table.TYPE_INT64, I64: 2}, {Type: table.TYPE_STRING, Str: []byte{}}},
+    }})
+
+    for _, bad := range []string{
+        "", "select", "select * from", "select * from t where a", "select * from t limit x",
+        "select * from select", "insert into t values (1", "select 'abc", "select x'0'",
+        "create table t (a float)", "delete t", "select * from t;;", "select * from t # 1",
+    } {
+        _, err := Parse(bad)
+        assert.ErrorIs(t, err, ErrSyntax, bad)
+    }
+    _, err = Parse("create table t (a int, primary key (b))")
+    assert.ErrorIs(t, err, ErrBadQuery)
+}
+
+func TestExec(t *testing.T) {
+    db := newTestDB(t)
+    run(t, db, "CREATE TABLE people (id INT, city STRING, age INT, INDEX (city, age))")
+    res := run(t, db, `INSERT INTO people VALUES
+        (1, 'oslo', 30), (2, 'rome', 20), (3, 'oslo', 25), (4, 'paris', 41), (5, 'rome', 35)`)
+    assert.Equal(t, res.Affected, 5)
+
+    assert.Equal(t, rows(t, db, "SELECT * FROM people WHERE id >= 4"), [][]string{
+        {"4", "paris", "41"}, {"5", "rome", "35"},
+    })
+    assert.Equal(t, rows(t, db, "SELECT id FROM people WHERE city = 'oslo' ORDER BY age DESC"), [][]string{
+        {"1"}, {"3"},
+    })
+    assert.Equal(t, rows(t, db, "SELECT id, age FROM people WHERE age > 25 AND age != 41 LIMIT 1"), [][]string{
+        {"1", "30"},
+    })
+    assert.Equal(t, rows(t, db, "SELECT city FROM people ORDER BY city, age LIMIT 3"), [][]string{
+        {"oslo"}, {"oslo"}, {"paris"},
+    })
+
+    // updates follow the indexes, a new primary key moves the row
+    res = run(t, db, "UPDATE people SET city = 'rome' WHERE city = 'oslo' AND age < 30")
+    assert.Equal(t, res.Affected, 1)
+    res = run(t, db, "UPDATE people SET id = 10, age = 50 WHERE id = 1")
+    assert.Equal(t, res.Affected, 1)
+    assert.Equal(t, rows(t, db, "SELECT id, age FROM people WHERE city = 'rome'"), [][]string{
+        {"2", "20"}, {"3", "25"}, {"5", "35"},
+    })
+    assert.Equal(t, rows(t, db, "SELECT * FROM people WHERE city = 'oslo'"), [][]string{
+        {"10", "oslo", "50"},
+    })
+    res = run(t, db, "DELETE FROM people WHERE city = 'rome' AND age >= 25")
+    assert.Equal(t, res.Affected, 2)
+    assert.Equal(t, rows(t, db, "SELECT id FROM people"), [][]string{{"2"}, {"4"}, {"10"}})
+
+    // errors leave the table alone
+    _, err := Run(db, "INSERT INTO people VALUES (11, 'oslo', 1), (2, 'oslo', 1)")
+    assert.ErrorIs(t, err, table.ErrRowExists)
+    _, err = Run(db, "SELECT * FROM people WHERE age = 'old'")
+    assert.ErrorIs(t, err, ErrBadQuery)
+    _, err = Run(db, "SELECT height FROM people")
+    assert.ErrorIs(t, err, ErrBadQuery)
+    _, err = Run(db, "SELECT * FROM people ORDER BY age")
+    assert.ErrorIs(t, err, ErrBadQuery)
+    _, err = Run(db, "SELECT * FROM nobody")
+    assert.ErrorIs(t, err, table.ErrTableNotFound)
+    assert.Equal(t, rows(t, db, "SELECT id FROM people"), [][]string{{"2"}, {"4"}, {"10"}})
+
+    run(t, db, "DROP TABLE people")
+    _, err = Run(db, "SELECT * FROM people")
+    assert.ErrorIs(t, err, table.ErrTableNotFound)
+}
+
+func TestPlan(t *testing.T) {
+    db := newTestDB(t)
+    run(t, db, "CREATE TABLE t (a INT, b INT, c STRING, d INT, PRIMARY KEY (a, b), INDEX (c, d))")
+    run(t, db, "CREATE INDEX ON t (d)")
+    for _, c := range []struct {
+        query string
+        plan  string
+    }{
+        {"SELECT * FROM t", "full scan"},
+        {"SELECT * FROM t WHERE a = 1", "primary key range"},
+        {"SELECT * FROM t WHERE b = 1", "full scan"},
+        {"SELECT * FROM t WHERE a > 1 AND a <= 5", "primary key range"},
+        {"SELECT * FROM t WHERE c = 'x'", "index (c, d) range"},
+        {"SELECT * FROM t WHERE c = 'x' AND d > 1 AND a = 1", "index (c, d) range"},
+        {"SELECT * FROM t WHERE d = 1 AND a > 1", "index (d) range"},
+        {"SELECT * FROM t WHERE a = 1 AND d = 1", "index (d) range"}, // (d, a, b)
+        {"SELECT * FROM t WHERE a = 1 AND c > 'x'", "primary key range"},
+        {"SELECT * FROM t ORDER BY d DESC", "index (d) scan"},
+        {"SELECT * FROM t WHERE c = 'x' ORDER BY d", "index (c, d) range"},
+        {"SELECT * FROM t WHERE a = 1 ORDER BY b", "primary key range"},
+        {"DELETE FROM t WHERE d < 3", "index (d) range"},
+    } {
+        assert.Equal(t, run(t, db, c.query).Plan, c.plan, c.query)
+    }
+}
+
+func TestRanges(t *testing.T) {
+    db := newTestDB(t)
+    run(t, db, "CREATE TABLE t (a INT, b INT, c INT, PRIMARY KEY (a, b), INDEX (c))")
+    for a := 0; a < 4; a++ {
+        for b := 0; b < 4; b++ {
+            _, err := Exec(db, &Insert{Table: "t", Rows: [][]table.Value{{
+                {Type: table.TYPE_INT64, I64: int64(a)},
+                {Type: table.TYPE_INT64, I64: int64(b)},
+                {Type: table.TYPE_INT64, I64: int64(a * 4 + b)},
+            }}})
+            assert.Nil(t, err)
+        }
+    }
+    count := func(where string) int {
+        return len(run(t, db, "SELECT * FROM t WHERE " + where).Rows)
+    }
+    assert.Equal(t, count("a = 1"), 4)
+    assert.Equal(t, count("a = 1 AND b > 1"), 2)
+    assert.Equal(t, count("a = 1 AND b >= 1 AND b < 3"), 2)
+    assert.Equal(t, count("a > 1"), 8)
+    assert.Equal(t, count("a <= 1"), 8)
+    assert.Equal(t, count("a < 1"), 4)
+    assert.Equal(t, count("c > 5 AND c <= 9"), 4)
+    assert.Equal(t, count("c = 16"), 0)
+    assert.Equal(t, rows(t, db, "SELECT c FROM t WHERE a = 2 ORDER BY b DESC LIMIT 2"), [][]string{
+        {"11"}, {"10"},
+    })
+}
+
+func TestFormat(t *testing.T) {
+    res := &Result{
+        Cols: []string{"id", "name"},
+        Rows: [][]table.Value{
+            {{Type: table.TYPE_INT64, I64: 12}, {Type: table.TYPE_STRING, Str: []byte("bob")}},
+            {{Type: table.TYPE_INT64, I64: 3}, {Type: table.TYPE_BYTES, Str: []byte{1}}},
+        },
+    }
+    assert.Equal(t, res.String(), "" +
+        "+----+-------+\n" +
+        "| id | name  |\n" +
+        "+----+-------+\n" +
+        "| 12 | bob   |\n" +
+        "| 3  | x'01' |\n" +
+        "+----+-------+\n" +
+        "(2 rows)")
+    assert.Equal(t, (&Result{Affected: 3}).String(), "OK, 3 rows affected")
+}
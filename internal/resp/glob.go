@@ -0,0 +1,82 @@
+package resp
+
+// glob-style pattern matching of the SCAN MATCH option, as in Redis:
+// *      any sequence of bytes
+// ?      any single byte
+// [abc]  one of the bytes, [^abc] none of them, [a-z] a range
+// \x     the byte x literally
+func globMatch(pattern []byte, str []byte) bool {
+    for len(pattern) > 0 {
+        switch pattern[0] {
+        case '*':
+            for len(pattern) > 1 && pattern[1] == '*' {
+                pattern = pattern[1:]
+            }
+            if len(pattern) == 1 {
+                return true
+            }
+            // try every suffix
+            for i := 0; i <= len(str); i++ {
+                if globMatch(pattern[1:], str[i:]) {
+                    return true
+                }
+            }
+            return false
+        case '?':
+            if len(str) == 0 {
+                return false
+            }
+            pattern, str = pattern[1:], str[1:]
+        case '[':
+            if len(str) == 0 {
+                return false
+            }
+            var ok bool
+            ok, pattern = globClass(pattern[1:], str[0])
+            if !ok {
+                return false
+            }
+            str = str[1:]
+        default:
+            if pattern[0] == '\\' && len(pattern) > 1 {
+                pattern = pattern[1:]
+            }
+            if len(str) == 0 || pattern[0] != str[0] {
+                return false
+            }
+            pattern, str = pattern[1:], str[1:]
+        }
+    }
+    return len(str) == 0
+}
+
+// match a byte against a [...] class, `pattern` starts after the '['.
+// returns the rest of the pattern after the ']'.
+func globClass(pattern []byte, c byte) (bool, []byte) {
+    not := len(pattern) > 0 && pattern[0] == '^'
+    if not {
+        pattern = pattern[1:]
+    }
+    match := false
+    for len(pattern) > 0 && pattern[0] != ']' {
+        switch {
+        case pattern[0] == '\\' && len(pattern) > 1:
+            match = match || pattern[1] == c
+            pattern = pattern[2:]
+        case len(pattern) > 2 && pattern[1] == '-' && pattern[2] != ']':
+            lo, hi := pattern[0], pattern[2]
+            if lo > hi {
+                lo, hi = hi, lo
+            }
+            match = match || (lo <= c && c <= hi)
+            pattern = pattern[3:]
+        default:
+            match = match || pattern[0] == c
+            pattern = pattern[1:]
+        }
+    }
+    if len(pattern) > 0 {
+        pattern = pattern[1:] // skip the ']'
+    }
+    return match != not, pattern
+}
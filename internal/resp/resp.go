@@ -0,0 +1,203 @@
+package resp
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/connnorchen/MyDb/internal/b_tree"
+)
+
+// the Redis serialization protocol (RESP2).
+// requests are arrays of bulk strings:
+// *<n>\r\n $<len>\r\n <bytes>\r\n ...
+// or inline commands, a line of space separated words (e.g. from telnet).
+const (
+    MAX_ARGS = 1 << 20
+    // large enough for the largest value plus some slack for the command
+    MAX_BULK_LEN = b_tree.BTREE_MAX_LARGE_VALUE_SIZE + 1024
+    MAX_INLINE_LEN = 64 << 10
+    // memory is given to a request as its data arrives, not as announced
+    READ_CHUNK = 64 << 10
+)
+
+var ErrProtocol = errors.New("Protocol error")
+
+func protocolError(format string, args ...interface{}) error {
+    return fmt.Errorf("%w: %s", ErrProtocol, fmt.Sprintf(format, args...))
+}
+
+type Reader struct {
+    rd *bufio.Reader
+}
+
+func NewReader(rd io.Reader) *Reader {
+    return &Reader{rd: bufio.NewReader(rd)}
+}
+
+// whether a request is already buffered, used to batch the replies of
+// pipelined requests.
+func (r *Reader) Buffered() bool {
+    return r.rd.Buffered() > 0
+}
+
+// read a line without the trailing \r\n
+func (r *Reader) readLine(limit int) ([]byte, error) {
+    var line []byte
+    for {
+        chunk, isPrefix, err := r.rd.ReadLine()
+        if err != nil {
+            return nil, err
+        }
+        line = append(line, chunk...)
+        if len(line) > limit {
+            return nil, protocolError("too big request line")
+        }
+        if !isPrefix {
+            return line, nil
+        }
+    }
+}
+
+// read a line of the form <prefix><integer>
+func (r *Reader) readLength(prefix byte, max int) (int, error) {
+    line, err := r.readLine(64)
+    if err != nil {
+        return 0, err
+    }
+    if len(line) == 0 || line[0] != prefix {
+        return 0, protocolError("expected '%c'", prefix)
+    }
+    n, err := strconv.Atoi(string(line[1:]))
+    if err != nil || n < 0 || n > max {
+        return 0, protocolError("invalid length")
+    }
+    return n, nil
+}
+
+// read one request, the command and its arguments.
+// io.EOF is returned if the connection is closed between requests.
+func (r *Reader) ReadCommand() ([][]byte, error) {
+    for {
+        c, err := r.rd.Peek(1)
+        if err != nil {
+            return nil, err
+        }
+        if c[0] != '*' {
+            args, err := r.readInline()
+            if err != nil || len(args) > 0 {
+                return args, err
+            }
+            continue // skip empty lines
+        }
+
+        n, err := r.readLength('*', MAX_ARGS)
+        if err != nil {
+            return nil, unexpectedEOF(err)
+        }
+        if n == 0 {
+            continue
+        }
+        args := make([][]byte, 0, minInt(n, READ_CHUNK / 8))
+        for i := 0; i < n; i++ {
+            arg, err := r.readBulk()
+            if err != nil {
+                return nil, unexpectedEOF(err)
+            }
+            args = append(args, arg)
+        }
+        return args, nil
+    }
+}
+
+func (r *Reader) readBulk() ([]byte, error) {
+    n, err := r.readLength('$', MAX_BULK_LEN)
+    if err != nil {
+        return nil, err
+    }
+    // a bad client can announce MAX_BULK_LEN and send nothing
+    data := make([]byte, 0, minInt(n + 2, READ_CHUNK))
+    for len(data) < n + 2 {
+        start := len(data)
+        data = append(data, make([]byte, minInt(n + 2 - start, READ_CHUNK))...)
+        if _, err := io.ReadFull(r.rd, data[start:]); err != nil {
+            return nil, err
+        }
+    }
+    if data[n] != '\r' || data[n + 1] != '\n' {
+        return nil, protocolError("expected CRLF after bulk string")
+    }
+    return data[:n], nil
+}
+
+func minInt(a int, b int) int {
+    if a < b {
+        return a
+    }
+    return b
+}
+
+func (r *Reader) readInline() ([][]byte, error) {
+    line, err := r.readLine(MAX_INLINE_LEN)
+    if err != nil {
+        return nil, err
+    }
+    args := [][]byte{}
+    for _, word := range strings.Fields(string(line)) {
+        args = append(args, []byte(word))
+    }
+    return args, nil
+}
+
+// EOF in the middle of a request
+func unexpectedEOF(err error) error {
+    if err == io.EOF {
+        return io.ErrUnexpectedEOF
+    }
+    return err
+}
+
+// buffered writer of replies, the caller flushes it.
+type Writer struct {
+    *bufio.Writer
+}
+
+func NewWriter(wr io.Writer) *Writer {
+    return &Writer{bufio.NewWriter(wr)}
+}
+
+// +<status>\r\n
+func (w *Writer) WriteStatus(s string) {
+    w.WriteString("+" + s + "\r\n")
+}
+
+// -<message>\r\n, the message must not contain newlines
+func (w *Writer) WriteError(msg string) {
+    msg = strings.NewReplacer("\r", " ", "\n", " ").Replace(msg)
+    w.WriteString("-" + msg + "\r\n")
+}
+
+// :<n>\r\n
+func (w *Writer) WriteInt(n int64) {
+    w.WriteString(":" + strconv.FormatInt(n, 10) + "\r\n")
+}
+
+// $<len>\r\n<data>\r\n
+func (w *Writer) WriteBulk(data []byte) {
+    w.WriteString("$" + strconv.Itoa(len(data)) + "\r\n")
+    w.Write(data)
+    w.WriteString("\r\n")
+}
+
+// $-1\r\n
+func (w *Writer) WriteNull() {
+    w.WriteString("$-1\r\n")
+}
+
+// *<n>\r\n followed by n elements written by the caller
+func (w *Writer) WriteArrayHeader(n int) {
+    w.WriteString("*" + strconv.Itoa(n) + "\r\n")
+}
@@ -0,0 +1,114 @@
+package resp
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadCommand(t *testing.T) {
+    input := "*3\r\n$3\r\nSET\r\n$1\r\nk\r\n$5\r\na\r\nb!\r\n" +
+        "\r\n" + // empty lines are skipped
+        "PING  hello\r\n" +
+        "*1\r\n$0\r\n\r\n"
+    rd := NewReader(strings.NewReader(input))
+
+    args, err := rd.ReadCommand()
+    assert.Nil(t, err)
+    assert.Equal(t, args, [][]byte{[]byte("SET"), []byte("k"), []byte("a\r\nb!")})
+    assert.True(t, rd.Buffered())
+
+    args, err = rd.ReadCommand()
+    assert.Nil(t, err)
+    assert.Equal(t, args, [][]byte{[]byte("PING"), []byte("hello")})
+
+    args, err = rd.ReadCommand()
+    assert.Nil(t, err)
+    assert.Equal(t, args, [][]byte{{}})
+
+    _, err = rd.ReadCommand()
+    assert.Equal(t, err, io.EOF)
+}
+
+func TestReadCommandErrors(t *testing.T) {
+    for _, input := range []string{
+        "*x\r\n",
+        "*1\r\n:1\r\n",
+        "*1\r\n$-1\r\n",
+        "*1\r\n$3\r\nabcd\r\n",
+        "*1\r\n$999999999999\r\n",
+    } {
+        _, err := NewReader(strings.NewReader(input)).ReadCommand()
+        assert.ErrorIs(t, err, ErrProtocol, input)
+    }
+    _, err := NewReader(strings.NewReader("*2\r\n$1\r\na\r\n")).ReadCommand()
+    assert.True(t, errors.Is(err, io.ErrUnexpectedEOF))
+}
+
+func TestReadLargeBulk(t *testing.T) {
+    val := bytes.Repeat([]byte("0123456789"), READ_CHUNK / 3)
+    input := fmt.Sprintf("*1\r\n$%d\r\n%s\r\n", len(val), val)
+    args, err := NewReader(strings.NewReader(input)).ReadCommand()
+    assert.Nil(t, err)
+    assert.Equal(t, args, [][]byte{val})
+
+    // the announced lengths are not allocated up front
+    input = fmt.Sprintf("*%d\r\n$%d\r\nabc", MAX_ARGS, MAX_BULK_LEN)
+    var before, after runtime.MemStats
+    runtime.ReadMemStats(&before)
+    _, err = NewReader(strings.NewReader(input)).ReadCommand()
+    runtime.ReadMemStats(&after)
+    assert.True(t, errors.Is(err, io.ErrUnexpectedEOF))
+    assert.Less(t, after.TotalAlloc - before.TotalAlloc, uint64(1 << 20))
+}
+
+func TestWriter(t *testing.T) {
+    buf := &bytes.Buffer{}
+    wr := NewWriter(buf)
+    wr.WriteStatus("OK")
+    wr.WriteError("ERR bad\r\nthing")
+    wr.WriteInt(-3)
+    wr.WriteArrayHeader(2)
+    wr.WriteBulk([]byte("v"))
+    wr.WriteNull()
+    wr.Flush()
+    assert.Equal(
+        t, buf.String(), "+OK\r\n-ERR bad  thing\r\n:-3\r\n*2\r\n$1\r\nv\r\n$-1\r\n",
+    )
+}
+
+func TestGlobMatch(t *testing.T) {
+    cases := []struct {
+        pattern string
+        str     string
+        match   bool
+    }{
+        {"*", "", true},
+        {"*", "abc", true},
+        {"a*", "abc", true},
+        {"a*", "bac", false},
+        {"*c", "abc", true},
+        {"a*b*c", "axxbyyc", true},
+        {"a*b*c", "axxbyy", false},
+        {"h?llo", "hello", true},
+        {"h?llo", "hllo", false},
+        {"h[ae]llo", "hallo", true},
+        {"h[ae]llo", "hillo", false},
+        {"h[^e]llo", "hallo", true},
+        {"h[^e]llo", "hello", false},
+        {"h[a-b]llo", "hbllo", true},
+        {"h[a-b]llo", "hcllo", false},
+        {"h\\*llo", "h*llo", true},
+        {"h\\*llo", "hello", false},
+        {"user:[0-9]*", "user:42", true},
+    }
+    for _, c := range cases {
+        assert.Equal(t, globMatch([]byte(c.pattern), []byte(c.str)), c.match, c.pattern + " " + c.str)
+    }
+}
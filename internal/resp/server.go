@@ -0,0 +1,403 @@
+package resp
+
+import (
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/connnorchen/MyDb/internal/kvstore"
+)
+
+// a Redis compatible server on top of KV.
+// each connection is served by its own goroutine, writes are serialized by
+// the KV writer lock and reads use snapshots, so they never block each other.
+type Server struct {
+    DB *kvstore.KV
+
+    mu       sync.Mutex
+    listener net.Listener
+    conns    map[net.Conn]struct{}
+    closed   bool
+    wg       sync.WaitGroup
+    // SCAN cursors handed out to all clients, see cmdScan
+    cursors    map[uint64][]byte
+    nextCursor uint64
+}
+
+var ErrServerClosed = errors.New("resp: server closed")
+
+// accept connections until Close. it returns nil after Close.
+func (srv *Server) Serve(l net.Listener) error {
+    srv.mu.Lock()
+    if srv.closed {
+        srv.mu.Unlock()
+        l.Close()
+        return ErrServerClosed
+    }
+    srv.listener = l
+    if srv.conns == nil {
+        srv.conns = map[net.Conn]struct{}{}
+    }
+    srv.mu.Unlock()
+
+    for {
+        conn, err := l.Accept()
+        if err != nil {
+            srv.mu.Lock()
+            closed := srv.closed
+            srv.mu.Unlock()
+            if closed {
+                return nil
+            }
+            return err
+        }
+
+        srv.mu.Lock()
+        if srv.closed {
+            srv.mu.Unlock()
+            conn.Close()
+            return nil
+        }
+        srv.conns[conn] = struct{}{}
+        srv.wg.Add(1)
+        srv.mu.Unlock()
+
+        go srv.serveConn(conn)
+    }
+}
+
+// stop accepting, close all connections and wait for their goroutines.
+// the DB is left open.
+func (srv *Server) Close() error {
+    srv.mu.Lock()
+    srv.closed = true
+    var err error
+    if srv.listener != nil {
+        err = srv.listener.Close()
+    }
+    for conn := range srv.conns {
+        conn.Close()
+    }
+    srv.mu.Unlock()
+    srv.wg.Wait()
+    return err
+}
+
+// the state of a connection
+type client struct {
+    srv *Server
+    rd  *Reader
+    wr  *Writer
+}
+
+func (srv *Server) serveConn(conn net.Conn) {
+    defer func() {
+        conn.Close()
+        srv.mu.Lock()
+        delete(srv.conns, conn)
+        srv.mu.Unlock()
+        srv.wg.Done()
+    }()
+
+    c := &client{srv: srv, rd: NewReader(conn), wr: NewWriter(conn)}
+    for {
+        args, err := c.rd.ReadCommand()
+        if err != nil {
+            if errors.Is(err, ErrProtocol) {
+                c.wr.WriteError("ERR " + err.Error())
+                c.wr.Flush()
+            }
+            return // io.EOF or a broken connection
+        }
+        quit := c.dispatch(args)
+        // replies to pipelined requests are sent together
+        if quit || !c.rd.Buffered() {
+            if err := c.wr.Flush(); err != nil {
+                return
+            }
+        }
+        if quit {
+            return
+        }
+    }
+}
+
+type command struct {
+    // the number of arguments including the command name,
+    // negative for a minimum like in the Redis command table.
+    arity   int
+    handler func(c *client, args [][]byte)
+}
+
+var commands map[string]command
+
+func init() {
+    commands = map[string]command{
+        "ping":   {-1, cmdPing},
+        "quit":   {1, cmdQuit},
+        "get":    {2, cmdGet},
+        "set":    {3, cmdSet},
+        "del":    {-2, cmdDel},
+        "exists": {-2, cmdExists},
+        "mget":   {-2, cmdMGet},
+        "mset":   {-3, cmdMSet},
+        "scan":   {-2, cmdScan},
+    }
+}
+
+// execute a command, returns true if the connection should be closed
+func (c *client) dispatch(args [][]byte) bool {
+    name := strings.ToLower(string(args[0]))
+    cmd, ok := commands[name]
+    if !ok {
+        c.wr.WriteError("ERR unknown command '" + string(args[0]) + "'")
+        return false
+    }
+    if (cmd.arity > 0 && len(args) != cmd.arity) ||
+        (cmd.arity < 0 && len(args) < -cmd.arity) {
+        c.wr.WriteError("ERR wrong number of arguments for '" + name + "' command")
+        return false
+    }
+    cmd.handler(c, args)
+    return name == "quit"
+}
+
+func (c *client) writeErr(err error) {
+    c.wr.WriteError("ERR " + err.Error())
+}
+
+// PING [message]
+func cmdPing(c *client, args [][]byte) {
+    switch len(args) {
+    case 1:
+        c.wr.WriteStatus("PONG")
+    case 2:
+        c.wr.WriteBulk(args[1])
+    default:
+        c.wr.WriteError("ERR wrong number of arguments for 'ping' command")
+    }
+}
+
+// QUIT, the connection is closed after the reply
+func cmdQuit(c *client, args [][]byte) {
+    c.wr.WriteStatus("OK")
+}
+
+// GET key
+func cmdGet(c *client, args [][]byte) {
+    val, found, err := c.srv.DB.Get(args[1])
+    switch {
+    case err != nil:
+        c.writeErr(err)
+    case !found:
+        c.wr.WriteNull()
+    default:
+        c.wr.WriteBulk(val)
+    }
+}
+
+// SET key value, no options
+func cmdSet(c *client, args [][]byte) {
+    if err := c.srv.DB.Set(args[1], args[2]); err != nil {
+        c.writeErr(err)
+        return
+    }
+    c.wr.WriteStatus("OK")
+}
+
+// DEL key [key ...], the number of keys removed.
+// all keys are deleted in one transaction.
+func cmdDel(c *client, args [][]byte) {
+    tx := c.srv.DB.Begin()
+    count := int64(0)
+    for _, key := range args[1:] {
+        deleted, err := tx.Del(key)
+        if err != nil {
+            tx.Abort()
+            c.writeErr(err)
+            return
+        }
+        if deleted {
+            count++
+        }
+    }
+    if err := tx.Commit(); err != nil {
+        c.writeErr(err)
+        return
+    }
+    c.wr.WriteInt(count)
+}
+
+// EXISTS key [key ...], the number of existing keys.
+// a key given multiple times is counted multiple times.
+func cmdExists(c *client, args [][]byte) {
+    reader := c.srv.DB.BeginRead()
+    defer reader.Close()
+    count := int64(0)
+    for _, key := range args[1:] {
+        _, found, err := reader.Get(key)
+        if err != nil {
+            c.writeErr(err)
+            return
+        }
+        if found {
+            count++
+        }
+    }
+    c.wr.WriteInt(count)
+}
+
+// MGET key [key ...], the values from one snapshot
+func cmdMGet(c *client, args [][]byte) {
+    vals := make([][]byte, len(args) - 1)
+    reader := c.srv.DB.BeginRead()
+    for i, key := range args[1:] {
+        val, found, err := reader.Get(key)
+        if err != nil {
+            reader.Close()
+            c.writeErr(err)
+            return
+        }
+        if found {
+            // copied, the snapshot is closed before writing the reply
+            vals[i] = append([]byte{}, val...)
+        }
+    }
+    reader.Close()
+
+    c.wr.WriteArrayHeader(len(vals))
+    for _, val := range vals {
+        if val == nil {
+            c.wr.WriteNull()
+        } else {
+            c.wr.WriteBulk(val)
+        }
+    }
+}
+
+// MSET key value [key value ...], all pairs are set in one transaction
+func cmdMSet(c *client, args [][]byte) {
+    if len(args) % 2 != 1 {
+        c.wr.WriteError("ERR wrong number of arguments for 'mset' command")
+        return
+    }
+    tx := c.srv.DB.Begin()
+    for i := 1; i < len(args); i += 2 {
+        if err := tx.Set(args[i], args[i + 1]); err != nil {
+            tx.Abort()
+            c.writeErr(err)
+            return
+        }
+    }
+    if err := tx.Commit(); err != nil {
+        c.writeErr(err)
+        return
+    }
+    c.wr.WriteStatus("OK")
+}
+
+const (
+    SCAN_DEFAULT_COUNT = 10
+    SCAN_MAX_CURSORS = 4096 // for the whole server
+)
+
+// SCAN cursor [MATCH pattern] [COUNT count]
+// clients expect numeric cursors, but our keys are ordered, so the cursor
+// is a server-wide handle to the next key instead of a hash table position.
+// a cursor can be used again and from any connection, until it's among the
+// oldest ones dropped past SCAN_MAX_CURSORS.
+// like in Redis, keys that exist during the whole scan are returned exactly
+// once, COUNT is the number of keys examined and may return fewer matches.
+func cmdScan(c *client, args [][]byte) {
+    cursor, err := strconv.ParseUint(string(args[1]), 10, 64)
+    if err != nil {
+        c.wr.WriteError("ERR invalid cursor")
+        return
+    }
+    var pattern []byte
+    count := SCAN_DEFAULT_COUNT
+    for i := 2; i < len(args); i += 2 {
+        if i + 1 >= len(args) {
+            c.wr.WriteError("ERR syntax error")
+            return
+        }
+        switch strings.ToLower(string(args[i])) {
+        case "match":
+            pattern = args[i + 1]
+        case "count":
+            count, err = strconv.Atoi(string(args[i + 1]))
+            if err != nil || count < 1 {
+                c.wr.WriteError("ERR value is not an integer or out of range")
+                return
+            }
+        default:
+            c.wr.WriteError("ERR syntax error")
+            return
+        }
+    }
+
+    var start []byte
+    if cursor != 0 {
+        var ok bool
+        start, ok = c.srv.loadCursor(cursor)
+        if !ok {
+            c.wr.WriteError("ERR invalid cursor")
+            return
+        }
+    }
+
+    keys := [][]byte{}
+    reader := c.srv.DB.BeginRead()
+    sc := reader.Scan(start, nil)
+    for i := 0; i < count && sc.Valid(); i++ {
+        if pattern == nil || globMatch(pattern, sc.Key()) {
+            keys = append(keys, append([]byte{}, sc.Key()...))
+        }
+        sc.Next()
+    }
+    next := uint64(0)
+    if sc.Valid() {
+        next = c.srv.saveCursor(append([]byte{}, sc.Key()...))
+    }
+    err = sc.Err()
+    reader.Close()
+    if err != nil {
+        c.writeErr(err)
+        return
+    }
+
+    c.wr.WriteArrayHeader(2)
+    c.wr.WriteBulk([]byte(strconv.FormatUint(next, 10)))
+    c.wr.WriteArrayHeader(len(keys))
+    for _, key := range keys {
+        c.wr.WriteBulk(key)
+    }
+}
+
+// remember where a scan continues, the oldest cursors are dropped first.
+// cursors are never removed otherwise, so the live ones are the last
+// len(cursors) ids.
+func (srv *Server) saveCursor(key []byte) uint64 {
+    srv.mu.Lock()
+    defer srv.mu.Unlock()
+    if srv.cursors == nil {
+        srv.cursors = map[uint64][]byte{}
+        srv.nextCursor = 1 // 0 ends the scan
+    }
+    if len(srv.cursors) >= SCAN_MAX_CURSORS {
+        delete(srv.cursors, srv.nextCursor - uint64(len(srv.cursors)))
+    }
+    id := srv.nextCursor
+    srv.nextCursor++
+    srv.cursors[id] = key
+    return id
+}
+
+func (srv *Server) loadCursor(id uint64) ([]byte, bool) {
+    srv.mu.Lock()
+    defer srv.mu.Unlock()
+    key, ok := srv.cursors[id]
+    return key, ok
+}
@@ -0,0 +1,263 @@
+package resp
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/connnorchen/MyDb/internal/kvstore"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestServer(t *testing.T) string {
+    db := &kvstore.KV{Path: filepath.Join(t.TempDir(), "db")}
+    assert.Nil(t, db.Open())
+    return serveTest(t, db)
+}
+
+// serve an open KV, it's closed with the server
+func serveTest(t *testing.T, db *kvstore.KV) string {
+    l, err := net.Listen("tcp", "127.0.0.1:0")
+    assert.Nil(t, err)
+    srv := &Server{DB: db}
+    done := make(chan error)
+    go func() { done <- srv.Serve(l) }()
+    t.Cleanup(func() {
+        assert.Nil(t, srv.Close())
+        assert.Nil(t, <-done)
+        db.Close()
+    })
+    return l.Addr().String()
+}
+
+type testClient struct {
+    t    *testing.T
+    conn net.Conn
+    rd   *bufio.Reader
+}
+
+func dial(t *testing.T, addr string) *testClient {
+    conn, err := net.Dial("tcp", addr)
+    assert.Nil(t, err)
+    t.Cleanup(func() { conn.Close() })
+    return &testClient{t: t, conn: conn, rd: bufio.NewReader(conn)}
+}
+
+func (c *testClient) send(args ...string) {
+    wr := NewWriter(c.conn)
+    wr.WriteArrayHeader(len(args))
+    for _, arg := range args {
+        wr.WriteBulk([]byte(arg))
+    }
+    assert.Nil(c.t, wr.Flush())
+}
+
+// a reply as string, int64, nil, []interface{}, or error for -ERR
+func (c *testClient) reply() interface{} {
+    line, err := c.rd.ReadString('\n')
+    if !assert.Nil(c.t, err) {
+        return nil
+    }
+    line = line[:len(line) - 2]
+    switch line[0] {
+    case '+':
+        return line[1:]
+    case '-':
+        return fmt.Errorf("%s", line[1:])
+    case ':':
+        n, _ := strconv.ParseInt(line[1:], 10, 64)
+        return n
+    case '$':
+        n, _ := strconv.Atoi(line[1:])
+        if n < 0 {
+            return nil
+        }
+        data := make([]byte, n + 2)
+        _, err := io.ReadFull(c.rd, data)
+        assert.Nil(c.t, err)
+        return string(data[:n])
+    case '*':
+        n, _ := strconv.Atoi(line[1:])
+        items := make([]interface{}, n)
+        for i := range items {
+            items[i] = c.reply()
+        }
+        return items
+    }
+    c.t.Fatalf("bad reply %q", line)
+    return nil
+}
+
+func (c *testClient) do(args ...string) interface{} {
+    c.send(args...)
+    return c.reply()
+}
+
+func TestServerCommands(t *testing.T) {
+    c := dial(t, newTestServer(t))
+    assert.Equal(t, c.do("PING"), "PONG")
+    assert.Equal(t, c.do("ping", "hi"), "hi")
+
+    assert.Equal(t, c.do("SET", "k1", "v1"), "OK")
+    assert.Equal(t, c.do("GET", "k1"), "v1")
+    assert.Equal(t, c.do("GET", "nope"), nil)
+
+    assert.Equal(t, c.do("MSET", "k2", "v2", "k3", "v3"), "OK")
+    assert.Equal(t, c.do("MGET", "k1", "nope", "k3"), []interface{}{"v1", nil, "v3"})
+    assert.Equal(t, c.do("EXISTS", "k1", "k2", "nope", "k1"), int64(3))
+
+    assert.Equal(t, c.do("DEL", "k1", "k2", "nope"), int64(2))
+    assert.Equal(t, c.do("EXISTS", "k1", "k2"), int64(0))
+
+    // large values go through overflow pages
+    big := string(make([]byte, 100000))
+    assert.Equal(t, c.do("SET", "big", big), "OK")
+    assert.Equal(t, c.do("GET", "big"), big)
+
+    // errors don't close the connection
+    assert.IsType(t, c.do("FOO"), fmt.Errorf(""))
+    assert.IsType(t, c.do("GET"), fmt.Errorf(""))
+    assert.IsType(t, c.do("MSET", "a", "b", "c"), fmt.Errorf(""))
+    assert.IsType(t, c.do("SET", "", "v"), fmt.Errorf(""))
+    assert.Equal(t, c.do("PING"), "PONG")
+
+    assert.Equal(t, c.do("QUIT"), "OK")
+    _, err := c.rd.ReadByte()
+    assert.NotNil(t, err)
+}
+
+func TestServerPipeline(t *testing.T) {
+    c := dial(t, newTestServer(t))
+    // inline commands as sent by telnet
+    _, err := c.conn.Write([]byte("SET a 1\r\nSET b 2\r\nGET a\r\nGET b\r\n"))
+    assert.Nil(t, err)
+    assert.Equal(t, c.reply(), "OK")
+    assert.Equal(t, c.reply(), "OK")
+    assert.Equal(t, c.reply(), "1")
+    assert.Equal(t, c.reply(), "2")
+
+    // a protocol error is reported before closing
+    _, err = c.conn.Write([]byte("*1\r\n$x\r\n"))
+    assert.Nil(t, err)
+    assert.IsType(t, c.reply(), fmt.Errorf(""))
+    _, err = c.rd.ReadByte()
+    assert.NotNil(t, err)
+}
+
+func TestServerScan(t *testing.T) {
+    addr := newTestServer(t)
+    c := dial(t, addr)
+    for i := 0; i < 25; i++ {
+        assert.Equal(t, c.do("SET", fmt.Sprintf("user:%02d", i), "v"), "OK")
+        assert.Equal(t, c.do("SET", fmt.Sprintf("item:%02d", i), "v"), "OK")
+    }
+
+    scanAll := func(args ...string) []interface{} {
+        keys := []interface{}{}
+        cursor := "0"
+        for {
+            reply := c.do(append([]string{"SCAN", cursor}, args...)...).([]interface{})
+            keys = append(keys, reply[1].([]interface{})...)
+            cursor = reply[0].(string)
+            if cursor == "0" {
+                return keys
+            }
+        }
+    }
+    keys := scanAll()
+    assert.Equal(t, len(keys), 50)
+    assert.Equal(t, keys[0], "item:00")
+    assert.Equal(t, keys[49], "user:24")
+
+    keys = scanAll("MATCH", "user:1*", "COUNT", "7")
+    assert.Equal(t, len(keys), 10)
+    assert.Equal(t, keys[0], "user:10")
+
+    // cursors can be retried and used from another connection
+    reply := c.do("SCAN", "0", "COUNT", "10").([]interface{})
+    cursor := reply[0].(string)
+    next := func(c *testClient) []interface{} {
+        return c.do("SCAN", cursor, "COUNT", "10").([]interface{})[1].([]interface{})
+    }
+    keys = next(c)
+    assert.Equal(t, keys[0], "item:10")
+    assert.Equal(t, next(c), keys)
+    assert.Equal(t, next(dial(t, addr)), keys)
+
+    assert.IsType(t, c.do("SCAN", "12345"), fmt.Errorf(""))
+    assert.IsType(t, c.do("SCAN", "0", "COUNT", "0"), fmt.Errorf(""))
+    assert.IsType(t, c.do("SCAN", "0", "TYPE", "string"), fmt.Errorf(""))
+}
+
+func TestServerScanExpired(t *testing.T) {
+    db := &kvstore.KV{Path: filepath.Join(t.TempDir(), "db"), TTLSweepInterval: -1}
+    assert.Nil(t, db.Open())
+    for i := 0; i < 10; i++ {
+        key := []byte(fmt.Sprintf("k%d", i))
+        if i % 2 == 1 {
+            assert.Nil(t, db.SetWithTTL(key, []byte("v"), time.Millisecond))
+        } else {
+            assert.Nil(t, db.Set(key, []byte("v")))
+        }
+    }
+    time.Sleep(10 * time.Millisecond)
+    c := dial(t, serveTest(t, db))
+
+    // not swept yet, but skipped like for GET
+    assert.Nil(t, c.do("GET", "k1"))
+    reply := c.do("SCAN", "0", "COUNT", "3").([]interface{})
+    assert.Equal(t, reply[1], []interface{}{"k0", "k2", "k4"})
+    reply = c.do("SCAN", reply[0].(string)).([]interface{})
+    assert.Equal(t, reply, []interface{}{"0", []interface{}{"k6", "k8"}})
+}
+
+func TestServerScanCursorEviction(t *testing.T) {
+    srv := &Server{}
+    first := srv.saveCursor([]byte("k0"))
+    assert.NotEqual(t, first, uint64(0))
+    for i := 1; i <= SCAN_MAX_CURSORS; i++ {
+        srv.saveCursor([]byte(fmt.Sprintf("k%d", i)))
+    }
+    assert.Len(t, srv.cursors, SCAN_MAX_CURSORS)
+    _, ok := srv.loadCursor(first)
+    assert.False(t, ok)
+    key, ok := srv.loadCursor(first + 1)
+    assert.True(t, ok)
+    assert.Equal(t, key, []byte("k1"))
+}
+
+func TestServerConcurrent(t *testing.T) {
+    addr := newTestServer(t)
+    const nclients = 8
+    const nkeys = 50
+    var wg sync.WaitGroup
+    for i := 0; i < nclients; i++ {
+        wg.Add(1)
+        go func(i int) {
+            defer wg.Done()
+            c := dial(t, addr)
+            for j := 0; j < nkeys; j++ {
+                key := fmt.Sprintf("c%d:%d", i, j)
+                assert.Equal(t, c.do("SET", key, key), "OK")
+                assert.Equal(t, c.do("GET", key), key)
+                // a shared counter-like key written by everyone
+                assert.Equal(t, c.do("MSET", "shared", key, key + ":m", "x"), "OK")
+            }
+        }(i)
+    }
+    wg.Wait()
+
+    c := dial(t, addr)
+    for i := 0; i < nclients; i++ {
+        for j := 0; j < nkeys; j++ {
+            key := fmt.Sprintf("c%d:%d", i, j)
+            assert.Equal(t, c.do("EXISTS", key, key + ":m"), int64(2))
+        }
+    }
+}
@@ -0,0 +1,324 @@
+package table
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/connnorchen/MyDb/internal/b_tree"
+	"github.com/connnorchen/MyDb/internal/kvstore"
+)
+
+// the tables of a KV store, which must be open
+type DB struct {
+    KV *kvstore.KV
+}
+
+// a read-write transaction, see kvstore.KVTX
+type TX struct {
+    kv *kvstore.KVTX
+}
+
+// a read-only snapshot, see kvstore.KVReader
+type Reader struct {
+    kv *kvstore.KVReader
+}
+
+// where the buckets come from, a transaction or a snapshot
+type buckets interface {
+    Bucket(name []byte) (*kvstore.Bucket, error)
+}
+
+func (db *DB) Begin() *TX {
+    return &TX{kv: db.KV.Begin()}
+}
+
+func (tx *TX) Commit() error {
+    return tx.kv.Commit()
+}
+
+func (tx *TX) Abort() {
+    tx.kv.Abort()
+}
+
+func (db *DB) BeginRead() *Reader {
+    return &Reader{kv: db.KV.BeginRead()}
+}
+
+func (reader *Reader) Close() {
+    reader.kv.Close()
+}
+
+// run `fn` in a transaction, commit if it succeeds
+func (db *DB) update(fn func(tx *TX) error) error {
+    tx := db.Begin()
+    if err := fn(tx); err != nil {
+        tx.Abort()
+        return err
+    }
+    return tx.Commit()
+}
+
+// read the definition of a table from the catalog
+func getTableDef(src buckets, name string) (*TableDef, error) {
+    schema, err := src.Bucket([]byte(SCHEMA_BUCKET))
+    if errors.Is(err, kvstore.ErrBucketNotFound) {
+        return nil, fmt.Errorf("%w: %s", ErrTableNotFound, name)
+    }
+    if err != nil {
+        return nil, err
+    }
+    val, ok, err := schema.Get([]byte(name))
+    if err != nil {
+        return nil, err
+    }
+    if !ok {
+        return nil, fmt.Errorf("%w: %s", ErrTableNotFound, name)
+    }
+    def := &TableDef{}
+    if err := json.Unmarshal(val, def); err != nil {
+        return nil, fmt.Errorf("%w: table %s: %v", ErrBadSchema, name, err)
+    }
+    if err := def.check(); err != nil {
+        return nil, err
+    }
+    return def, nil
+}
+
+func tableBucket(src buckets, def *TableDef) (*kvstore.Bucket, error) {
+    return src.Bucket([]byte(TABLE_BUCKET_PREFIX + def.Name))
+}
+
+func (tx *TX) TableDef(name string) (*TableDef, error) {
+    return getTableDef(tx.kv, name)
+}
+
+func (reader *Reader) TableDef(name string) (*TableDef, error) {
+    return getTableDef(reader.kv, name)
+}
+
+// the prefixes of the indexes in `def` are assigned
+func (tx *TX) CreateTable(def *TableDef) error {
+    for i := range def.Indexes {
+        def.Indexes[i].Prefix = uint32(ROWS_PREFIX + 1 + i)
+    }
+    if err := def.check(); err != nil {
+        return err
+    }
+    schema, err := tx.kv.Bucket([]byte(SCHEMA_BUCKET))
+    if errors.Is(err, kvstore.ErrBucketNotFound) {
+        // the first table
+        if err = tx.kv.CreateBucket([]byte(SCHEMA_BUCKET)); err == nil {
+            schema, err = tx.kv.Bucket([]byte(SCHEMA_BUCKET))
+        }
+    }
+    if err != nil {
+        return err
+    }
+    val, err := json.Marshal(def)
+    if err != nil {
+        return err
+    }
+    _, err = schema.SetEx([]byte(def.Name), val, b_tree.MODE_INSERT_ONLY)
+    if errors.Is(err, b_tree.ErrKeyExists) {
+        return fmt.Errorf("%w: %s", ErrTableExists, def.Name)
+    }
+    if err != nil {
+        return err
+    }
+    err = tx.kv.CreateBucket([]byte(TABLE_BUCKET_PREFIX + def.Name))
+    if errors.Is(err, kvstore.ErrBucketExists) {
+        return fmt.Errorf("%w: bucket of %s", ErrTableExists, def.Name)
+    }
+    return err
+}
+
+// delete a table with its rows
+func (tx *TX) DropTable(name string) error {
+    def, err := tx.TableDef(name)
+    if err != nil {
+        return err
+    }
+    schema, err := tx.kv.Bucket([]byte(SCHEMA_BUCKET))
+    if err != nil {
+        return err
+    }
+    if _, err := schema.Del([]byte(def.Name)); err != nil {
+        return err
+    }
+    return tx.kv.DropBucket([]byte(TABLE_BUCKET_PREFIX + def.Name))
+}
+
+// look up a row by its primary key, `rec` holds the primary key columns and
+// gets all the columns if the row is found
+func dbGet(src buckets, table string, rec *Record) (bool, error) {
+    def, err := getTableDef(src, table)
+    if err != nil {
+        return false, err
+    }
+    vals, err := checkRecord(def, *rec, def.PKeys)
+    if err != nil {
+        return false, err
+    }
+    b, err := tableBucket(src, def)
+    if err != nil {
+        return false, err
+    }
+    val, ok, err := b.Get(encodeKey(ROWS_PREFIX, vals))
+    if err != nil || !ok {
+        return false, err
+    }
+    rest, err := decodeValues(val, def.Types[def.PKeys:])
+    if err != nil {
+        return false, err
+    }
+    rec.Cols = append([]string(nil), def.Cols...)
+    rec.Vals = append(vals, rest...)
+    return true, nil
+}
+
+func (tx *TX) Get(table string, rec *Record) (bool, error) {
+    return dbGet(tx.kv, table, rec)
+}
+
+func (reader *Reader) Get(table string, rec *Record) (bool, error) {
+    return dbGet(reader.kv, table, rec)
+}
+
+// store a complete row depending on the mode, see BTree.InsertEx
+func (tx *TX) update(table string, rec Record, mode int) (bool, error) {
+    def, err := tx.TableDef(table)
+    if err != nil {
+        return false, err
+    }
+    vals, err := checkRecord(def, rec, len(def.Cols))
+    if err != nil {
+        return false, err
+    }
+    b, err := tableBucket(tx.kv, def)
+    if err != nil {
+        return false, err
+    }
+    key := encodeKey(ROWS_PREFIX, vals[:def.PKeys])
+    val := encodeValues(nil, vals[def.PKeys:])
+    res, err := b.SetEx(key, val, mode)
+    switch {
+    case errors.Is(err, b_tree.ErrKeyExists):
+        return false, fmt.Errorf("%w: table %s", ErrRowExists, table)
+    case errors.Is(err, b_tree.ErrKeyNotFound):
+        return false, fmt.Errorf("%w: table %s", ErrRowNotFound, table)
+    case err != nil:
+        return false, err
+    }
+    var old []Value
+    if res.Updated {
+        if old, err = decodeRow(def, key, res.Old); err != nil {
+            return false, err
+        }
+    }
+    return res.Added, updateIndexes(b, def, old, vals)
+}
+
+// add a new row, ErrRowExists if the primary key exists
+func (tx *TX) Insert(table string, rec Record) error {
+    _, err := tx.update(table, rec, b_tree.MODE_INSERT_ONLY)
+    return err
+}
+
+// replace an existing row, ErrRowNotFound if the primary key doesn't exist
+func (tx *TX) Update(table string, rec Record) error {
+    _, err := tx.update(table, rec, b_tree.MODE_UPDATE_ONLY)
+    return err
+}
+
+// insert or replace a row, returns whether it's a new row
+func (tx *TX) Upsert(table string, rec Record) (bool, error) {
+    return tx.update(table, rec, b_tree.MODE_UPSERT)
+}
+
+// delete a row by its primary key
+func (tx *TX) Delete(table string, rec Record) (bool, error) {
+    def, err := tx.TableDef(table)
+    if err != nil {
+        return false, err
+    }
+    vals, err := checkRecord(def, rec, def.PKeys)
+    if err != nil {
+        return false, err
+    }
+    b, err := tableBucket(tx.kv, def)
+    if err != nil {
+        return false, err
+    }
+    key := encodeKey(ROWS_PREFIX, vals)
+    val, ok, err := b.Get(key)
+    if err != nil || !ok {
+        return false, err
+    }
+    // the old row is needed for the index entries
+    old, err := decodeRow(def, key, val)
+    if err != nil {
+        return false, err
+    }
+    if _, err := b.Del(key); err != nil {
+        return false, err
+    }
+    return true, updateIndexes(b, def, old, nil)
+}
+
+// all the columns of a row in the table order
+func decodeRow(def *TableDef, key []byte, val []byte) ([]Value, error) {
+    if len(key) < 4 {
+        return nil, fmt.Errorf("%w: short key", ErrCorruptRow)
+    }
+    keys, err := decodeValues(key[4:], def.Types[:def.PKeys])
+    if err != nil {
+        return nil, err
+    }
+    rest, err := decodeValues(val, def.Types[def.PKeys:])
+    if err != nil {
+        return nil, err
+    }
+    return append(keys, rest...), nil
+}
+
+// the operations below run in a transaction of their own
+
+func (db *DB) CreateTable(def *TableDef) error {
+    return db.update(func(tx *TX) error { return tx.CreateTable(def) })
+}
+
+func (db *DB) DropTable(name string) error {
+    return db.update(func(tx *TX) error { return tx.DropTable(name) })
+}
+
+// look up a row in the last commit, see TX.Get
+func (db *DB) Get(table string, rec *Record) (bool, error) {
+    reader := db.BeginRead()
+    defer reader.Close()
+    return reader.Get(table, rec)
+}
+
+func (db *DB) Insert(table string, rec Record) error {
+    return db.update(func(tx *TX) error { return tx.Insert(table, rec) })
+}
+
+func (db *DB) Update(table string, rec Record) error {
+    return db.update(func(tx *TX) error { return tx.Update(table, rec) })
+}
+
+func (db *DB) Upsert(table string, rec Record) (added bool, err error) {
+    err = db.update(func(tx *TX) error {
+        added, err = tx.Upsert(table, rec)
+        return err
+    })
+    return added, err
+}
+
+func (db *DB) Delete(table string, rec Record) (deleted bool, err error) {
+    err = db.update(func(tx *TX) error {
+        deleted, err = tx.Delete(table, rec)
+        return err
+    })
+    return deleted, err
+}
@@ -0,0 +1,113 @@
+package table
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+// order-preserving encoding of column values, comparing encoded tuples with
+// bytes.Compare gives the same order as comparing them column by column.
+// the types are not encoded, the schema provides them.
+// int64:         8B big-endian with the sign bit flipped
+// bytes, string: escaped and terminated by 0x00, the escaping keeps the
+//                order and removes the 0x00 from the content:
+//                0x00 -> 0x01 0x01, 0x01 -> 0x01 0x02
+// a tuple is less than the longer tuples starting with it.
+
+func encodeValues(out []byte, vals []Value) []byte {
+    for _, v := range vals {
+        switch v.Type {
+        case TYPE_INT64:
+            var buf [8]byte
+            binary.BigEndian.PutUint64(buf[:], uint64(v.I64) ^ (1 << 63))
+            out = append(out, buf[:]...)
+        case TYPE_BYTES, TYPE_STRING:
+            out = escapeString(out, v.Str)
+            out = append(out, 0)
+        default:
+            panic("unknown value type")
+        }
+    }
+    return out
+}
+
+func escapeString(out []byte, in []byte) []byte {
+    for _, ch := range in {
+        if ch <= 1 {
+            out = append(out, 0x01, ch + 1)
+        } else {
+            out = append(out, ch)
+        }
+    }
+    return out
+}
+
+// decode a tuple of the given types, the input must be consumed entirely.
+// the decoded strings don't share the input.
+func decodeValues(in []byte, types []uint32) ([]Value, error) {
+    vals := make([]Value, 0, len(types))
+    for _, typ := range types {
+        v := Value{Type: typ}
+        switch typ {
+        case TYPE_INT64:
+            if len(in) < 8 {
+                return nil, fmt.Errorf("%w: truncated int64", ErrCorruptRow)
+            }
+            v.I64 = int64(binary.BigEndian.Uint64(in) ^ (1 << 63))
+            in = in[8:]
+        case TYPE_BYTES, TYPE_STRING:
+            end := bytes.IndexByte(in, 0)
+            if end < 0 {
+                return nil, fmt.Errorf("%w: unterminated string", ErrCorruptRow)
+            }
+            str, err := unescapeString(in[:end])
+            if err != nil {
+                return nil, err
+            }
+            v.Str = str
+            in = in[end + 1:]
+        default:
+            return nil, fmt.Errorf("%w: unknown type %d", ErrCorruptRow, typ)
+        }
+        vals = append(vals, v)
+    }
+    if len(in) > 0 {
+        return nil, fmt.Errorf("%w: trailing data", ErrCorruptRow)
+    }
+    return vals, nil
+}
+
+func unescapeString(in []byte) ([]byte, error) {
+    out := make([]byte, 0, len(in))
+    for i := 0; i < len(in); i++ {
+        if in[i] != 0x01 {
+            out = append(out, in[i])
+            continue
+        }
+        if i + 1 >= len(in) || in[i + 1] < 1 || in[i + 1] > 2 {
+            return nil, fmt.Errorf("%w: bad escape", ErrCorruptRow)
+        }
+        out = append(out, in[i + 1] - 1)
+        i++
+    }
+    return out, nil
+}
+
+// a key in the bucket of a table: | prefix | tuple |
+//                                 |   4B   | ...   |
+func encodeKey(prefix uint32, vals []Value) []byte {
+    out := binary.BigEndian.AppendUint32(nil, prefix)
+    return encodeValues(out, vals)
+}
+
+// the smallest key greater than all keys starting with `prefix`, the
+// prefix of a table key is never all 0xff
+func prefixEnd(prefix []byte) []byte {
+    end := append([]byte(nil), prefix...)
+    for len(end) > 0 && end[len(end) - 1] == 0xff {
+        end = end[:len(end) - 1]
+    }
+    end[len(end) - 1]++
+    return end
+}
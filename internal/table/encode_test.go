@@ -0,0 +1,71 @@
+package table
+
+import (
+	"bytes"
+	"math"
+	"math/rand"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEncodeRoundTrip(t *testing.T) {
+    vals := []Value{
+        {Type: TYPE_INT64, I64: -5},
+        {Type: TYPE_BYTES, Str: []byte{0, 1, 2, 0xff, 0}},
+        {Type: TYPE_STRING, Str: []byte{}},
+        {Type: TYPE_INT64, I64: math.MaxInt64},
+    }
+    types := []uint32{TYPE_INT64, TYPE_BYTES, TYPE_STRING, TYPE_INT64}
+    data := encodeValues(nil, vals)
+    decoded, err := decodeValues(data, types)
+    assert.Nil(t, err)
+    assert.Equal(t, decoded, vals)
+
+    // damaged input
+    _, err = decodeValues(data[:len(data) - 1], types)
+    assert.ErrorIs(t, err, ErrCorruptRow)
+    _, err = decodeValues(append(data, 0), types)
+    assert.ErrorIs(t, err, ErrCorruptRow)
+    _, err = decodeValues([]byte{0x01, 0x07, 0}, []uint32{TYPE_BYTES})
+    assert.ErrorIs(t, err, ErrCorruptRow)
+}
+
+// the byte order of encoded tuples is the tuple order
+func TestEncodeOrder(t *testing.T) {
+    type tuple struct {
+        i int64
+        s []byte
+    }
+    less := func(a tuple, b tuple) bool {
+        if a.i != b.i {
+            return a.i < b.i
+        }
+        return bytes.Compare(a.s, b.s) < 0
+    }
+    rng := rand.New(rand.NewSource(1))
+    ints := []int64{math.MinInt64, -256, -1, 0, 1, 255, math.MaxInt64}
+    tuples := []tuple{}
+    for i := 0; i < 500; i++ {
+        s := make([]byte, rng.Intn(4))
+        for j := range s {
+            s[j] = []byte{0, 1, 2, 0xff}[rng.Intn(4)]
+        }
+        tuples = append(tuples, tuple{i: ints[rng.Intn(len(ints))], s: s})
+    }
+    sort.Slice(tuples, func(i, j int) bool { return less(tuples[i], tuples[j]) })
+    encode := func(tup tuple) []byte {
+        return encodeValues(nil, []Value{
+            {Type: TYPE_INT64, I64: tup.i}, {Type: TYPE_BYTES, Str: tup.s},
+        })
+    }
+    for i := 1; i < len(tuples); i++ {
+        cmp := bytes.Compare(encode(tuples[i - 1]), encode(tuples[i]))
+        if less(tuples[i - 1], tuples[i]) {
+            assert.Equal(t, cmp, -1)
+        } else {
+            assert.Equal(t, cmp, 0)
+        }
+    }
+}
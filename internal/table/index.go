@@ -0,0 +1,236 @@
+package table
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"github.com/connnorchen/MyDb/internal/kvstore"
+)
+
+// secondary indexes, the entries are keys in the bucket of the table:
+// | prefix | indexed columns | primary key columns | with an empty value.
+// the primary key columns already in the index are not repeated. each
+// entry is unique and points to the row, the index is ordered by the
+// indexed columns then by the primary key.
+// the entries are updated together with the rows, in the same transaction.
+
+// rows are read in batches when an index is created, the scanner can't be
+// used after the entries of a batch are added
+const INDEX_BACKFILL_BATCH = 1000
+
+type IndexDef struct {
+    Cols   []string
+    Prefix uint32 // the key prefix of the entries, assigned by the table
+}
+
+func (def *TableDef) checkIndex(cols []string) error {
+    if len(cols) == 0 {
+        return fmt.Errorf("%w: table %s: index without columns", ErrBadSchema, def.Name)
+    }
+    for i, col := range cols {
+        if def.colIndex(col) < 0 {
+            return fmt.Errorf("%w: table %s: index on unknown column %q", ErrBadSchema, def.Name, col)
+        }
+        for _, other := range cols[:i] {
+            if other == col {
+                return fmt.Errorf("%w: table %s: index on column %q twice", ErrBadSchema, def.Name, col)
+            }
+        }
+    }
+    return nil
+}
+
+func sameCols(a []string, b []string) bool {
+    if len(a) != len(b) {
+        return false
+    }
+    for i := range a {
+        if a[i] != b[i] {
+            return false
+        }
+    }
+    return true
+}
+
+// nil if there is no index on these columns in this order
+func (def *TableDef) findIndex(cols []string) *IndexDef {
+    for i := range def.Indexes {
+        if sameCols(def.Indexes[i].Cols, cols) {
+            return &def.Indexes[i]
+        }
+    }
+    return nil
+}
+
+// an unused prefix for a new index
+func (def *TableDef) nextPrefix() uint32 {
+    prefix := uint32(ROWS_PREFIX)
+    for _, index := range def.Indexes {
+        if index.Prefix > prefix {
+            prefix = index.Prefix
+        }
+    }
+    return prefix + 1
+}
+
+// the positions of the columns in the keys of an index
+func indexCols(def *TableDef, index *IndexDef) []int {
+    cols := []int{}
+    for _, col := range index.Cols {
+        cols = append(cols, def.colIndex(col))
+    }
+    for i := 0; i < def.PKeys; i++ {
+        if !containsInt(cols, i) {
+            cols = append(cols, i)
+        }
+    }
+    return cols
+}
+
+func containsInt(list []int, x int) bool {
+    for _, v := range list {
+        if v == x {
+            return true
+        }
+    }
+    return false
+}
+
+// the entry of a row, `row` holds all the columns in the table order
+func indexKey(def *TableDef, index *IndexDef, row []Value) []byte {
+    vals := []Value{}
+    for _, pos := range indexCols(def, index) {
+        vals = append(vals, row[pos])
+    }
+    return encodeKey(index.Prefix, vals)
+}
+
+// the primary key of the row of an entry
+func decodeIndexKey(def *TableDef, index *IndexDef, key []byte) ([]Value, error) {
+    cols := indexCols(def, index)
+    types := []uint32{}
+    for _, pos := range cols {
+        types = append(types, def.Types[pos])
+    }
+    if len(key) < 4 {
+        return nil, fmt.Errorf("%w: short index entry", ErrCorruptRow)
+    }
+    vals, err := decodeValues(key[4:], types)
+    if err != nil {
+        return nil, err
+    }
+    pkey := make([]Value, def.PKeys)
+    for i, pos := range cols {
+        if pos < def.PKeys {
+            pkey[pos] = vals[i]
+        }
+    }
+    return pkey, nil
+}
+
+// replace the entries of a row, `old` or `row` is nil if the row is added
+// or deleted
+func updateIndexes(b *kvstore.Bucket, def *TableDef, old []Value, row []Value) error {
+    for i := range def.Indexes {
+        index := &def.Indexes[i]
+        var oldKey, newKey []byte
+        if old != nil {
+            oldKey = indexKey(def, index, old)
+        }
+        if row != nil {
+            newKey = indexKey(def, index, row)
+        }
+        if bytes.Equal(oldKey, newKey) {
+            continue // the indexed columns are unchanged
+        }
+        if oldKey != nil {
+            deleted, err := b.Del(oldKey)
+            if err != nil {
+                return err
+            }
+            if !deleted {
+                return fmt.Errorf("%w: table %s: missing index entry", ErrCorruptRow, def.Name)
+            }
+        }
+        if newKey != nil {
+            if err := b.Set(newKey, []byte{}); err != nil {
+                return err
+            }
+        }
+    }
+    return nil
+}
+
+// add an index to a table and fill it with the existing rows
+func (tx *TX) CreateIndex(table string, cols []string) error {
+    def, err := tx.TableDef(table)
+    if err != nil {
+        return err
+    }
+    if err := def.checkIndex(cols); err != nil {
+        return err
+    }
+    if def.findIndex(cols) != nil {
+        return fmt.Errorf("%w: table %s: %v", ErrIndexExists, table, cols)
+    }
+    index := IndexDef{Cols: append([]string(nil), cols...), Prefix: def.nextPrefix()}
+    def.Indexes = append(def.Indexes, index)
+    if err := tx.storeTableDef(def); err != nil {
+        return err
+    }
+    b, err := tableBucket(tx.kv, def)
+    if err != nil {
+        return err
+    }
+    return backfillIndex(b, def, &index)
+}
+
+// add the entries of the existing rows to a new index
+func backfillIndex(b *kvstore.Bucket, def *TableDef, index *IndexDef) error {
+    start := encodeKey(ROWS_PREFIX, nil)
+    end := encodeKey(ROWS_PREFIX + 1, nil)
+    for {
+        keys := [][]byte{}
+        sc := b.Scan(start, end)
+        for ; sc.Valid() && len(keys) < INDEX_BACKFILL_BATCH; sc.Next() {
+            row, err := decodeRow(def, sc.Key(), sc.Val())
+            if err != nil {
+                return err
+            }
+            keys = append(keys, indexKey(def, index, row))
+            start = append(append([]byte(nil), sc.Key()...), 0) // the next key
+        }
+        if err := sc.Err(); err != nil {
+            return err
+        }
+        for _, key := range keys {
+            if err := b.Set(key, []byte{}); err != nil {
+                return err
+            }
+        }
+        if len(keys) < INDEX_BACKFILL_BATCH {
+            return nil
+        }
+    }
+}
+
+// overwrite the definition of an existing table
+func (tx *TX) storeTableDef(def *TableDef) error {
+    if err := def.check(); err != nil {
+        return err
+    }
+    schema, err := tx.kv.Bucket([]byte(SCHEMA_BUCKET))
+    if err != nil {
+        return err
+    }
+    val, err := json.Marshal(def)
+    if err != nil {
+        return err
+    }
+    return schema.Set([]byte(def.Name), val)
+}
+
+func (db *DB) CreateIndex(table string, cols []string) error {
+    return db.update(func(tx *TX) error { return tx.CreateIndex(table, cols) })
+}
@@ -0,0 +1,145 @@
+package table
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// (id) -> (city, age)
+func peopleDef() *TableDef {
+    return &TableDef{
+        Name:  "people",
+        Types: []uint32{TYPE_INT64, TYPE_STRING, TYPE_INT64},
+        Cols:  []string{"id", "city", "age"},
+        PKeys: 1,
+        Indexes: []IndexDef{{Cols: []string{"city", "age"}}},
+    }
+}
+
+func person(id int64, city string, age int64) Record {
+    rec := Record{}
+    rec.AddInt64("id", id).AddStr("city", city).AddInt64("age", age)
+    return rec
+}
+
+func scanAll(t *testing.T, db *DB, table string, r Range) []Record {
+    reader := db.BeginRead()
+    defer reader.Close()
+    sc, err := reader.ScanRange(table, r)
+    assert.Nil(t, err)
+    rows := []Record{}
+    for ; sc.Valid(); sc.Next() {
+        rec := Record{}
+        assert.Nil(t, sc.Deref(&rec))
+        rows = append(rows, rec)
+    }
+    assert.Nil(t, sc.Err())
+    return rows
+}
+
+// the rows with the given city by the index, ordered by age
+func byCity(t *testing.T, db *DB, index []string, city string) []Record {
+    key := Record{}
+    key.AddStr("city", city)
+    return scanAll(t, db, "people", Range{Index: index, Start: &key, End: &key, EndIncl: true})
+}
+
+func TestIndexUpdates(t *testing.T) {
+    db := newTestDB(t)
+    assert.Nil(t, db.CreateTable(peopleDef()))
+    index := []string{"city", "age"}
+    assert.Nil(t, db.Insert("people", person(1, "oslo", 30)))
+    assert.Nil(t, db.Insert("people", person(2, "rome", 20)))
+    assert.Nil(t, db.Insert("people", person(3, "oslo", 25)))
+    assert.Equal(t, byCity(t, db, index, "oslo"), []Record{
+        person(3, "oslo", 25), person(1, "oslo", 30),
+    })
+
+    // moving a row updates the entries
+    assert.Nil(t, db.Update("people", person(1, "rome", 30)))
+    _, err := db.Upsert("people", person(3, "oslo", 26))
+    assert.Nil(t, err)
+    assert.Equal(t, byCity(t, db, index, "oslo"), []Record{person(3, "oslo", 26)})
+    assert.Equal(t, byCity(t, db, index, "rome"), []Record{
+        person(2, "rome", 20), person(1, "rome", 30),
+    })
+    key := Record{}
+    key.AddInt64("id", 2)
+    deleted, err := db.Delete("people", key)
+    assert.Nil(t, err)
+    assert.True(t, deleted)
+    assert.Equal(t, byCity(t, db, index, "rome"), []Record{person(1, "rome", 30)})
+
+    // a failed insert leaves the entries alone
+    assert.ErrorIs(t, db.Insert("people", person(1, "oslo", 1)), ErrRowExists)
+    assert.Equal(t, byCity(t, db, index, "oslo"), []Record{person(3, "oslo", 26)})
+
+    // a range of the index: rome with age > 20, then the cities after oslo
+    lo, hi := Record{}, Record{}
+    lo.AddStr("city", "rome").AddInt64("age", 20)
+    hi.AddStr("city", "rome")
+    rows := scanAll(t, db, "people", Range{
+        Index: index, Start: &lo, StartExcl: true, End: &hi, EndIncl: true,
+    })
+    assert.Equal(t, rows, []Record{person(1, "rome", 30)})
+    lo = Record{}
+    lo.AddStr("city", "oslo")
+    rows = scanAll(t, db, "people", Range{Index: index, Start: &lo, StartExcl: true})
+    assert.Equal(t, rows, []Record{person(1, "rome", 30)})
+
+    // the rows are unaffected by the entries
+    rows = scanAll(t, db, "people", Range{})
+    assert.Equal(t, rows, []Record{person(1, "rome", 30), person(3, "oslo", 26)})
+    rows = scanAll(t, db, "people", Range{Index: index, Desc: true})
+    assert.Equal(t, rows, []Record{person(1, "rome", 30), person(3, "oslo", 26)})
+    rows = scanAll(t, db, "people", Range{Desc: true})
+    assert.Equal(t, rows, []Record{person(3, "oslo", 26), person(1, "rome", 30)})
+
+    reader := db.BeginRead()
+    _, err = reader.ScanRange("people", Range{Index: []string{"age"}})
+    reader.Close()
+    assert.ErrorIs(t, err, ErrIndexNotFound)
+}
+
+func TestIndexBackfill(t *testing.T) {
+    db := newTestDB(t)
+    def := peopleDef()
+    def.Indexes = nil
+    assert.Nil(t, db.CreateTable(def))
+    const n = 1500 // more than a batch
+    for i := 0; i < n; i += 250 {
+        tx := db.Begin()
+        for j := i; j < i + 250; j++ {
+            assert.Nil(t, tx.Insert("people", person(int64(j), fmt.Sprintf("c%d", j % 7), int64(j % 90))))
+        }
+        assert.Nil(t, tx.Commit())
+    }
+
+    assert.Nil(t, db.CreateIndex("people", []string{"age"}))
+    assert.ErrorIs(t, db.CreateIndex("people", []string{"age"}), ErrIndexExists)
+    assert.ErrorIs(t, db.CreateIndex("people", []string{"height"}), ErrBadSchema)
+    assert.Nil(t, db.CreateIndex("people", []string{"city"}))
+    db = reopen(t, db)
+
+    rows := scanAll(t, db, "people", Range{Index: []string{"age"}})
+    assert.Len(t, rows, n)
+    for i := 1; i < len(rows); i++ {
+        assert.LessOrEqual(t, rows[i - 1].Get("age").I64, rows[i].Get("age").I64)
+    }
+    rows = byCity(t, db, []string{"city"}, "c3")
+    assert.Len(t, rows, (n + 3) / 7)
+    for i, row := range rows {
+        // ordered by the primary key within the city
+        assert.Equal(t, row.Get("id").I64, int64(3 + 7 * i))
+    }
+
+    // the new indexes are maintained
+    assert.Nil(t, db.Update("people", person(3, "c0", 99)))
+    assert.Len(t, byCity(t, db, []string{"city"}, "c3"), (n + 3) / 7 - 1)
+    age := Record{}
+    age.AddInt64("age", 99)
+    rows = scanAll(t, db, "people", Range{Index: []string{"age"}, Start: &age})
+    assert.Equal(t, rows, []Record{person(3, "c0", 99)})
+}
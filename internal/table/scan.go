@@ -0,0 +1,150 @@
+package table
+
+import (
+	"fmt"
+
+	"github.com/connnorchen/MyDb/internal/kvstore"
+)
+
+// a range of rows by the primary key or by an index.
+// a bound holds the first few key columns, the key columns of an index are
+// the indexed columns followed by the primary key. a bound with fewer
+// columns covers all the keys starting with them, e.g. with the key (a, b),
+// Start = (a: 1) with End = (a: 1), EndIncl is the rows with a = 1.
+type Range struct {
+    Index []string // the columns of an index, nil for the primary key
+    Start *Record  // nil for no lower bound
+    End   *Record  // nil for no upper bound
+    StartExcl bool // exclude the keys starting with `Start`
+    EndIncl   bool // include the keys starting with `End`
+    Desc      bool // from the last key to the first
+}
+
+// range scan over the rows of a table in the key order or the reverse.
+// it's invalidated by any update of the table.
+type Scanner struct {
+    def   *TableDef
+    index *IndexDef // nil for the primary key
+    b     *kvstore.Bucket
+    sc    *kvstore.Scanner
+}
+
+func scanRange(src buckets, table string, r Range) (*Scanner, error) {
+    def, err := getTableDef(src, table)
+    if err != nil {
+        return nil, err
+    }
+    b, err := tableBucket(src, def)
+    if err != nil {
+        return nil, err
+    }
+    var index *IndexDef
+    prefix := uint32(ROWS_PREFIX)
+    cols := []int{}
+    for i := 0; i < def.PKeys; i++ {
+        cols = append(cols, i)
+    }
+    if r.Index != nil {
+        if index = def.findIndex(r.Index); index == nil {
+            return nil, fmt.Errorf("%w: table %s: %v", ErrIndexNotFound, table, r.Index)
+        }
+        prefix = index.Prefix
+        cols = indexCols(def, index)
+    }
+
+    lo := encodeKey(prefix, nil)
+    hi := encodeKey(prefix + 1, nil)
+    if r.Start != nil {
+        if lo, err = encodeBound(def, prefix, cols, *r.Start, r.StartExcl); err != nil {
+            return nil, err
+        }
+    }
+    if r.End != nil {
+        if hi, err = encodeBound(def, prefix, cols, *r.End, r.EndIncl); err != nil {
+            return nil, err
+        }
+    }
+    sc := &Scanner{def: def, index: index, b: b}
+    if r.Desc {
+        sc.sc = b.ScanDesc(lo, hi)
+    } else {
+        sc.sc = b.Scan(lo, hi)
+    }
+    return sc, nil
+}
+
+// a bound made of the first key columns, `after` moves it after the keys
+// starting with it
+func encodeBound(def *TableDef, prefix uint32, cols []int, rec Record, after bool) ([]byte, error) {
+    if len(rec.Cols) > len(cols) {
+        return nil, fmt.Errorf(
+            "%w: table %s: a bound has key columns only", ErrBadRecord, def.Name,
+        )
+    }
+    vals, err := checkCols(def, rec, cols[:len(rec.Cols)])
+    if err != nil {
+        return nil, err
+    }
+    key := encodeKey(prefix, vals)
+    if after {
+        key = prefixEnd(key)
+    }
+    return key, nil
+}
+
+// the rows whose primary key is in [start, end), see Range
+func (tx *TX) Scan(table string, start *Record, end *Record) (*Scanner, error) {
+    return scanRange(tx.kv, table, Range{Start: start, End: end})
+}
+
+func (reader *Reader) Scan(table string, start *Record, end *Record) (*Scanner, error) {
+    return scanRange(reader.kv, table, Range{Start: start, End: end})
+}
+
+func (tx *TX) ScanRange(table string, r Range) (*Scanner, error) {
+    return scanRange(tx.kv, table, r)
+}
+
+func (reader *Reader) ScanRange(table string, r Range) (*Scanner, error) {
+    return scanRange(reader.kv, table, r)
+}
+
+// within the range or not
+func (sc *Scanner) Valid() bool {
+    return sc.sc.Valid()
+}
+
+func (sc *Scanner) Next() {
+    sc.sc.Next()
+}
+
+// the error that stopped the scan, e.g. a corrupt page
+func (sc *Scanner) Err() error {
+    return sc.sc.Err()
+}
+
+// decode the current row, an index entry is followed to its row
+func (sc *Scanner) Deref(rec *Record) error {
+    def := sc.def
+    key, val := sc.sc.Key(), sc.sc.Val()
+    if sc.index != nil {
+        pkey, err := decodeIndexKey(def, sc.index, key)
+        if err != nil {
+            return err
+        }
+        key = encodeKey(ROWS_PREFIX, pkey)
+        var ok bool
+        if val, ok, err = sc.b.Get(key); err != nil {
+            return err
+        } else if !ok {
+            return fmt.Errorf("%w: table %s: index entry without a row", ErrCorruptRow, def.Name)
+        }
+    }
+    row, err := decodeRow(def, key, val)
+    if err != nil {
+        return err
+    }
+    rec.Cols = append([]string(nil), def.Cols...)
+    rec.Vals = row
+    return nil
+}
@@ -0,0 +1,190 @@
+package table
+
+import (
+	"errors"
+	"fmt"
+)
+
+// a relational layer on top of the KV store.
+// the definition of each table is stored as JSON in the `@schema` bucket,
+// keyed by the table name. the rows of a table are in the bucket
+// `@table/<name>`, the key is the primary key and the value holds the other
+// columns, both in the order-preserving encoding of encode.go, so rows are
+// ordered by the primary key.
+// each secondary index has its own key prefix in the bucket of the table,
+// see index.go.
+// buckets starting with `@` are reserved for the table layer.
+
+// column types
+const (
+    TYPE_INT64 = 1
+    TYPE_BYTES = 2
+    TYPE_STRING = 3 // same as bytes, for printing
+)
+
+const (
+    SCHEMA_BUCKET = "@schema"
+    TABLE_BUCKET_PREFIX = "@table/"
+    ROWS_PREFIX = 0 // the key prefix of the rows in the bucket of a table
+)
+
+var (
+    ErrTableExists   = errors.New("table already exists")
+    ErrTableNotFound = errors.New("table not found")
+    ErrBadSchema     = errors.New("bad table definition")
+    ErrBadRecord     = errors.New("bad record")
+    ErrRowExists     = errors.New("row already exists")
+    ErrRowNotFound   = errors.New("row not found")
+    ErrCorruptRow    = errors.New("corrupt row")
+    ErrIndexExists   = errors.New("index already exists")
+    ErrIndexNotFound = errors.New("index not found")
+)
+
+// a column value
+type Value struct {
+    Type uint32
+    I64  int64
+    Str  []byte // TYPE_BYTES and TYPE_STRING
+}
+
+// a row or a part of it, the columns can be in any order
+type Record struct {
+    Cols []string
+    Vals []Value
+}
+
+func (rec *Record) AddInt64(col string, val int64) *Record {
+    rec.Cols = append(rec.Cols, col)
+    rec.Vals = append(rec.Vals, Value{Type: TYPE_INT64, I64: val})
+    return rec
+}
+
+func (rec *Record) AddBytes(col string, val []byte) *Record {
+    rec.Cols = append(rec.Cols, col)
+    rec.Vals = append(rec.Vals, Value{Type: TYPE_BYTES, Str: val})
+    return rec
+}
+
+func (rec *Record) AddStr(col string, val string) *Record {
+    rec.Cols = append(rec.Cols, col)
+    rec.Vals = append(rec.Vals, Value{Type: TYPE_STRING, Str: []byte(val)})
+    return rec
+}
+
+// nil if the column is not in the record
+func (rec *Record) Get(col string) *Value {
+    for i, c := range rec.Cols {
+        if c == col {
+            return &rec.Vals[i]
+        }
+    }
+    return nil
+}
+
+type TableDef struct {
+    Name  string
+    Types []uint32
+    Cols  []string
+    PKeys int // the first PKeys columns are the primary key
+    Indexes []IndexDef
+}
+
+func (def *TableDef) check() error {
+    bad := func(format string, args ...interface{}) error {
+        return fmt.Errorf("%w: %s", ErrBadSchema, fmt.Sprintf(format, args...))
+    }
+    if def.Name == "" {
+        return bad("empty table name")
+    }
+    if len(def.Cols) == 0 || len(def.Types) != len(def.Cols) {
+        return bad("table %s: %d columns with %d types", def.Name, len(def.Cols), len(def.Types))
+    }
+    if def.PKeys < 1 || def.PKeys > len(def.Cols) {
+        return bad("table %s: %d primary key columns", def.Name, def.PKeys)
+    }
+    for i, col := range def.Cols {
+        if col == "" || def.colIndex(col) != i {
+            return bad("table %s: empty or duplicate column %q", def.Name, col)
+        }
+        if def.Types[i] < TYPE_INT64 || def.Types[i] > TYPE_STRING {
+            return bad("table %s: column %s has unknown type %d", def.Name, col, def.Types[i])
+        }
+    }
+    for i, index := range def.Indexes {
+        if err := def.checkIndex(index.Cols); err != nil {
+            return err
+        }
+        if index.Prefix == ROWS_PREFIX {
+            return bad("table %s: index %v has the prefix of the rows", def.Name, index.Cols)
+        }
+        for _, other := range def.Indexes[:i] {
+            if other.Prefix == index.Prefix || sameCols(other.Cols, index.Cols) {
+                return bad("table %s: duplicate index %v", def.Name, index.Cols)
+            }
+        }
+    }
+    return nil
+}
+
+// -1 if the column doesn't exist
+func (def *TableDef) colIndex(col string) int {
+    for i, c := range def.Cols {
+        if c == col {
+            return i
+        }
+    }
+    return -1
+}
+
+// the values of the first `n` columns of the table in the table order, the
+// record must contain exactly these columns
+func checkRecord(def *TableDef, rec Record, n int) ([]Value, error) {
+    cols := make([]int, n)
+    for i := range cols {
+        cols[i] = i
+    }
+    return checkCols(def, rec, cols)
+}
+
+// the values of the columns at the positions `cols` in this order, the
+// record must contain exactly these columns
+func checkCols(def *TableDef, rec Record, cols []int) ([]Value, error) {
+    if len(rec.Cols) != len(cols) || len(rec.Vals) != len(cols) {
+        return nil, fmt.Errorf(
+            "%w: table %s: expected %d columns, got %d",
+            ErrBadRecord, def.Name, len(cols), len(rec.Cols),
+        )
+    }
+    vals := make([]Value, len(cols))
+    set := make([]bool, len(cols))
+    for i, col := range rec.Cols {
+        idx := -1
+        for j, pos := range cols {
+            if def.Cols[pos] == col {
+                idx = j
+            }
+        }
+        if idx < 0 || set[idx] {
+            return nil, fmt.Errorf(
+                "%w: table %s: unexpected column %q", ErrBadRecord, def.Name, col,
+            )
+        }
+        typ := def.Types[cols[idx]]
+        if !sameType(rec.Vals[i].Type, typ) {
+            return nil, fmt.Errorf(
+                "%w: table %s: column %s has type %d, expected %d",
+                ErrBadRecord, def.Name, col, rec.Vals[i].Type, typ,
+            )
+        }
+        vals[idx] = rec.Vals[i]
+        vals[idx].Type = typ
+        set[idx] = true
+    }
+    return vals, nil
+}
+
+// bytes and strings are interchangeable
+func sameType(a uint32, b uint32) bool {
+    str := func(t uint32) bool { return t == TYPE_BYTES || t == TYPE_STRING }
+    return a == b || (str(a) && str(b))
+}
@@ -0,0 +1,196 @@
+package table
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/connnorchen/MyDb/internal/kvstore"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestDB(t *testing.T) *DB {
+    kv := &kvstore.KV{Path: filepath.Join(t.TempDir(), "db")}
+    assert.Nil(t, kv.Open())
+    t.Cleanup(kv.Close)
+    return &DB{KV: kv}
+}
+
+func reopen(t *testing.T, db *DB) *DB {
+    db.KV.Close()
+    kv := &kvstore.KV{Path: db.KV.Path}
+    assert.Nil(t, kv.Open())
+    t.Cleanup(kv.Close)
+    return &DB{KV: kv}
+}
+
+// (id, name) -> (age)
+var usersDef = &TableDef{
+    Name:  "users",
+    Types: []uint32{TYPE_INT64, TYPE_STRING, TYPE_INT64},
+    Cols:  []string{"id", "name", "age"},
+    PKeys: 2,
+}
+
+func user(id int64, name string, age int64) Record {
+    rec := Record{}
+    rec.AddInt64("id", id).AddStr("name", name).AddInt64("age", age)
+    return rec
+}
+
+func TestTableDef(t *testing.T) {
+    db := newTestDB(t)
+    assert.Nil(t, db.CreateTable(usersDef))
+    assert.ErrorIs(t, db.CreateTable(usersDef), ErrTableExists)
+
+    bad := []*TableDef{
+        {Name: "", Types: []uint32{TYPE_INT64}, Cols: []string{"a"}, PKeys: 1},
+        {Name: "t", Types: []uint32{TYPE_INT64}, Cols: []string{"a", "b"}, PKeys: 1},
+        {Name: "t", Types: []uint32{TYPE_INT64}, Cols: []string{"a"}, PKeys: 0},
+        {Name: "t", Types: []uint32{TYPE_INT64, TYPE_INT64}, Cols: []string{"a", "a"}, PKeys: 1},
+        {Name: "t", Types: []uint32{9}, Cols: []string{"a"}, PKeys: 1},
+    }
+    for _, def := range bad {
+        assert.ErrorIs(t, db.CreateTable(def), ErrBadSchema)
+    }
+
+    db = reopen(t, db)
+    reader := db.BeginRead()
+    def, err := reader.TableDef("users")
+    reader.Close()
+    assert.Nil(t, err)
+    assert.Equal(t, def, usersDef)
+
+    assert.Nil(t, db.DropTable("users"))
+    assert.ErrorIs(t, db.DropTable("users"), ErrTableNotFound)
+    _, err = db.Get("users", &Record{})
+    assert.ErrorIs(t, err, ErrTableNotFound)
+    assert.Nil(t, db.CreateTable(usersDef))
+}
+
+func TestTableRows(t *testing.T) {
+    db := newTestDB(t)
+    _, err := db.Get("users", &Record{})
+    assert.ErrorIs(t, err, ErrTableNotFound)
+    assert.Nil(t, db.CreateTable(usersDef))
+
+    assert.Nil(t, db.Insert("users", user(1, "ann", 30)))
+    assert.ErrorIs(t, db.Insert("users", user(1, "ann", 31)), ErrRowExists)
+    assert.ErrorIs(t, db.Update("users", user(2, "bob", 40)), ErrRowNotFound)
+    added, err := db.Upsert("users", user(2, "bob", 40))
+    assert.Nil(t, err)
+    assert.True(t, added)
+    added, err = db.Upsert("users", user(2, "bob", 41))
+    assert.Nil(t, err)
+    assert.False(t, added)
+    assert.Nil(t, db.Update("users", user(1, "ann", 31)))
+
+    // the columns of a record can be in any order
+    rec := Record{}
+    rec.AddStr("name", "ann").AddInt64("id", 1)
+    ok, err := db.Get("users", &rec)
+    assert.Nil(t, err)
+    assert.True(t, ok)
+    assert.Equal(t, rec, user(1, "ann", 31))
+
+    // bad records
+    rec = Record{}
+    rec.AddInt64("id", 1)
+    _, err = db.Get("users", &rec)
+    assert.ErrorIs(t, err, ErrBadRecord)
+    rec.AddInt64("name", 1)
+    _, err = db.Get("users", &rec)
+    assert.ErrorIs(t, err, ErrBadRecord)
+    rec = Record{}
+    rec.AddInt64("id", 3).AddStr("name", "x").AddInt64("height", 1)
+    assert.ErrorIs(t, db.Insert("users", rec), ErrBadRecord)
+
+    db = reopen(t, db)
+    rec = Record{}
+    rec.AddInt64("id", 2).AddStr("name", "bob")
+    ok, err = db.Get("users", &rec)
+    assert.Nil(t, err)
+    assert.True(t, ok)
+    assert.Equal(t, rec.Get("age").I64, int64(41))
+
+    key := Record{}
+    key.AddInt64("id", 2).AddStr("name", "bob")
+    deleted, err := db.Delete("users", key)
+    assert.Nil(t, err)
+    assert.True(t, deleted)
+    ok, err = db.Get("users", &key)
+    assert.Nil(t, err)
+    assert.False(t, ok)
+
+    // a failed operation aborts the transaction
+    tx := db.Begin()
+    assert.Nil(t, tx.Insert("users", user(5, "eve", 20)))
+    assert.ErrorIs(t, tx.Insert("users", user(1, "ann", 20)), ErrRowExists)
+    tx.Abort()
+    rec = Record{}
+    rec.AddInt64("id", 5).AddStr("name", "eve")
+    ok, err = db.Get("users", &rec)
+    assert.Nil(t, err)
+    assert.False(t, ok)
+}
+
+func TestTableScan(t *testing.T) {
+    db := newTestDB(t)
+    assert.Nil(t, db.CreateTable(usersDef))
+    other := &TableDef{
+        Name: "other", Types: []uint32{TYPE_INT64}, Cols: []string{"id"}, PKeys: 1,
+    }
+    assert.Nil(t, db.CreateTable(other))
+    tx := db.Begin()
+    for id := int64(-50); id < 50; id++ {
+        for _, name := range []string{"a", "b"} {
+            assert.Nil(t, tx.Insert("users", user(id, name, id + 100)))
+        }
+        rec := Record{}
+        assert.Nil(t, tx.Insert("other", *rec.AddInt64("id", id)))
+    }
+    assert.Nil(t, tx.Commit())
+
+    scan := func(start *Record, end *Record) []Record {
+        reader := db.BeginRead()
+        defer reader.Close()
+        sc, err := reader.Scan("users", start, end)
+        assert.Nil(t, err)
+        rows := []Record{}
+        for ; sc.Valid(); sc.Next() {
+            rec := Record{}
+            assert.Nil(t, sc.Deref(&rec))
+            rows = append(rows, rec)
+        }
+        assert.Nil(t, sc.Err())
+        return rows
+    }
+    rows := scan(nil, nil)
+    assert.Len(t, rows, 200)
+    // ordered by the primary key, negative numbers first
+    assert.Equal(t, rows[0], user(-50, "a", 50))
+    assert.Equal(t, rows[1], user(-50, "b", 50))
+    assert.Equal(t, rows[199], user(49, "b", 149))
+
+    // a bound with a part of the primary key
+    lo, hi := Record{}, Record{}
+    lo.AddInt64("id", -1)
+    hi.AddInt64("id", 2)
+    rows = scan(&lo, &hi)
+    assert.Len(t, rows, 6)
+    assert.Equal(t, rows[0], user(-1, "a", 99))
+    assert.Equal(t, rows[5], user(1, "b", 101))
+
+    lo = Record{}
+    lo.AddInt64("id", 48).AddStr("name", "b")
+    rows = scan(&lo, nil)
+    assert.Equal(t, rows, []Record{
+        user(48, "b", 148), user(49, "a", 149), user(49, "b", 149),
+    })
+
+    bad := Record{}
+    bad.AddInt64("id", 1).AddStr("name", "a").AddInt64("age", 1)
+    reader := db.BeginRead()
+    _, err := reader.Scan("users", &bad, nil)
+    reader.Close()
+    assert.ErrorIs(t, err, ErrBadRecord)
+}
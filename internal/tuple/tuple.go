@@ -0,0 +1,189 @@
+package tuple
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// order-preserving encoding of tuples, comparing encoded tuples with
+// bytes.Compare (as the B-tree does) gives the natural tuple order:
+// element by element, and a tuple is less than the longer tuples starting
+// with it. values of different types are ordered by their types in the
+// order of the tags below, e.g. nulls first.
+//
+// each element is a tag followed by the value:
+// null:          nothing
+// false, true:   nothing, the tag is the value
+// int64:         8B big-endian with the sign bit flipped
+// uint64:        8B big-endian
+// float64:       8B big-endian, the sign bit is flipped for positive numbers
+//                and all the bits for negative numbers. -0 is stored as 0,
+//                NaNs are after +Inf.
+// bytes, string: escaped and terminated by 0x00, the escaping keeps the
+//                order and removes the 0x00 from the content:
+//                0x00 -> 0x01 0x01, 0x01 -> 0x01 0x02
+// the tag 0 is not used, so that the end of a string is less than any
+// following element.
+
+const (
+    TAG_NULL = 1
+    TAG_FALSE = 2
+    TAG_TRUE = 3
+    TAG_INT64 = 4
+    TAG_UINT64 = 5
+    TAG_FLOAT64 = 6
+    TAG_BYTES = 7
+    TAG_STRING = 8
+)
+
+var (
+    ErrUnsupportedType = errors.New("unsupported tuple element type")
+    ErrCorrupt = errors.New("corrupt tuple")
+)
+
+// encode a tuple, see Append
+func Encode(vals ...interface{}) ([]byte, error) {
+    return Append(nil, vals...)
+}
+
+// append the encoded tuple to `out`. the elements are nil (null), bool,
+// int, int64, uint64, float64, string or []byte.
+func Append(out []byte, vals ...interface{}) ([]byte, error) {
+    var buf [8]byte
+    for _, v := range vals {
+        switch v := v.(type) {
+        case nil:
+            out = append(out, TAG_NULL)
+        case bool:
+            if v {
+                out = append(out, TAG_TRUE)
+            } else {
+                out = append(out, TAG_FALSE)
+            }
+        case int:
+            binary.BigEndian.PutUint64(buf[:], uint64(v) ^ (1 << 63))
+            out = append(append(out, TAG_INT64), buf[:]...)
+        case int64:
+            binary.BigEndian.PutUint64(buf[:], uint64(v) ^ (1 << 63))
+            out = append(append(out, TAG_INT64), buf[:]...)
+        case uint64:
+            binary.BigEndian.PutUint64(buf[:], v)
+            out = append(append(out, TAG_UINT64), buf[:]...)
+        case float64:
+            binary.BigEndian.PutUint64(buf[:], floatBits(v))
+            out = append(append(out, TAG_FLOAT64), buf[:]...)
+        case []byte:
+            out = appendString(append(out, TAG_BYTES), v)
+        case string:
+            out = appendString(append(out, TAG_STRING), []byte(v))
+        default:
+            return nil, fmt.Errorf("%w: %T", ErrUnsupportedType, v)
+        }
+    }
+    return out, nil
+}
+
+func floatBits(v float64) uint64 {
+    if v == 0 {
+        v = 0 // -0
+    }
+    bits := math.Float64bits(v)
+    if bits >> 63 != 0 {
+        return ^bits // negative, a larger magnitude is smaller
+    }
+    return bits | (1 << 63)
+}
+
+func floatFromBits(bits uint64) float64 {
+    if bits >> 63 != 0 {
+        return math.Float64frombits(bits &^ (1 << 63))
+    }
+    return math.Float64frombits(^bits)
+}
+
+func appendString(out []byte, in []byte) []byte {
+    for _, ch := range in {
+        if ch <= 1 {
+            out = append(out, 0x01, ch + 1)
+        } else {
+            out = append(out, ch)
+        }
+    }
+    return append(out, 0)
+}
+
+// decode a tuple, the elements are nil, bool, int64, uint64, float64, string
+// or []byte. the decoded strings and bytes don't share `data`.
+func Decode(data []byte) ([]interface{}, error) {
+    vals := []interface{}{}
+    for len(data) > 0 {
+        v, rest, err := DecodeOne(data)
+        if err != nil {
+            return nil, err
+        }
+        vals = append(vals, v)
+        data = rest
+    }
+    return vals, nil
+}
+
+// decode the first element of a tuple, returns the rest
+func DecodeOne(data []byte) (interface{}, []byte, error) {
+    if len(data) == 0 {
+        return nil, nil, fmt.Errorf("%w: empty", ErrCorrupt)
+    }
+    tag, data := data[0], data[1:]
+    switch tag {
+    case TAG_NULL:
+        return nil, data, nil
+    case TAG_FALSE, TAG_TRUE:
+        return tag == TAG_TRUE, data, nil
+    case TAG_INT64, TAG_UINT64, TAG_FLOAT64:
+        if len(data) < 8 {
+            return nil, nil, fmt.Errorf("%w: truncated number", ErrCorrupt)
+        }
+        bits := binary.BigEndian.Uint64(data)
+        switch tag {
+        case TAG_INT64:
+            return int64(bits ^ (1 << 63)), data[8:], nil
+        case TAG_UINT64:
+            return bits, data[8:], nil
+        default:
+            return floatFromBits(bits), data[8:], nil
+        }
+    case TAG_BYTES, TAG_STRING:
+        end := bytes.IndexByte(data, 0)
+        if end < 0 {
+            return nil, nil, fmt.Errorf("%w: unterminated string", ErrCorrupt)
+        }
+        str, err := unescapeString(data[:end])
+        if err != nil {
+            return nil, nil, err
+        }
+        if tag == TAG_STRING {
+            return string(str), data[end + 1:], nil
+        }
+        return str, data[end + 1:], nil
+    default:
+        return nil, nil, fmt.Errorf("%w: unknown tag %d", ErrCorrupt, tag)
+    }
+}
+
+func unescapeString(in []byte) ([]byte, error) {
+    out := make([]byte, 0, len(in))
+    for i := 0; i < len(in); i++ {
+        if in[i] != 0x01 {
+            out = append(out, in[i])
+            continue
+        }
+        if i + 1 >= len(in) || in[i + 1] < 1 || in[i + 1] > 2 {
+            return nil, fmt.Errorf("%w: bad escape", ErrCorrupt)
+        }
+        out = append(out, in[i + 1] - 1)
+        i++
+    }
+    return out, nil
+}
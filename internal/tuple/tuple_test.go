@@ -0,0 +1,170 @@
+package tuple
+
+import (
+	"bytes"
+	"math"
+	"math/rand"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRoundTrip(t *testing.T) {
+    vals := []interface{}{
+        nil, false, true, int64(-7), uint64(math.MaxUint64), -1.5, math.Inf(1),
+        []byte{0, 1, 2, 0xff}, "a\x00b", "", []byte{},
+    }
+    data, err := Encode(vals...)
+    assert.Nil(t, err)
+    decoded, err := Decode(data)
+    assert.Nil(t, err)
+    assert.Equal(t, decoded, vals)
+    // no 0x00 inside a string
+    data, _ = Encode("a\x00b\x01")
+    assert.Equal(t, data, []byte{TAG_STRING, 'a', 1, 1, 'b', 1, 2, 0})
+
+    // int is int64
+    data, err = Encode(5)
+    assert.Nil(t, err)
+    decoded, err = Decode(data)
+    assert.Nil(t, err)
+    assert.Equal(t, decoded, []interface{}{int64(5)})
+
+    // the decoded bytes are copies
+    data, _ = Encode([]byte("abc"))
+    decoded, _ = Decode(data)
+    data[1] = 'x'
+    assert.Equal(t, decoded[0], []byte("abc"))
+
+    _, err = Encode(int32(1))
+    assert.ErrorIs(t, err, ErrUnsupportedType)
+}
+
+func TestDecodeCorrupt(t *testing.T) {
+    data, _ := Encode(int64(1), "abc")
+    for _, bad := range [][]byte{
+        data[:5],                // truncated number
+        data[:len(data) - 1],    // unterminated string
+        {0},                     // unused tag
+        {TAG_BYTES, 0x01, 0x03, 0}, // bad escape
+        {TAG_BYTES, 0x01, 0},
+    } {
+        _, err := Decode(bad)
+        assert.ErrorIs(t, err, ErrCorrupt, "%x", bad)
+    }
+}
+
+func sign(less bool, greater bool) int {
+    switch {
+    case less:
+        return -1
+    case greater:
+        return 1
+    }
+    return 0
+}
+
+// the natural order: by type, then by value, shorter tuples first
+func compareTuples(a []interface{}, b []interface{}) int {
+    rank := func(v interface{}) int {
+        switch v := v.(type) {
+        case nil:
+            return 0
+        case bool:
+            if v {
+                return 2
+            }
+            return 1
+        case int64:
+            return 3
+        case uint64:
+            return 4
+        case float64:
+            return 5
+        case []byte:
+            return 6
+        default:
+            return 7
+        }
+    }
+    for i := 0; i < len(a) && i < len(b); i++ {
+        if ra, rb := rank(a[i]), rank(b[i]); ra != rb {
+            return ra - rb
+        }
+        cmp := 0
+        switch x := a[i].(type) {
+        case int64:
+            cmp = sign(x < b[i].(int64), x > b[i].(int64))
+        case uint64:
+            cmp = sign(x < b[i].(uint64), x > b[i].(uint64))
+        case float64:
+            cmp = sign(x < b[i].(float64), x > b[i].(float64))
+        case []byte:
+            cmp = bytes.Compare(x, b[i].([]byte))
+        case string:
+            cmp = bytes.Compare([]byte(x), []byte(b[i].(string)))
+        }
+        if cmp != 0 {
+            return cmp
+        }
+    }
+    return len(a) - len(b)
+}
+
+func randomElem(rng *rand.Rand) interface{} {
+    str := func() []byte {
+        s := make([]byte, rng.Intn(4))
+        for i := range s {
+            s[i] = []byte{0, 1, 2, 'a', 0xff}[rng.Intn(5)]
+        }
+        return s
+    }
+    switch rng.Intn(8) {
+    case 0:
+        return nil
+    case 1:
+        return rng.Intn(2) == 1
+    case 2:
+        return []int64{math.MinInt64, -256, -1, 0, 1, 255, math.MaxInt64}[rng.Intn(7)]
+    case 3:
+        return []uint64{0, 1, 255, 256, math.MaxUint64}[rng.Intn(5)]
+    case 4:
+        return []float64{math.Inf(-1), -1e300, -2.5, -1e-300, 0, 1e-300, 2.5, math.Inf(1)}[rng.Intn(8)]
+    case 5:
+        return str()
+    default:
+        return string(str())
+    }
+}
+
+func TestOrder(t *testing.T) {
+    rng := rand.New(rand.NewSource(1))
+    tuples := [][]interface{}{}
+    for i := 0; i < 2000; i++ {
+        tup := []interface{}{}
+        for j := rng.Intn(4); j > 0; j-- {
+            tup = append(tup, randomElem(rng))
+        }
+        tuples = append(tuples, tup)
+    }
+    sort.Slice(tuples, func(i, j int) bool {
+        return compareTuples(tuples[i], tuples[j]) < 0
+    })
+    for i := 1; i < len(tuples); i++ {
+        a, err := Encode(tuples[i - 1]...)
+        assert.Nil(t, err)
+        b, err := Encode(tuples[i]...)
+        assert.Nil(t, err)
+        expected := 0
+        if compareTuples(tuples[i - 1], tuples[i]) < 0 {
+            expected = -1
+        }
+        assert.Equal(t, bytes.Compare(a, b), expected, "%v %v", tuples[i - 1], tuples[i])
+    }
+
+    // -0 is 0
+    neg, _ := Encode(math.Copysign(0, -1))
+    pos, _ := Encode(0.0)
+    assert.Equal(t, neg, pos)
+}
@@ -1,74 +1,111 @@
 package main
 
 import (
+	"bufio"
 	"fmt"
+	"os"
+	"strings"
 
 	"github.com/connnorchen/MyDb/internal/kvstore"
+	"github.com/connnorchen/MyDb/internal/query"
+	"github.com/connnorchen/MyDb/internal/table"
 )
 
 const PATH string = "/Users/connor/proj/build-your-own-db/db"
 
+// a SQL shell, the statements end with `;` and can span lines.
+// the keys outside of tables are accessed by the commands below, a command
+// takes a single line without `;`:
+//   set <key> <val>
+//   get <key>
+//   del <key>
+// the database file is the first argument, PATH by default.
 func main() {
-    db := kvstore.KV{Path: PATH}
-    if err := db.Open(); err != nil {
+    path := PATH
+    if len(os.Args) > 1 {
+        path = os.Args[1]
+    }
+    kv := kvstore.KV{Path: path}
+    if err := kv.Open(); err != nil {
         fmt.Printf("err in open: %s\n", err.Error())
-        goto end
+        os.Exit(1)
     }
+    defer kv.Close()
+    db := &table.DB{KV: &kv}
+
+    in := bufio.NewScanner(os.Stdin)
+    stmt := ""
     for {
-        var op string
-        fmt.Println("what op?")
-        fmt.Scanln(&op)
-        switch (op) {
-        case "set":
-            set(&db)
-            break
-        case "get":
-            get(&db)
-            break
-        case "del":
-            del(&db)
-            break
+        if stmt == "" {
+            fmt.Print("db> ")
+        } else {
+            fmt.Print("... ")
+        }
+        if !in.Scan() {
+            fmt.Println()
+            return
+        }
+        if stmt == "" && command(&kv, strings.Fields(in.Text())) {
+            continue
+        }
+        stmt += in.Text() + "\n"
+        if strings.TrimSpace(stmt) == "" {
+            stmt = ""
+            continue
+        }
+        if !strings.HasSuffix(strings.TrimSpace(stmt), ";") {
+            continue
         }
+        res, err := query.Run(db, stmt)
+        stmt = ""
+        if err != nil {
+            fmt.Printf("error: %s\n", err.Error())
+            continue
+        }
+        fmt.Println(res.String())
     }
-    
-end:
-    db.Close()
-    fmt.Printf("error occured\n")
-    return
 }
 
-func set(db *kvstore.KV) {
-    fmt.Println("key: ")
-    var key string
-    fmt.Scanln(&key)
+// run a non-SQL command, returns false if the line is not one
+func command(db *kvstore.KV, args []string) bool {
+    if len(args) == 0 {
+        return false
+    }
+    switch {
+    case args[0] == "set" && len(args) == 3:
+        set(db, args[1], args[2])
+    case args[0] == "get" && len(args) == 2:
+        get(db, args[1])
+    case args[0] == "del" && len(args) == 2:
+        del(db, args[1])
+    default:
+        return false
+    }
+    return true
+}
 
-    fmt.Println("val: ")
-    var val string
-    fmt.Scanln(&val)
+func set(db *kvstore.KV, key string, val string) {
     if err := db.Set([]byte(key), []byte(val)); err != nil {
-        fmt.Printf("error in set, %s", err.Error())
+        fmt.Printf("error in set, %s\n", err.Error())
     }
 }
 
-func get(db *kvstore.KV) {
-    fmt.Println("key: ")
-    var key string
-    fmt.Scanln(&key)
-    val, found := db.Get([]byte(key))
-    if !found {
+func get(db *kvstore.KV, key string) {
+    val, found, err := db.Get([]byte(key))
+    if err != nil {
+        fmt.Printf("error in get, %s\n", err.Error())
+    } else if !found {
         fmt.Println("not found such key")
     } else {
         fmt.Printf("val is %s\n", val)
     }
 }
 
-func del(db *kvstore.KV) {
-    fmt.Println("key: ")
-    var key string
-    fmt.Scanln(&key)
+func del(db *kvstore.KV, key string) {
     deleted, err := db.Del([]byte(key))
     if err != nil {
-        fmt.Printf("error in del, %s", err.Error())
+        fmt.Printf("error in del, %s\n", err.Error())
+        return
     }
     fmt.Printf("del %t\n", deleted)
 }